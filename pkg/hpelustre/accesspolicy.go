@@ -0,0 +1,278 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// The access policy limits which namespaces and service accounts may publish
+// which Lustre filesystems and sub-dirs. It is read from a file, normally a
+// mounted ConfigMap, and is reloaded when the file changes. A publish is
+// allowed if any rule allows it, and denied if none does.
+//
+//	rules:
+//	  - namespaces: ["team-a"]
+//	    serviceAccounts: ["builder"]
+//	    filesystems: ["lushtx"]
+//	    pathPrefixes: ["projects/team-a"]
+type accessPolicy struct {
+	Rules []accessPolicyRule `json:"rules"`
+}
+
+type accessPolicyRule struct {
+	// Pod namespaces, or "*" for any namespace
+	Namespaces []string `json:"namespaces"`
+	// Service account names in those namespaces. Any service account if empty.
+	ServiceAccounts []string `json:"serviceAccounts,omitempty"`
+	// Filesystem names, such as "lushtx", which match the filesystem behind
+	// any MGS, filesystems of an MGS, such as "10.1.1.113@tcp:/lushtx" or
+	// "10.1.1.113@tcp:/*", or "*" for any filesystem
+	Filesystems []string `json:"filesystems"`
+	// Sub-dirs of the filesystem that may be published, along with everything
+	// below them. The whole filesystem, with or without a sub-dir, if empty.
+	PathPrefixes []string `json:"pathPrefixes,omitempty"`
+}
+
+// volumeAccess is the filesystem, and the sub-dir of it, that is published
+// for a volume.
+type volumeAccess struct {
+	mgs    string
+	fsName string
+	// Relative to the root of the filesystem, empty for the whole filesystem
+	subDir string
+}
+
+const accessPolicyWildcard = "*"
+
+func parseAccessPolicy(data []byte) (*accessPolicy, error) {
+	policy := &accessPolicy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, err
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if len(rule.Namespaces) == 0 {
+			return nil, fmt.Errorf("rule %d: namespaces must be given", i)
+		}
+		if len(rule.Filesystems) == 0 {
+			return nil, fmt.Errorf("rule %d: filesystems must be given", i)
+		}
+		for _, fs := range rule.Filesystems {
+			if err := checkPolicyFilesystem(fs); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+		}
+
+		for j, prefix := range rule.PathPrefixes {
+			prefix = strings.Trim(filepath.Clean("/"+prefix), "/")
+			if len(prefix) == 0 {
+				// The filesystem root allows everything.
+				rule.PathPrefixes = nil
+				break
+			}
+			rule.PathPrefixes[j] = prefix
+		}
+	}
+
+	return policy, nil
+}
+
+// checkPolicyFilesystem checks that a filesystem of a rule is a filesystem
+// name, with or without the NIDs of its MGS.
+func checkPolicyFilesystem(fs string) error {
+	if fs == accessPolicyWildcard {
+		return nil
+	}
+
+	fsName := fs
+	if strings.Contains(fs, ":/") {
+		var err error
+		if _, fsName, err = parseVolumeHandle(fs); err != nil {
+			return fmt.Errorf("filesystem %q is not of the form <mgs>:/<fsname>", fs)
+		}
+	}
+	if strings.ContainsAny(fsName, "/:") {
+		return fmt.Errorf("filesystem %q must not have a path, use pathPrefixes", fs)
+	}
+	return nil
+}
+
+// parseVolumeAccess returns the filesystem and sub-dir of a volume, whose
+// handle may itself name a directory of the filesystem. The sub-dir is the one
+// being published, or the sub-dir of the volume ID if empty.
+func parseVolumeAccess(volumeID, subDir string) (*volumeAccess, error) {
+	handle, idSubDir := splitVolumeID(volumeID)
+	mgs, fsPath, err := parseVolumeHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	if len(subDir) == 0 {
+		subDir = idSubDir
+	}
+
+	fsName, handlePath, _ := strings.Cut(fsPath, "/")
+	return &volumeAccess{
+		mgs:    mgs,
+		fsName: fsName,
+		subDir: strings.Trim(filepath.Clean("/"+filepath.Join(handlePath, subDir)), "/"),
+	}, nil
+}
+
+func matchesAny(values []string, value string) bool {
+	return slices.Contains(values, accessPolicyWildcard) || slices.Contains(values, value)
+}
+
+// allowsFilesystem reports whether the rule allows the filesystem behind the
+// MGS. A filesystem given by name alone is allowed behind any MGS.
+func (r *accessPolicyRule) allowsFilesystem(mgs, fsName string) bool {
+	for _, fs := range r.Filesystems {
+		if fs == accessPolicyWildcard {
+			return true
+		}
+
+		ruleMgs, ruleFsName := "", fs
+		if strings.Contains(fs, ":/") {
+			var err error
+			if ruleMgs, ruleFsName, err = parseVolumeHandle(fs); err != nil {
+				continue
+			}
+		}
+		if len(ruleMgs) != 0 && ruleMgs != mgs {
+			continue
+		}
+		if ruleFsName == accessPolicyWildcard || ruleFsName == fsName {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsPath reports whether the sub-dir, relative to the root of the
+// filesystem, is below one of the rule's prefixes. An empty sub-dir is the
+// whole filesystem.
+func (r *accessPolicyRule) allowsPath(subDir string) bool {
+	if len(r.PathPrefixes) == 0 {
+		return true
+	}
+	if len(subDir) == 0 {
+		return false
+	}
+
+	for _, prefix := range r.PathPrefixes {
+		if subDir == prefix || strings.HasPrefix(subDir, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *accessPolicy) allows(namespace, serviceAccount string, access *volumeAccess) bool {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !matchesAny(rule.Namespaces, namespace) {
+			continue
+		}
+		if len(rule.ServiceAccounts) != 0 && !matchesAny(rule.ServiceAccounts, serviceAccount) {
+			continue
+		}
+		if rule.allowsFilesystem(access.mgs, access.fsName) && rule.allowsPath(access.subDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadAccessPolicy returns the access policy, reading the policy file again if
+// it has changed since it was last read.
+func (d *Driver) loadAccessPolicy() (*accessPolicy, error) {
+	d.accessPolicyLock.Lock()
+	defer d.accessPolicyLock.Unlock()
+
+	info, err := os.Stat(d.accessPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	if d.accessPolicy != nil && info.ModTime().Equal(d.accessPolicyModTime) {
+		return d.accessPolicy, nil
+	}
+
+	data, err := os.ReadFile(d.accessPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := parseAccessPolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %w", d.accessPolicyFile, err)
+	}
+
+	klog.V(2).Infof("loaded access policy %q with %d rules", d.accessPolicyFile, len(policy.Rules))
+	d.accessPolicy = policy
+	d.accessPolicyModTime = info.ModTime()
+	return policy, nil
+}
+
+// checkAccessPolicy verifies that the pod that the volume is published for may
+// use the filesystem and sub-dir of the volume. The filesystem comes from the
+// handle that the volume was resolved to, as kubelet generates the IDs of
+// ephemeral volumes. Every denial is logged for auditing.
+func (d *Driver) checkAccessPolicy(volumeID string, vol *lustreVolume, subDir, target string, context map[string]string) error {
+	if len(d.accessPolicyFile) == 0 {
+		return nil
+	}
+
+	// Without a policy nothing is allowed.
+	policy, err := d.loadAccessPolicy()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not load access policy: %v", err)
+	}
+
+	namespace := context[podNamespaceKey]
+	serviceAccount := context[serviceAccountNameKey]
+	access, err := parseVolumeAccess(vol.id, subDir)
+	if err != nil {
+		klog.Warningf("access policy cannot check volume %s: %v", volumeID, err)
+	} else if len(namespace) != 0 && policy.allows(namespace, serviceAccount, access) {
+		return nil
+	}
+
+	klog.InfoS("Access policy denied volume publish",
+		"volumeID", volumeID,
+		"filesystem", vol.id,
+		"subDir", subDir,
+		"namespace", namespace,
+		"serviceAccount", serviceAccount,
+		"pod", context[podNameKey],
+		"targetPath", target,
+	)
+	return status.Errorf(codes.PermissionDenied,
+		"Access policy does not allow service account %q in namespace %q to use volume %s on %s sub-dir %q",
+		serviceAccount, namespace, volumeID, vol.id, subDir)
+}
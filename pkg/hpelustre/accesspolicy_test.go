@@ -0,0 +1,307 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseAccessPolicy(t *testing.T) {
+	tests := []struct {
+		desc   string
+		policy string
+		valid  bool
+	}{
+		{
+			desc:   "filesystem names",
+			policy: `{"rules": [{"namespaces": ["a"], "filesystems": ["lushtx", "*"]}]}`,
+			valid:  true,
+		},
+		{
+			desc:   "filesystems of an MGS",
+			policy: `{"rules": [{"namespaces": ["a"], "filesystems": ["10.1.1.113@tcp:/lushtx", "10.1.1.113@tcp:/*"]}]}`,
+			valid:  true,
+		},
+		{
+			desc:   "no namespaces",
+			policy: `{"rules": [{"filesystems": ["lushtx"]}]}`,
+		},
+		{
+			desc:   "no filesystems",
+			policy: `{"rules": [{"namespaces": ["a"]}]}`,
+		},
+		{
+			desc:   "filesystem with a path",
+			policy: `{"rules": [{"namespaces": ["a"], "filesystems": ["10.1.1.113@tcp:/lushtx/projects"]}]}`,
+		},
+		{
+			desc:   "filesystem name with a path",
+			policy: `{"rules": [{"namespaces": ["a"], "filesystems": ["lushtx/projects"]}]}`,
+		},
+		{
+			desc:   "filesystem without an MGS",
+			policy: `{"rules": [{"namespaces": ["a"], "filesystems": [":/lushtx"]}]}`,
+		},
+		{
+			desc:   "unknown field",
+			policy: `{"rules": [{"namespaces": ["a"], "filesystems": ["lushtx"], "paths": ["a"]}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := parseAccessPolicy([]byte(test.policy))
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyAllows(t *testing.T) {
+	policy, err := parseAccessPolicy([]byte(`
+rules:
+  - namespaces: ["team-a"]
+    serviceAccounts: ["builder"]
+    filesystems: ["lushtx"]
+    pathPrefixes: ["projects/team-a", "/shared/"]
+  - namespaces: ["team-b"]
+    filesystems: ["10.1.1.113@tcp:/scratch"]
+  - namespaces: ["team-c"]
+    filesystems: ["10.1.1.114@tcp:10.1.1.115@tcp:/*"]
+  - namespaces: ["*"]
+    filesystems: ["public"]
+    pathPrefixes: ["/"]
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc           string
+		namespace      string
+		serviceAccount string
+		volumeID       string
+		subDir         string
+		allowed        bool
+	}{
+		{
+			desc:           "sub-dir below prefix",
+			namespace:      "team-a",
+			serviceAccount: "builder",
+			volumeID:       "10.1.1.113@tcp:/lushtx",
+			subDir:         "projects/team-a/build",
+			allowed:        true,
+		},
+		{
+			desc:           "sub-dir of the volume ID below prefix",
+			namespace:      "team-a",
+			serviceAccount: "builder",
+			volumeID:       "10.1.1.113@tcp:/lushtx#shared/data",
+			allowed:        true,
+		},
+		{
+			desc:           "sub-dir of the volume ID outside of prefixes",
+			namespace:      "team-a",
+			serviceAccount: "builder",
+			volumeID:       "10.1.1.113@tcp:/lushtx#projects/team-b",
+		},
+		{
+			desc:           "path of the handle below prefix",
+			namespace:      "team-a",
+			serviceAccount: "builder",
+			volumeID:       "10.1.1.113@tcp:/lushtx/projects",
+			subDir:         "team-a",
+			allowed:        true,
+		},
+		{
+			desc:           "path of the handle outside of prefixes",
+			namespace:      "team-a",
+			serviceAccount: "builder",
+			volumeID:       "10.1.1.113@tcp:/lushtx/projects/team-b",
+		},
+		{
+			desc:           "sub-dir escaping prefix",
+			namespace:      "team-a",
+			serviceAccount: "builder",
+			volumeID:       "10.1.1.113@tcp:/lushtx",
+			subDir:         "projects/team-a/../team-b",
+		},
+		{
+			desc:           "whole filesystem with prefixes",
+			namespace:      "team-a",
+			serviceAccount: "builder",
+			volumeID:       "10.1.1.113@tcp:/lushtx",
+		},
+		{
+			desc:           "other service account",
+			namespace:      "team-a",
+			serviceAccount: "default",
+			volumeID:       "10.1.1.113@tcp:/lushtx",
+			subDir:         "projects/team-a",
+		},
+		{
+			desc:      "filesystem behind its MGS",
+			namespace: "team-b",
+			volumeID:  "10.1.1.113@tcp:/scratch#any",
+			allowed:   true,
+		},
+		{
+			desc:      "filesystem behind another MGS",
+			namespace: "team-b",
+			volumeID:  "10.1.1.200@tcp:/scratch",
+		},
+		{
+			desc:      "other filesystem behind the MGS",
+			namespace: "team-b",
+			volumeID:  "10.1.1.113@tcp:/lushtx",
+		},
+		{
+			desc:      "any filesystem behind failover MGS",
+			namespace: "team-c",
+			volumeID:  "10.1.1.114@tcp:10.1.1.115@tcp:/home",
+			allowed:   true,
+		},
+		{
+			desc:      "any filesystem behind one of failover MGS",
+			namespace: "team-c",
+			volumeID:  "10.1.1.114@tcp:/home",
+		},
+		{
+			desc:      "root prefix allows everything",
+			namespace: "other",
+			volumeID:  "10.1.1.113@tcp:/public",
+			allowed:   true,
+		},
+		{
+			desc:      "filesystem name does not match a path",
+			namespace: "other",
+			volumeID:  "10.1.1.113@tcp:/publicity",
+		},
+		{
+			desc:      "other namespace",
+			namespace: "other",
+			volumeID:  "10.1.1.113@tcp:/lushtx",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			access, err := parseVolumeAccess(test.volumeID, test.subDir)
+			require.NoError(t, err)
+			assert.Equal(t, test.allowed, policy.allows(test.namespace, test.serviceAccount, access))
+		})
+	}
+}
+
+func TestParseVolumeAccess(t *testing.T) {
+	access, err := parseVolumeAccess("10.1.1.113@tcp:10.1.1.114@tcp:/lushtx/projects#team-a", "")
+	require.NoError(t, err)
+	assert.Equal(t, &volumeAccess{
+		mgs:    "10.1.1.113@tcp:10.1.1.114@tcp",
+		fsName: "lushtx",
+		subDir: "projects/team-a",
+	}, access)
+
+	_, err = parseVolumeAccess("lushtx", "")
+	assert.Error(t, err)
+}
+
+func TestCheckAccessPolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(policyFile, []byte(`
+rules:
+  - namespaces: ["team-a"]
+    filesystems: ["10.1.1.113@tcp:/lushtx"]
+    pathPrefixes: ["scratch"]
+`), 0o600))
+	d := NewDriver(&DriverOptions{AccessPolicyFile: policyFile})
+
+	tests := []struct {
+		desc      string
+		volumeID  string
+		context   map[string]string
+		ephemeral bool
+		subDir    string
+		code      codes.Code
+	}{
+		{
+			desc:     "persistent volume",
+			volumeID: "10.1.1.113@tcp:/lushtx#scratch/a",
+			context:  map[string]string{podNamespaceKey: "team-a"},
+			subDir:   "scratch/a",
+		},
+		{
+			desc:     "persistent volume of another namespace",
+			volumeID: "10.1.1.113@tcp:/lushtx#scratch/a",
+			context:  map[string]string{podNamespaceKey: "team-b"},
+			subDir:   "scratch/a",
+			code:     codes.PermissionDenied,
+		},
+		{
+			desc:     "ephemeral volume",
+			volumeID: "csi-8f1c3bd2b9a7e4c5d6f0a1b2c3d4e5f60718293a4b5c6d7e8f9a0b1c2d3e4f5",
+			context: map[string]string{
+				podNamespaceKey:         "team-a",
+				ephemeralKey:            "true",
+				VolumeContextFilesystem: "10.1.1.113@tcp:/lushtx",
+				VolumeContextSubDir:     "scratch/${pod.metadata.uid}",
+			},
+			ephemeral: true,
+			subDir:    "scratch/0f3c",
+		},
+		{
+			desc:     "ephemeral volume outside the prefix",
+			volumeID: "csi-8f1c3bd2b9a7e4c5d6f0a1b2c3d4e5f60718293a4b5c6d7e8f9a0b1c2d3e4f5",
+			context: map[string]string{
+				podNamespaceKey:         "team-a",
+				ephemeralKey:            "true",
+				VolumeContextFilesystem: "10.1.1.113@tcp:/lushtx",
+				VolumeContextSubDir:     "home/${pod.metadata.uid}",
+			},
+			ephemeral: true,
+			subDir:    "home/0f3c",
+			code:      codes.PermissionDenied,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var vol *lustreVolume
+			var err error
+			if test.ephemeral {
+				vol, err = getEphemeralVolume(test.context)
+			} else {
+				vol, err = getPersistentVolume(test.volumeID, test.context)
+			}
+			require.NoError(t, err)
+
+			err = d.checkAccessPolicy(test.volumeID, vol, test.subDir, "/var/lib/kubelet/pods/0f3c/volumes/data", test.context)
+			assert.Equal(t, test.code, status.Code(err), "%v", err)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// PublishContextNodeID is set by ControllerPublishVolume to the node that
+	// owns the volume. The node plugin refuses to mount the volume anywhere else.
+	PublishContextNodeID = "lustre-csi.hpe.com/node-id"
+
+	attachLeasePrefix        = "lustre-csi-attach-"
+	attachVolumeIDAnnotation = "lustre-csi.hpe.com/volume-id"
+)
+
+// Every Lustre mount of a filesystem looks the same to Kubernetes, so nothing
+// outside of the driver stops a ReadWriteOnce volume from being mounted on
+// several nodes at once. In attach mode, ControllerPublishVolume records the
+// owning node of a single-node volume in a Lease and refuses any other node
+// until ControllerUnpublishVolume releases it. Creating the Lease is atomic in
+// the API server, so two controllers racing for the same volume cannot both win.
+
+// attachLeaseName returns the name of the Lease that records the owner of the
+// given volume. Leases are keyed by the whole volume ID, so sub-dir volumes of
+// the same filesystem have their own owners. Volume IDs hold Lustre NIDs, which
+// are not valid object names.
+func attachLeaseName(volumeID string) string {
+	sum := sha256.Sum256([]byte(volumeID))
+	return attachLeasePrefix + hex.EncodeToString(sum[:])
+}
+
+// isSingleNodeAccessMode returns true if the access mode allows the volume to
+// be published on only one node at a time.
+func isSingleNodeAccessMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		return true
+	}
+	return false
+}
+
+// acquireAttachLease records nodeID as the owner of volumeID. It succeeds if the
+// volume is unowned or already owned by nodeID.
+func (d *Driver) acquireAttachLease(ctx context.Context, volumeID, nodeID string) error {
+	client, err := d.getKubeClient()
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+	leases := client.CoordinationV1().Leases(d.namespace)
+	name := attachLeaseName(volumeID)
+
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				attachVolumeIDAnnotation: volumeID,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity: ptr.To(nodeID),
+			AcquireTime:    ptr.To(metav1.NewMicroTime(metav1.Now().Time)),
+		},
+	}
+
+	_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+	if err == nil {
+		klog.V(2).Infof("volume %s is now owned by node %s", volumeID, nodeID)
+		return nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return status.Errorf(codes.Internal, "could not create attach lease for volume %s: %v", volumeID, err)
+	}
+
+	existing, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not get attach lease for volume %s: %v", volumeID, err)
+	}
+	if err := checkAttachLease(existing, volumeID); err != nil {
+		return err
+	}
+
+	holder := ptr.Deref(existing.Spec.HolderIdentity, "")
+	if holder != nodeID {
+		return status.Errorf(codes.FailedPrecondition,
+			"volume %s is already published on node %s", volumeID, holder)
+	}
+
+	return nil
+}
+
+// releaseAttachLease removes the owner of volumeID if it is nodeID. An empty
+// nodeID releases the volume from whichever node owns it.
+func (d *Driver) releaseAttachLease(ctx context.Context, volumeID, nodeID string) error {
+	client, err := d.getKubeClient()
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+	leases := client.CoordinationV1().Leases(d.namespace)
+	name := attachLeaseName(volumeID)
+
+	existing, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "could not get attach lease for volume %s: %v", volumeID, err)
+	}
+	if err := checkAttachLease(existing, volumeID); err != nil {
+		return err
+	}
+
+	holder := ptr.Deref(existing.Spec.HolderIdentity, "")
+	if len(nodeID) != 0 && holder != nodeID {
+		klog.V(2).Infof("volume %s is owned by node %s, not %s; nothing to release", volumeID, holder, nodeID)
+		return nil
+	}
+
+	err = leases.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(existing.UID)),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return status.Errorf(codes.Internal, "could not delete attach lease for volume %s: %v", volumeID, err)
+	}
+
+	klog.V(2).Infof("volume %s is no longer owned by node %s", volumeID, holder)
+	return nil
+}
+
+// checkAttachLease returns an error unless the lease records the owner of
+// volumeID, rather than of another volume whose ID hashes to the same name.
+func checkAttachLease(lease *coordinationv1.Lease, volumeID string) error {
+	if recorded := lease.Annotations[attachVolumeIDAnnotation]; recorded != volumeID {
+		return status.Errorf(codes.Internal,
+			"attach lease %s is for volume %q, not %s", lease.Name, recorded, volumeID)
+	}
+	return nil
+}
+
+// verifyPublishContext ensures that a volume published by the controller in
+// attach mode is mounted only on the node that the controller chose.
+func (d *Driver) verifyPublishContext(req *csi.NodePublishVolumeRequest) error {
+	// Ephemeral volumes are never published by the controller.
+	if !d.enableAttach || isEphemeralVolume(req.GetVolumeContext()) {
+		return nil
+	}
+
+	owner := req.GetPublishContext()[PublishContextNodeID]
+	if len(owner) == 0 {
+		if isSingleNodeAccessMode(req.GetVolumeCapability().GetAccessMode().GetMode()) {
+			return status.Errorf(codes.FailedPrecondition,
+				"volume %s was not published to node %s by the controller", req.GetVolumeId(), d.NodeID)
+		}
+		return nil
+	}
+
+	if owner != d.NodeID {
+		return status.Errorf(codes.FailedPrecondition,
+			"volume %s is published on node %s, not %s", req.GetVolumeId(), owner, d.NodeID)
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAttachLeases(t *testing.T) {
+	d := NewDriver(&DriverOptions{EnableAttach: true, Namespace: "lustre-csi-system"})
+	d.kubeClient = fake.NewSimpleClientset()
+
+	singleNode := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	multiNode := &csi.VolumeCapability{
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+
+	// The steps run in order against the same leases.
+	steps := []struct {
+		desc      string
+		unpublish bool
+		volumeID  string
+		nodeID    string
+		volCap    *csi.VolumeCapability
+		code      codes.Code
+	}{
+		{
+			desc:     "first node owns the volume",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:   "node1",
+			volCap:   singleNode,
+		},
+		{
+			desc:     "owner publishes again",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:   "node1",
+			volCap:   singleNode,
+		},
+		{
+			desc:     "other node is refused",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:   "node2",
+			volCap:   singleNode,
+			code:     codes.FailedPrecondition,
+		},
+		{
+			desc:     "other sub-dir of the filesystem has its own owner",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/b",
+			nodeID:   "node2",
+			volCap:   singleNode,
+		},
+		{
+			desc:     "multi-node volume needs no lease",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:   "node3",
+			volCap:   multiNode,
+		},
+		{
+			desc:      "unpublish by other node keeps the owner",
+			unpublish: true,
+			volumeID:  "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:    "node2",
+		},
+		{
+			desc:     "other node is still refused",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:   "node2",
+			volCap:   singleNode,
+			code:     codes.FailedPrecondition,
+		},
+		{
+			desc:      "unpublish by the owner",
+			unpublish: true,
+			volumeID:  "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:    "node1",
+		},
+		{
+			desc:     "other node owns the released volume",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/a",
+			nodeID:   "node2",
+			volCap:   singleNode,
+		},
+		{
+			desc:      "unpublish without a node releases any owner",
+			unpublish: true,
+			volumeID:  "10.1.1.113@tcp:/lushtx#csi/b",
+		},
+		{
+			desc:      "unpublish of an unowned volume",
+			unpublish: true,
+			volumeID:  "10.1.1.113@tcp:/lushtx#csi/b",
+			nodeID:    "node1",
+		},
+		{
+			desc:     "first node owns the released sub-dir",
+			volumeID: "10.1.1.113@tcp:/lushtx#csi/b",
+			nodeID:   "node1",
+			volCap:   singleNode,
+		},
+	}
+
+	for _, step := range steps {
+		var err error
+		if step.unpublish {
+			_, err = d.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: step.volumeID,
+				NodeId:   step.nodeID,
+			})
+		} else {
+			var resp *csi.ControllerPublishVolumeResponse
+			resp, err = d.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+				VolumeId:         step.volumeID,
+				NodeId:           step.nodeID,
+				VolumeCapability: step.volCap,
+			})
+			if err == nil {
+				assert.Equal(t, step.nodeID, resp.GetPublishContext()[PublishContextNodeID], step.desc)
+			}
+		}
+		assert.Equal(t, step.code, status.Code(err), step.desc)
+	}
+
+	leases, err := d.kubeClient.CoordinationV1().Leases(d.namespace).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	holders := map[string]string{}
+	for _, lease := range leases.Items {
+		assert.Equal(t, attachLeaseName(lease.Annotations[attachVolumeIDAnnotation]), lease.Name)
+		holders[lease.Annotations[attachVolumeIDAnnotation]] = *lease.Spec.HolderIdentity
+	}
+	assert.Equal(t, map[string]string{
+		"10.1.1.113@tcp:/lushtx#csi/a": "node2",
+		"10.1.1.113@tcp:/lushtx#csi/b": "node1",
+	}, holders)
+}
+
+func TestAttachLeaseOfOtherVolume(t *testing.T) {
+	volumeID := "10.1.1.113@tcp:/lushtx#csi/a"
+	d := NewDriver(&DriverOptions{EnableAttach: true, Namespace: "lustre-csi-system"})
+	d.kubeClient = fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        attachLeaseName(volumeID),
+			Namespace:   "lustre-csi-system",
+			Annotations: map[string]string{attachVolumeIDAnnotation: "10.1.1.113@tcp:/lushtx#csi/b"},
+		},
+	})
+
+	err := d.acquireAttachLease(context.Background(), volumeID, "node1")
+	assert.Equal(t, codes.Internal, status.Code(err))
+	err = d.releaseAttachLease(context.Background(), volumeID, "")
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
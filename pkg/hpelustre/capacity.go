@@ -0,0 +1,125 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Internal mount of a filesystem whose capacity is being read
+	capacityMountPath = "capacity"
+)
+
+// Lustre OST pool names are up to 15 characters.
+var ostPoolRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,15}$`)
+
+// GetCapacity returns the space that is available for new volumes of a
+// StorageClass: that of its OST pool, if it has one, or of its whole
+// filesystem, from "lfs df". A filesystem or pool without free inodes has no
+// capacity, as no files can be created in it.
+func (d *Driver) GetCapacity(
+	_ context.Context,
+	req *csi.GetCapacityRequest,
+) (*csi.GetCapacityResponse, error) {
+	if !d.enableCapacity {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	params, err := parseStorageClassParameters(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	d.capacityLock.Lock()
+	defer d.capacityLock.Unlock()
+
+	root, unmount, err := d.mountFilesystem(params.filesystem, capacityMountPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	blocks, err := d.lfsDf(root, params, false)
+	if err != nil {
+		return nil, err
+	}
+	inodes, err := d.lfsDf(root, params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	available := blocks.Available * 1024
+	if inodes.Available == 0 {
+		klog.Warningf("GetCapacity: %s has no free inodes", describeCapacity(params))
+		available = 0
+	}
+	klog.V(4).Infof("GetCapacity: %s has %d bytes and %d inodes available",
+		describeCapacity(params), blocks.Available*1024, inodes.Available)
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: available,
+		MaximumVolumeSize: wrapperspb.Int64(available),
+	}, nil
+}
+
+// lfsDf runs "lfs df" on a filesystem mounted at root, for the OST pool of the
+// StorageClass if it has one.
+func (d *Driver) lfsDf(root string, params *storageClassParameters, inodes bool) (*volumehelper.LfsDfSummary, error) {
+	args := []string{"df"}
+	if inodes {
+		args = append(args, "-i")
+	}
+	if len(params.ostPool) != 0 {
+		_, fsName, err := parseVolumeHandle(params.filesystem)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--pool", fsName+"."+params.ostPool)
+	}
+	args = append(args, root)
+
+	output, err := d.runLfs(args...)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not get capacity of %s: %v", describeCapacity(params), err)
+	}
+
+	summary, err := volumehelper.ParseLfsDf(output)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get capacity of %s: %v", describeCapacity(params), err)
+	}
+	return summary, nil
+}
+
+func describeCapacity(params *storageClassParameters) string {
+	if len(params.ostPool) != 0 {
+		return fmt.Sprintf("OST pool %s of %s", params.ostPool, params.filesystem)
+	}
+	return params.filesystem
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// The node plugin can keep Lustre client parameters of the whole node at the
+// values that are read from a file, normally a mounted ConfigMap shared by
+// every node:
+//
+//	parameters:
+//	  - name: osc.*.max_pages_per_rpc
+//	    value: "1024"
+//	  - name: jobid_var
+//	    value: procname_uid
+//
+// Only parameters whose names match --client-param-allowlist may be set. Once
+// per interval, and after each volume is mounted, the node plugin reads the
+// parameters with lctl get_param and sets those that have drifted from their
+// values with lctl set_param, in the order of the file. Parameters that are
+// removed from the file keep their last values.
+type clientParamsConfig struct {
+	Parameters []clientParam `json:"parameters"`
+}
+
+type clientParam struct {
+	// Name of the parameter, which may have wildcards, as lctl takes it
+	Name string `json:"name"`
+	// Value of the parameter, as lctl get_param prints it
+	Value string `json:"value"`
+}
+
+// DefaultClientParamAllowlist is the default of --client-param-allowlist. It
+// leaves out the parameters that volumes tune on their own client mounts.
+var DefaultClientParamAllowlist = []string{
+	"osc.*.max_pages_per_rpc",
+	"osc.*.checksums",
+	"osc.*.short_io_bytes",
+	"mdc.*.max_rpcs_in_flight",
+	"mdc.*.max_mod_rpcs_in_flight",
+	"ldlm.namespaces.*.lru_size",
+	"ldlm.namespaces.*.lru_max_age",
+	"llite.*.max_read_ahead_per_file_mb",
+	"llite.*.max_read_ahead_whole_mb",
+	"llite.*.max_cached_mb",
+	"jobid_var",
+	"jobid_name",
+}
+
+var (
+	clientParamInSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lustre_csi_client_param_in_sync",
+		Help: "Whether a Lustre client parameter of the node had its configured value after it was last reconciled.",
+	}, []string{"param"})
+	clientParamInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lustre_csi_client_param_instances",
+		Help: "Number of devices of the node that a Lustre client parameter was found on when it was last reconciled.",
+	}, []string{"param"})
+	clientParamCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_client_param_corrections_total",
+		Help: "Number of times that a Lustre client parameter of the node was set after drifting from its configured value.",
+	}, []string{"param"})
+	clientParamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_client_param_errors_total",
+		Help: "Number of times that a Lustre client parameter of the node could not be read or set.",
+	}, []string{"param"})
+	clientParamsReconciled = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lustre_csi_client_params_last_reconcile_timestamp_seconds",
+		Help: "Time that the Lustre client parameters of the node were last reconciled.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(clientParamInSync, clientParamInstances, clientParamCorrections,
+		clientParamErrors, clientParamsReconciled)
+}
+
+// parseClientParamsConfig parses a file of client parameters, each of which
+// must match one of the patterns of the allowlist.
+func parseClientParamsConfig(data []byte, allowlist []string) (*clientParamsConfig, error) {
+	config := &clientParamsConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for i, param := range config.Parameters {
+		if len(param.Name) == 0 {
+			return nil, fmt.Errorf("parameter %d: name must be given", i)
+		}
+		if names[param.Name] {
+			return nil, fmt.Errorf("parameter %q is given more than once", param.Name)
+		}
+		names[param.Name] = true
+		if !isAllowedClientParam(param.Name, allowlist) {
+			return nil, fmt.Errorf("parameter %q is not in the allowlist %s", param.Name, strings.Join(allowlist, ","))
+		}
+		if len(param.Value) == 0 || strings.ContainsAny(param.Value, "\n") {
+			return nil, fmt.Errorf("parameter %q: value must be a single line", param.Name)
+		}
+	}
+
+	return config, nil
+}
+
+// isAllowedClientParam reports whether the name of a parameter matches one of
+// the patterns of the allowlist, in which "*" matches any part of the name,
+// including a "*" of the name itself.
+func isAllowedClientParam(name string, allowlist []string) bool {
+	for _, pattern := range allowlist {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// loadClientParamsConfig returns the client parameters of the node, reading
+// the file again if it has changed since it was last read.
+func (d *Driver) loadClientParamsConfig() (*clientParamsConfig, error) {
+	info, err := os.Stat(d.clientParamsFile)
+	if err != nil {
+		return nil, err
+	}
+	if d.clientParamsConfig != nil && info.ModTime().Equal(d.clientParamsModTime) {
+		return d.clientParamsConfig, nil
+	}
+
+	data, err := os.ReadFile(d.clientParamsFile)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseClientParamsConfig(data, d.clientParamAllowlist)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %w", d.clientParamsFile, err)
+	}
+
+	klog.V(2).Infof("loaded client parameters %q with %d parameters", d.clientParamsFile, len(config.Parameters))
+	d.clientParamsConfig = config
+	d.clientParamsModTime = info.ModTime()
+	return config, nil
+}
+
+// kickClientParams has the client parameters reconciled soon, such as once a
+// volume is mounted with new devices, if they are managed.
+func (d *Driver) kickClientParams() {
+	select {
+	case d.clientParamsKick <- struct{}{}:
+	default:
+	}
+}
+
+// runClientParams reconciles the client parameters of the node once per
+// interval, and when kicked.
+func (d *Driver) runClientParams(ctx context.Context) {
+	klog.Infof("reconciling client parameters from %q every %v", d.clientParamsFile, d.clientParamsInterval)
+
+	ticker := time.NewTicker(d.clientParamsInterval)
+	defer ticker.Stop()
+
+	reconciled := map[string]struct{}{}
+	for {
+		config, err := d.loadClientParamsConfig()
+		if err != nil {
+			// The parameters that were last loaded are still reconciled.
+			klog.Errorf("failed to load client parameters: %v", err)
+			config = d.clientParamsConfig
+		}
+		if config != nil {
+			found := map[string]struct{}{}
+			for _, param := range config.Parameters {
+				found[param.Name] = struct{}{}
+				d.reconcileClientParam(param)
+			}
+			for name := range reconciled {
+				if _, ok := found[name]; !ok {
+					clientParamInSync.DeleteLabelValues(name)
+					clientParamInstances.DeleteLabelValues(name)
+				}
+			}
+			reconciled = found
+			clientParamsReconciled.SetToCurrentTime()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.clientParamsKick:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconcileClientParam sets a client parameter on the devices of the node
+// where it has drifted from its value. A parameter that is not on any device,
+// such as one of the OSCs before any volume is mounted, is in sync.
+func (d *Driver) reconcileClientParam(param clientParam) {
+	output, err := d.runLctl("get_param", param.Name)
+	if err != nil {
+		clientParamInstances.WithLabelValues(param.Name).Set(0)
+		clientParamInSync.WithLabelValues(param.Name).Set(1)
+		klog.V(4).Infof("client parameter %s is not on the node: %v", param.Name, err)
+		return
+	}
+	values, err := volumehelper.ParseLctlParams(output)
+	if err != nil {
+		clientParamErrors.WithLabelValues(param.Name).Inc()
+		clientParamInSync.WithLabelValues(param.Name).Set(0)
+		klog.Errorf("failed to read client parameter %s: %v", param.Name, err)
+		return
+	}
+	clientParamInstances.WithLabelValues(param.Name).Set(float64(len(values)))
+
+	drifted := []string{}
+	for name, value := range values {
+		if value != param.Value {
+			drifted = append(drifted, name+"="+value)
+		}
+	}
+	if len(drifted) == 0 {
+		clientParamInSync.WithLabelValues(param.Name).Set(1)
+		return
+	}
+
+	klog.Infof("setting client parameter %s=%s, which has drifted: %s", param.Name, param.Value, strings.Join(drifted, ", "))
+	clientParamCorrections.WithLabelValues(param.Name).Inc()
+	if _, err := d.runLctl("set_param", param.Name+"="+param.Value); err != nil {
+		clientParamErrors.WithLabelValues(param.Name).Inc()
+		clientParamInSync.WithLabelValues(param.Name).Set(0)
+		klog.Errorf("failed to set client parameter %s: %v", param.Name, err)
+		return
+	}
+	clientParamInSync.WithLabelValues(param.Name).Set(1)
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAllowedClientParam(t *testing.T) {
+	tests := []struct {
+		desc      string
+		name      string
+		allowlist []string
+		allowed   bool
+	}{
+		{
+			desc:      "device of the pattern",
+			name:      "osc.lushtx-OST0000-osc-ffff8f6a4d6e8000.checksums",
+			allowlist: DefaultClientParamAllowlist,
+			allowed:   true,
+		},
+		{
+			desc:      "all devices",
+			name:      "osc.*.checksums",
+			allowlist: DefaultClientParamAllowlist,
+			allowed:   true,
+		},
+		{
+			desc:      "exact name",
+			name:      "jobid_var",
+			allowlist: DefaultClientParamAllowlist,
+			allowed:   true,
+		},
+		{
+			desc:      "parameter that volumes tune",
+			name:      "osc.*.max_rpcs_in_flight",
+			allowlist: DefaultClientParamAllowlist,
+		},
+		{
+			desc:      "parameter of another device type",
+			name:      "mdc.*.checksums",
+			allowlist: DefaultClientParamAllowlist,
+		},
+		{
+			desc:      "prefix of a parameter",
+			name:      "osc.*.checksum",
+			allowlist: DefaultClientParamAllowlist,
+		},
+		{
+			desc:      "any parameter",
+			name:      "llite.*.statahead_max",
+			allowlist: []string{"*"},
+			allowed:   true,
+		},
+		{
+			desc: "empty allowlist",
+			name: "jobid_var",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.allowed, isAllowedClientParam(test.name, test.allowlist))
+		})
+	}
+}
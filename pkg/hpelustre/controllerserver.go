@@ -18,28 +18,470 @@ package hpelustre
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
 )
 
 const (
 	VolumeContextMGSIPAddress = "mgs-ip-address"
 	VolumeContextSubDir       = "sub-dir"
+	VolumeContextEncrypted    = "encrypted"
+	VolumeContextUID          = "uid"
+	VolumeContextGID          = "gid"
+	VolumeContextMode         = "mode"
+	VolumeContextDefaultACL   = "default-acl"
+	// Mount source of an ephemeral inline volume, which has no volume handle
+	VolumeContextFilesystem = "filesystem"
+	// What happens to the sub-dir when the volume is unpublished
+	VolumeContextSubDirOnUnpublish = "sub-dir-on-unpublish"
+	// Directory that archived sub-dirs are moved into
+	VolumeContextSubDirArchive = "sub-dir-archive"
+	// Whether the released files of the volume are restored from the HSM
+	// archive when it is published, and its other files prefetched as well
+	VolumeContextHsmOnPublish = "hsm-on-publish"
+	// Maximum number of bytes that are restored and prefetched on publish
+	VolumeContextHsmOnPublishMaxBytes = "hsm-on-publish-max-bytes"
+	// Whether the files of the volume are cached read-only or read-write
+	// with PCC on the node that it is published on
+	VolumeContextPCCMode = "pcc-mode"
+	// PCC backend of the node that files are cached in
+	VolumeContextPCCBackend = "pcc-backend"
+	// Rule that selects the files of the volume that are cached
+	VolumeContextPCCRule = "pcc-rule"
+	// Comma-separated patterns of the files of the volume that are
+	// prefetched when it is published
+	VolumeContextPrefetchPaths = "prefetch-paths"
+	// File in the volume with more patterns of files to prefetch
+	VolumeContextPrefetchManifest = "prefetch-manifest"
+	// Whether publishing the volume waits for the prefetch to finish
+	VolumeContextPrefetchWait = "prefetch-wait"
+	// Lustre client parameters of the mount of the volume
+	VolumeContextClientMaxReadAheadMB  = "client-max-read-ahead-mb"
+	VolumeContextClientMaxDirtyMB      = "client-max-dirty-mb"
+	VolumeContextClientMaxRPCsInFlight = "client-max-rpcs-in-flight"
+	VolumeContextClientStatAheadMax    = "client-statahead-max"
+	VolumeContextClientLazyStatFS      = "client-lazystatfs"
 )
 
-// CreateVolume provisions a volume
+// StorageClass parameters of dynamically provisioned volumes
+const (
+	// Volume handle of the filesystem that volumes are created on
+	ParameterFilesystem = "filesystem"
+	// Directory below the root of the filesystem that volumes are created in
+	ParameterParentDir = "parent-dir"
+	// OST pool that the files of volumes are allocated from, and whose free
+	// space GetCapacity reports
+	ParameterOSTPool = "ost-pool"
+	// Comma-separated OST pools that the files of volumes are mirrored
+	// across, instead of being allocated from a single OST pool
+	ParameterMirrorPools = "mirror-pools"
+
+	defaultParentDir = "csi-volumes"
+
+	// Prefix of the parameters that are reserved by the external provisioner,
+	// such as csi.storage.k8s.io/pvc/name with --extra-create-metadata
+	provisionerParameterPrefix = "csi.storage.k8s.io/"
+
+	// Internal mount of a filesystem for creating volumes
+	provisionMountPath = "volumes"
+)
+
+// Values of VolumeContextSubDirOnUnpublish
+const (
+	SubDirOnUnpublishDelete  = "delete"
+	SubDirOnUnpublishRetain  = "retain"
+	SubDirOnUnpublishArchive = "archive"
+)
+
+// Suffix of the hidden sibling directory that a deleted sub-dir is moved into
+// while its files are removed
+const subDirDeletingSuffix = ".deleting"
+
+// CreateVolume provisions a sub-dir volume in the parent directory of a
+// filesystem, populating it from a snapshot or another volume if it has a
+// content source
 func (d *Driver) CreateVolume(
-	ctx context.Context,
+	_ context.Context,
 	req *csi.CreateVolumeRequest,
 ) (*csi.CreateVolumeResponse, error) {
-	return nil, nil
+	if !d.enableProvisioning {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume name missing in request")
+	}
+	if strings.Contains(name, "/") || !ensureStrictSubpath(name) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Volume name %q must be a single path component", name)
+	}
+
+	volCaps := req.GetVolumeCapabilities()
+	if len(volCaps) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume capabilities missing in request")
+	}
+	for _, volCap := range volCaps {
+		if volCap.GetBlock() != nil {
+			return nil, status.Error(codes.InvalidArgument,
+				"Block volumes are not supported")
+		}
+	}
+
+	params, err := parseStorageClassParameters(req.GetParameters())
+	if err != nil {
+		return nil, err
+	}
+	// The files of a new volume have nothing to migrate, and the OST pool of a
+	// VolumeAttributesClass takes precedence over that of the StorageClass.
+	modification, err := parseMutableParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, err
+	}
+	modification.migrate = false
+	if modification.ostPool == nil && len(params.ostPool) != 0 {
+		modification.ostPool = &params.ostPool
+	}
+	modification.mirrorPools = params.mirrorPools
+
+	volumeDir := filepath.Join(params.parentDir, name)
+	volumeID := makeSubDirVolumeID(params.filesystem, volumeDir)
+	if err := d.checkManagedVolumeDir(volumeDir); err != nil {
+		return nil, err
+	}
+
+	source, err := d.getVolumeSource(req.GetVolumeContentSource(), params.filesystem)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil && (isSubpathOf(source.SubDir, volumeDir) || isSubpathOf(volumeDir, source.SubDir)) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Content source %q overlaps volume %q", source.ID, volumeID)
+	}
+
+	// Lustre does not limit the size of a sub-dir, so the volume has the
+	// capacity that was asked for.
+	capacity := req.GetCapacityRange().GetRequiredBytes()
+	if capacity == 0 {
+		capacity = req.GetCapacityRange().GetLimitBytes()
+	}
+	resp := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: capacity,
+			VolumeContext: params.volumeContext,
+			ContentSource: req.GetVolumeContentSource(),
+		},
+	}
+
+	d.volumeLock.Lock()
+	defer d.volumeLock.Unlock()
+
+	if c, ok := d.volumeCopies[volumeID]; ok {
+		if !c.finished() {
+			return nil, status.Errorf(codes.Aborted, "Volume %q is being populated", volumeID)
+		}
+		delete(d.volumeCopies, volumeID)
+		if c.err != nil {
+			return nil, c.err
+		}
+	}
+
+	root, unmount, err := d.mountFilesystem(params.filesystem, provisionMountPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+	d.addManagedParent(params.filesystem, params.parentDir)
+
+	path, exists, err := checkSubDir(root, volumeDir)
+	if err != nil {
+		return nil, err
+	}
+	populating, err := readPopulatingMarker(root, volumeDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if populating != nil {
+		if source == nil || populating.ID != source.ID {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"Volume %q is being populated from %q", volumeID, populating.ID)
+		}
+		klog.Infof("CreateVolume: resuming interrupted copy of %s into volume %s", source.ID, volumeID)
+		d.startVolumeCopy(volumeID, source, modification)
+		return nil, status.Errorf(codes.Aborted, "Volume %q is being populated", volumeID)
+	}
+	if exists {
+		if err := recordVolumeCapacity(path, capacity); err != nil {
+			return nil, err
+		}
+		if err := recordVolumeContext(path, params.volumeContext); err != nil {
+			return nil, err
+		}
+		if err := d.modifyVolume(root, path, volumeID, modification, false); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	if err := makeManagedParent(root, volumeDir); err != nil {
+		return nil, err
+	}
+
+	if source == nil {
+		klog.V(2).Infof("CreateVolume: creating volume %s", volumeID)
+		if err := os.Mkdir(path, 0o775); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to make volume directory %q: %v", path, err)
+		}
+		if err := d.modifyVolume(root, path, volumeID, modification, true); err != nil {
+			_ = os.Remove(path)
+			return nil, err
+		}
+		if err := recordVolumeCapacity(path, capacity); err != nil {
+			return nil, err
+		}
+		if err := recordVolumeContext(path, params.volumeContext); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	if err := checkVolumeSource(root, source); err != nil {
+		return nil, err
+	}
+
+	klog.V(2).Infof("CreateVolume: creating volume %s from %s", volumeID, source.ID)
+	if err := writePopulatingMarker(root, volumeDir, source); err != nil {
+		return nil, err
+	}
+	d.startVolumeCopy(volumeID, source, modification)
+
+	return nil, status.Errorf(codes.Aborted, "Volume %q is being populated", volumeID)
+}
+
+type storageClassParameters struct {
+	filesystem string
+	parentDir  string
+	ostPool    string
+	// OST pools that the files of volumes are mirrored across
+	mirrorPools []string
+	// Attributes that are passed on to the volume context of the volume
+	volumeContext map[string]string
 }
 
-// DeleteVolume delete a volume
+// parseStorageClassParameters parses the StorageClass parameters that are
+// passed to CreateVolume.
+func parseStorageClassParameters(params map[string]string) (*storageClassParameters, error) {
+	parsed := &storageClassParameters{
+		parentDir:     defaultParentDir,
+		volumeContext: map[string]string{},
+	}
+
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case ParameterFilesystem:
+			if strings.Contains(v, separator) {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be the volume handle of a filesystem", k)
+			}
+			if err := ValidateVolumeHandle(v); err != nil {
+				return nil, err
+			}
+			parsed.filesystem = v
+		case ParameterParentDir:
+			parsed.parentDir = strings.Trim(v, "/")
+			if !ensureStrictSubpath(parsed.parentDir) {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be strict subpath", k)
+			}
+		case ParameterOSTPool:
+			if !ostPoolRegex.MatchString(v) {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be 1 to 15 letters, digits, '_' or '-'", k)
+			}
+			parsed.ostPool = v
+		case ParameterMirrorPools:
+			pools, err := parseMirrorPools(v)
+			if err != nil {
+				return nil, err
+			}
+			parsed.mirrorPools = pools
+		case VolumeContextHsmOnPublish, VolumeContextHsmOnPublishMaxBytes,
+			VolumeContextPCCMode, VolumeContextPCCBackend, VolumeContextPCCRule,
+			VolumeContextPrefetchPaths, VolumeContextPrefetchManifest, VolumeContextPrefetchWait:
+			parsed.volumeContext[strings.ToLower(k)] = v
+		default:
+			if strings.HasPrefix(k, provisionerParameterPrefix) {
+				continue
+			}
+			if strings.HasPrefix(strings.ToLower(k), clientTuningPrefix) {
+				parsed.volumeContext[strings.ToLower(k)] = v
+				continue
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown parameter %s", k)
+		}
+	}
+
+	if len(parsed.filesystem) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Parameter %s is required", ParameterFilesystem)
+	}
+	if len(parsed.ostPool) != 0 && len(parsed.mirrorPools) != 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Parameter %s is not allowed with %s", ParameterOSTPool, ParameterMirrorPools)
+	}
+	if err := parseVolumeContext(newVolume(""), parsed.volumeContext); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// DeleteVolume moves a volume that the driver created into the trash of its
+// filesystem, stopping any copy that is populating it. The trash collector
+// removes it later, or archives it if its hsm-on-delete policy is archive.
 func (d *Driver) DeleteVolume(
-	ctx context.Context, req *csi.DeleteVolumeRequest,
+	_ context.Context, req *csi.DeleteVolumeRequest,
 ) (*csi.DeleteVolumeResponse, error) {
-	return nil, nil
+	if !d.enableProvisioning {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	handle, volumeDir := splitVolumeID(volumeID)
+	if len(volumeDir) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Volume %q is not a sub-dir volume and was not created by the driver", volumeID)
+	}
+	if err := ValidateVolumeHandle(volumeID); err != nil {
+		return nil, err
+	}
+	if err := d.checkManagedVolumeDir(volumeDir); err != nil {
+		return nil, err
+	}
+
+	d.volumeLock.Lock()
+	defer d.volumeLock.Unlock()
+
+	if c, ok := d.volumeCopies[volumeID]; ok {
+		c.stop()
+		delete(d.volumeCopies, volumeID)
+	}
+	if c, ok := d.volumeModifications[volumeID]; ok {
+		c.stop()
+		delete(d.volumeModifications, volumeID)
+	}
+
+	root, unmount, err := d.mountFilesystem(handle, provisionMountPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	_, exists, err := checkSubDir(root, volumeDir)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		// The driver only deletes directories in a parent directory that it
+		// created volumes in.
+		if managed, err := isManagedParent(root, filepath.Dir(volumeDir)); err != nil {
+			return nil, err
+		} else if !managed {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"refusing to delete volume %q, which is not in a parent directory managed by the driver", volumeID)
+		}
+
+		klog.V(2).Infof("DeleteVolume: moving volume %s into the trash", volumeID)
+		d.addManagedParent(handle, filepath.Dir(volumeDir))
+		if err := d.moveToTrash(root, volumeDir); err != nil {
+			return nil, err
+		}
+	}
+
+	marker := filepath.Join(root, populatingMarker(volumeDir))
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "failed to remove %q: %v", marker, err)
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ControllerPublishVolume records the node that a single-node volume is
+// published on, and rejects any other node until the volume is unpublished
+func (d *Driver) ControllerPublishVolume(
+	ctx context.Context,
+	req *csi.ControllerPublishVolumeRequest,
+) (*csi.ControllerPublishVolumeResponse, error) {
+	if !d.enableAttach {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	nodeID := req.GetNodeId()
+	if len(nodeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Node ID missing in request")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if volCap == nil {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume capability missing in request")
+	}
+
+	if isSingleNodeAccessMode(volCap.GetAccessMode().GetMode()) {
+		if err := d.acquireAttachLease(ctx, volumeID, nodeID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{
+			PublishContextNodeID: nodeID,
+		},
+	}, nil
+}
+
+// ControllerUnpublishVolume releases a volume from the node it was published on
+func (d *Driver) ControllerUnpublishVolume(
+	ctx context.Context,
+	req *csi.ControllerUnpublishVolumeRequest,
+) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if !d.enableAttach {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	if err := d.releaseAttachLease(ctx, volumeID, req.GetNodeId()); err != nil {
+		return nil, err
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
 // ValidateVolumeCapabilities return the capabilities of the volume
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseStorageClassParameters(t *testing.T) {
+	tests := []struct {
+		desc          string
+		params        map[string]string
+		filesystem    string
+		parentDir     string
+		ostPool       string
+		volumeContext map[string]string
+		code          codes.Code
+	}{
+		{
+			desc:          "filesystem only",
+			params:        map[string]string{"filesystem": "10.0.0.1@tcp:/lustre"},
+			filesystem:    "10.0.0.1@tcp:/lustre",
+			parentDir:     defaultParentDir,
+			volumeContext: map[string]string{},
+		},
+		{
+			desc: "all parameters",
+			params: map[string]string{
+				"filesystem":                "10.0.0.1@tcp:/lustre",
+				"parent-dir":                "/volumes/team/",
+				"ost-pool":                  "flash",
+				"csi.storage.k8s.io/fstype": "lustre",
+			},
+			filesystem:    "10.0.0.1@tcp:/lustre",
+			parentDir:     "volumes/team",
+			ostPool:       "flash",
+			volumeContext: map[string]string{},
+		},
+		{
+			desc: "volume context attributes in any case",
+			params: map[string]string{
+				"filesystem":               "10.0.0.1@tcp:/lustre",
+				"HSM-On-Publish":           "restore",
+				"prefetch-paths":           "index",
+				"client-max-read-ahead-mb": "64",
+			},
+			filesystem: "10.0.0.1@tcp:/lustre",
+			parentDir:  defaultParentDir,
+			volumeContext: map[string]string{
+				"hsm-on-publish":           "restore",
+				"prefetch-paths":           "index",
+				"client-max-read-ahead-mb": "64",
+			},
+		},
+		{
+			desc:   "missing filesystem",
+			params: map[string]string{"parent-dir": "volumes"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "filesystem with sub-dir",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre#data"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "parent dir is the root",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "parent-dir": "/"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "OST pool with filesystem name",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "ost-pool": "lustre.flash"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "invalid volume context attribute",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "client-lazystatfs": "maybe"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "unknown parameter",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "size": "10Gi"},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			parsed, err := parseStorageClassParameters(test.params)
+			require.Equal(t, test.code, status.Code(err), "%v", err)
+			if test.code != codes.OK {
+				return
+			}
+			assert.Equal(t, test.filesystem, parsed.filesystem)
+			assert.Equal(t, test.parentDir, parsed.parentDir)
+			assert.Equal(t, test.ostPool, parsed.ostPool)
+			assert.Equal(t, test.volumeContext, parsed.volumeContext)
+		})
+	}
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Lustre 2.14 and later support client-side encryption through the kernel's
+// fscrypt interface. An encrypted sub-dir volume has a v2 encryption policy on
+// its directory. The key is added to the filesystem keyring of the volume's
+// own client mount on publish, which unlocks the directory for that mount
+// only, and removed again on unpublish.
+
+// SecretEncryptionKey is the key in the node publish secret that holds the raw
+// 64-byte master key of an encrypted sub-dir volume.
+const SecretEncryptionKey = "encryptionKey"
+
+const fscryptMasterKeySize = 64
+
+type fscryptKeyID [unix.FSCRYPT_KEY_IDENTIFIER_SIZE]byte
+
+func (id fscryptKeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func parseFscryptKeyID(s string) (fscryptKeyID, error) {
+	id := fscryptKeyID{}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, status.Errorf(codes.Internal, "invalid encryption key identifier %q", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// fscryptError converts an error from an fscrypt ioctl into a status error that
+// says whether encryption is unsupported, rather than failing.
+func fscryptError(op, path string, err error) error {
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) {
+		return status.Errorf(codes.FailedPrecondition,
+			"%s on %q: client-side encryption is not supported by the Lustre client or servers: %v", op, path, err)
+	}
+	return status.Errorf(codes.Internal, "%s on %q: %v", op, path, err)
+}
+
+func fscryptIoctl(path string, req uintptr, arg unsafe.Pointer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// fscryptAddKey adds the master key to the keyring of the filesystem that
+// contains path, and returns the key's identifier.
+func fscryptAddKey(path string, key []byte) (fscryptKeyID, error) {
+	id := fscryptKeyID{}
+	if len(key) != fscryptMasterKeySize {
+		return id, status.Errorf(codes.InvalidArgument,
+			"encryption key must be %d bytes, not %d", fscryptMasterKeySize, len(key))
+	}
+
+	// The raw key follows the fixed part of the argument.
+	buf := make([]byte, unsafe.Sizeof(unix.FscryptAddKeyArg{})+uintptr(len(key)))
+	arg := (*unix.FscryptAddKeyArg)(unsafe.Pointer(&buf[0]))
+	arg.Key_spec.Type = unix.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER
+	arg.Raw_size = uint32(len(key))
+	copy(buf[unsafe.Sizeof(*arg):], key)
+	defer clear(buf)
+
+	if err := fscryptIoctl(path, unix.FS_IOC_ADD_ENCRYPTION_KEY, unsafe.Pointer(arg)); err != nil {
+		return id, fscryptError("adding encryption key", path, err)
+	}
+
+	copy(id[:], arg.Key_spec.U[:len(id)])
+	return id, nil
+}
+
+// fscryptRemoveKey removes the master key from the keyring of the filesystem
+// that contains path, which locks the files that were unlocked with it.
+func fscryptRemoveKey(path string, id fscryptKeyID) error {
+	arg := unix.FscryptRemoveKeyArg{}
+	arg.Key_spec.Type = unix.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER
+	copy(arg.Key_spec.U[:], id[:])
+
+	if err := fscryptIoctl(path, unix.FS_IOC_REMOVE_ENCRYPTION_KEY, unsafe.Pointer(&arg)); err != nil {
+		return fscryptError("removing encryption key", path, err)
+	}
+	return nil
+}
+
+// fscryptGetPolicyKeyID returns the identifier of the key that the directory
+// is encrypted with, or false if it is not encrypted.
+func fscryptGetPolicyKeyID(dir string) (fscryptKeyID, bool, error) {
+	id := fscryptKeyID{}
+	arg := unix.FscryptGetPolicyExArg{Size: uint64(len(unix.FscryptGetPolicyExArg{}.Policy))}
+
+	err := fscryptIoctl(dir, unix.FS_IOC_GET_ENCRYPTION_POLICY_EX, unsafe.Pointer(&arg))
+	if errors.Is(err, unix.ENODATA) {
+		return id, false, nil
+	} else if err != nil {
+		return id, false, fscryptError("getting encryption policy", dir, err)
+	}
+
+	policy := (*unix.FscryptPolicyV2)(unsafe.Pointer(&arg.Policy[0]))
+	if policy.Version != unix.FSCRYPT_POLICY_V2 {
+		return id, false, status.Errorf(codes.FailedPrecondition,
+			"directory %q has an unsupported v%d encryption policy", dir, policy.Version)
+	}
+
+	copy(id[:], policy.Master_key_identifier[:])
+	return id, true, nil
+}
+
+// fscryptSetPolicy encrypts the empty directory with the key.
+func fscryptSetPolicy(dir string, id fscryptKeyID) error {
+	policy := unix.FscryptPolicyV2{
+		Version:                   unix.FSCRYPT_POLICY_V2,
+		Contents_encryption_mode:  unix.FSCRYPT_MODE_AES_256_XTS,
+		Filenames_encryption_mode: unix.FSCRYPT_MODE_AES_256_CTS,
+		Flags:                     unix.FSCRYPT_POLICY_FLAGS_PAD_32,
+		Master_key_identifier:     [unix.FSCRYPT_KEY_IDENTIFIER_SIZE]uint8(id),
+	}
+
+	if err := fscryptIoctl(dir, unix.FS_IOC_SET_ENCRYPTION_POLICY, unsafe.Pointer(&policy)); err != nil {
+		if errors.Is(err, unix.ENOTEMPTY) {
+			return status.Errorf(codes.FailedPrecondition,
+				"sub-dir %q is not empty, so it cannot be encrypted", dir)
+		}
+		return fscryptError("setting encryption policy", dir, err)
+	}
+	return nil
+}
+
+// ensureEncryptionPolicy encrypts a new, empty sub-dir with the volume's key,
+// or verifies that an existing sub-dir is encrypted with it. The key is added
+// to the filesystem keyring only for as long as it takes to set the policy.
+func ensureEncryptionPolicy(dir string, key []byte) error {
+	keyID, err := fscryptAddKey(dir, key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := fscryptRemoveKey(dir, keyID); err != nil {
+			klog.Warningf("failed to remove encryption key from %q: %v", dir, err)
+		}
+	}()
+
+	return verifyEncryptionPolicy(dir, keyID, true)
+}
+
+// verifyEncryptionPolicy checks that the directory is encrypted with the key.
+// If allowSet is true, an unencrypted directory is encrypted.
+func verifyEncryptionPolicy(dir string, keyID fscryptKeyID, allowSet bool) error {
+	policyKeyID, encrypted, err := fscryptGetPolicyKeyID(dir)
+	if err != nil {
+		return err
+	}
+
+	if !encrypted {
+		if !allowSet {
+			return status.Errorf(codes.FailedPrecondition, "sub-dir %q is not encrypted", dir)
+		}
+
+		klog.V(2).Infof("encrypting sub-dir %q with key %s", dir, keyID)
+		return fscryptSetPolicy(dir, keyID)
+	}
+
+	if policyKeyID != keyID {
+		return status.Errorf(codes.PermissionDenied,
+			"sub-dir %q is encrypted with a different key", dir)
+	}
+
+	return nil
+}
+
+// getEncryptionKey returns the master key of an encrypted volume from its node
+// publish secret.
+func getEncryptionKey(secrets map[string]string) (string, error) {
+	key, ok := secrets[SecretEncryptionKey]
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument,
+			"Encrypted volume requires %q in the node publish secret", SecretEncryptionKey)
+	}
+	if len(key) != fscryptMasterKeySize {
+		return "", status.Errorf(codes.InvalidArgument,
+			"%q in the node publish secret must be a raw %d-byte key, not %d bytes",
+			SecretEncryptionKey, fscryptMasterKeySize, len(key))
+	}
+	return key, nil
+}
+
+// unlockEncryptedVolume adds the volume's key to the keyring of the client
+// mount at target, and returns the key identifier for locking it again.
+func unlockEncryptedVolume(target string, key []byte) (fscryptKeyID, error) {
+	keyID, err := fscryptAddKey(target, key)
+	if err != nil {
+		return keyID, err
+	}
+
+	if err := verifyEncryptionPolicy(target, keyID, false); err != nil {
+		if removeErr := fscryptRemoveKey(target, keyID); removeErr != nil {
+			klog.Warningf("failed to remove encryption key from %q: %v", target, removeErr)
+		}
+		return keyID, err
+	}
+
+	return keyID, nil
+}
+
+// lockEncryptedVolume removes the key of an encrypted volume from the client
+// mount at target before it is unmounted.
+func (d *Driver) lockEncryptedVolume(target string) {
+	d.publishStateLock.Lock()
+	defer d.publishStateLock.Unlock()
+
+	rec, err := d.loadPublishRecord(target)
+	if err != nil {
+		klog.Warningf("could not load publish record for %q: %v", target, err)
+		return
+	}
+	if rec == nil || len(rec.EncryptionKeyID) == 0 {
+		return
+	}
+
+	keyID, err := parseFscryptKeyID(rec.EncryptionKeyID)
+	if err == nil {
+		err = fscryptRemoveKey(target, keyID)
+	}
+	if err != nil {
+		klog.Warningf("failed to lock encrypted volume %s at %q: %v", rec.VolumeID, target, err)
+	}
+}
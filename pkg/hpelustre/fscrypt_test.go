@@ -0,0 +1,80 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetEncryptionKey(t *testing.T) {
+	key := strings.Repeat("k", fscryptMasterKeySize)
+
+	tests := []struct {
+		desc    string
+		secrets map[string]string
+		code    codes.Code
+	}{
+		{
+			desc:    "key",
+			secrets: map[string]string{SecretEncryptionKey: key},
+		},
+		{
+			desc: "no secret",
+			code: codes.InvalidArgument,
+		},
+		{
+			desc:    "other secret",
+			secrets: map[string]string{"sskKey": key},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "short key",
+			secrets: map[string]string{SecretEncryptionKey: key[1:]},
+			code:    codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := getEncryptionKey(test.secrets)
+			assert.Equal(t, test.code, status.Code(err))
+			if test.code == codes.OK {
+				assert.Equal(t, key, got)
+			}
+		})
+	}
+}
+
+func TestParseFscryptKeyID(t *testing.T) {
+	id := fscryptKeyID{0x01, 0x23, 0xab}
+	parsed, err := parseFscryptKeyID(id.String())
+	assert.NoError(t, err)
+	assert.Equal(t, id, parsed)
+
+	_, err = parseFscryptKeyID("0123")
+	assert.Error(t, err)
+	_, err = parseFscryptKeyID(strings.Repeat("x", 2*len(id)))
+	assert.Error(t, err)
+}
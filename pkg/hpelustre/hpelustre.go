@@ -17,12 +17,20 @@ limitations under the License.
 package hpelustre
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	csicommon "github.com/HewlettPackard/lustre-csi-driver/pkg/csi-common"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
@@ -32,6 +40,9 @@ const (
 	DefaultLustreFsName = "lustrefs"
 	separator           = "#"
 
+	// Mount option that kubelet uses to pass a pod's SELinux label
+	seLinuxContextOption = "context="
+
 	podNameKey            = "csi.storage.k8s.io/pod.name"
 	podNamespaceKey       = "csi.storage.k8s.io/pod.namespace"
 	podUIDKey             = "csi.storage.k8s.io/pod.uid"
@@ -39,14 +50,23 @@ const (
 	pvcNameKey            = "csi.storage.k8s.io/pvc/name"
 	pvcNamespaceKey       = "csi.storage.k8s.io/pvc/namespace"
 	pvNameKey             = "csi.storage.k8s.io/pv/name"
+	ephemeralKey          = "csi.storage.k8s.io/ephemeral"
+
+	// Variables of sub-dir templates, referred to as ${pod.metadata.name}
+	podNameMetadata            = "pod.metadata.name"
+	podNamespaceMetadata       = "pod.metadata.namespace"
+	podUIDMetadata             = "pod.metadata.uid"
+	serviceAccountNameMetadata = "serviceAccount.metadata.name"
+	pvcNameMetadata            = "pvc.metadata.name"
+	pvcNamespaceMetadata       = "pvc.metadata.namespace"
+	pvNameMetadata             = "pv.metadata.name"
+	nodeNameMetadata           = "node.name"
 
-	podNameMetadata            = "${pod.metadata.name}"
-	podNamespaceMetadata       = "${pod.metadata.namespace}"
-	podUIDMetadata             = "${pod.metadata.uid}"
-	serviceAccountNameMetadata = "${serviceAccount.metadata.name}"
-	pvcNameMetadata            = "${pvc.metadata.name}"
-	pvcNamespaceMetadata       = "${pvc.metadata.namespace}"
-	pvNameMetadata             = "${pv.metadata.name}"
+	// Prefixes of sub-dir template variables for the labels of the pod and
+	// the node, and for the driver's --sub-dir-variables
+	podLabelsPrefix      = "pod.labels."
+	nodeLabelsPrefix     = "node.labels."
+	driverVariablePrefix = "driver."
 )
 
 var (
@@ -67,6 +87,7 @@ var (
 	nodeServiceCapabilities = []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
 	}
 )
 
@@ -76,6 +97,46 @@ type lustreVolume struct {
 	mgsIPAddress  string
 	hpeLustreName string
 	subDir        string
+
+	// Client-side encryption of the sub-dir, unlocked with encryptionKey from
+	// the node publish secret
+	encrypted     bool
+	encryptionKey string
+
+	// Ownership, permissions and encoded default ACL applied to a sub-dir
+	// that the driver creates. A negative value is not set.
+	uid        int64
+	gid        int64
+	mode       int64
+	defaultACL string
+
+	// What happens to the sub-dir on unpublish, if set, and the directory
+	// that it is archived into
+	subDirOnUnpublish string
+	subDirArchive     string
+
+	// Whether released files are restored, and other files prefetched, on
+	// publish, and the most bytes to restore and prefetch, or 0 for the
+	// driver's maximum
+	hsmOnPublish         string
+	hsmOnPublishMaxBytes int64
+
+	// PCC caching mode, backend and rule in the form that lctl pcc add
+	// takes, or "" for the project of the volume
+	pccMode    string
+	pccBackend string
+	pccRule    string
+
+	// Comma-separated patterns of the files to prefetch on publish, file in
+	// the volume with more of them, and whether publishing waits for the
+	// prefetch
+	prefetchPaths    string
+	prefetchManifest string
+	prefetchWait     bool
+
+	// Client parameters of the mount, in the form
+	// attribute1=value1,attribute2=value2, sorted by attribute
+	clientTuning string
 }
 
 // DriverOptions defines driver parameters specified in driver deployment
@@ -84,6 +145,67 @@ type DriverOptions struct {
 	DriverName               string
 	EnableHpeLustreMockMount bool
 	WorkingMountDir          string
+	// Enforce single-node access modes through ControllerPublishVolume
+	EnableAttach bool
+	// Namespace holding the driver's Kubernetes objects, such as attach leases
+	Namespace string
+	// Directory on the host for state that must survive a plugin restart
+	StateDir string
+	// tmpfs directory that holds SSK keys while they are in use
+	SSKKeyDir string
+	// Publish volumes to pods with user namespaces through idmapped mounts
+	EnableIdmappedMounts bool
+	// File with the policy of which namespaces may use which filesystems
+	AccessPolicyFile string
+	// Variables that sub-dir templates may refer to as ${driver.<name>}
+	SubDirVariables map[string]string
+	// Create snapshots of sub-dir volumes by copying them
+	EnableSnapshots bool
+	// Directory below the root of each filesystem that holds its snapshots
+	SnapshotsDir string
+	// Create sub-dir volumes through CreateVolume
+	EnableProvisioning bool
+	// Populate new volumes from snapshots and other volumes by copying them
+	EnableVolumeCopy bool
+	// Report the free space of filesystems and OST pools through GetCapacity
+	EnableCapacity bool
+	// Number of files that are copied at the same time for a snapshot or a
+	// new volume
+	CopyWorkers int
+	// Directory below the root of each filesystem that deleted volumes are
+	// moved into
+	TrashDir string
+	// Time that a deleted volume stays in the trash before it is removed
+	TrashTTL time.Duration
+	// Time between collections of the trash
+	TrashCollectInterval time.Duration
+	// Number of files that are removed at the same time from the trash
+	TrashWorkers int
+	// Most bytes of a volume that are restored from the HSM archive or
+	// prefetched when it is published
+	HsmPublishMaxBytes int64
+	// Directory below the root of each filesystem that deleted volumes are
+	// moved into once their files are archived and released
+	HsmArchiveDir string
+	// File with the PCC backends of the node that volumes may be cached in
+	PCCConfigFile string
+	// Number of files that are prefetched at the same time on the node
+	PrefetchWorkers int
+	// Time between resyncs of the stale files of mirrored volumes, or 0 to
+	// not resync them
+	MirrorResyncInterval time.Duration
+	// File with the Lustre client parameters of the node, the time between
+	// reconciliations of them, and the patterns of the parameters that the
+	// file may set
+	ClientParamsFile     string
+	ClientParamsInterval time.Duration
+	ClientParamAllowlist []string
+	// File with the LNet configuration of the node, the time between
+	// reconciliations of LNet with it, and whether LNet is changed even while
+	// Lustre filesystems are mounted
+	LnetConfigFile     string
+	LnetConfigInterval time.Duration
+	LnetForce          bool
 
 	// Used for testing. Allows the .spec.csi.volumeHandle to be swapped with
 	// another value.
@@ -106,6 +228,88 @@ type Driver struct {
 	workingMountDir  string
 	kernelModuleLock sync.Mutex
 
+	enableAttach   bool
+	namespace      string
+	kubeClient     kubernetes.Interface
+	kubeClientLock sync.Mutex
+
+	stateDir         string
+	sskKeyDir        string
+	publishStateLock sync.Mutex
+
+	enableIdmappedMounts bool
+
+	accessPolicyFile    string
+	accessPolicyLock    sync.Mutex
+	accessPolicy        *accessPolicy
+	accessPolicyModTime time.Time
+
+	subDirVariables map[string]string
+
+	enableSnapshots    bool
+	snapshotsDir       string
+	snapshotLock       sync.Mutex
+	snapshotCopies     map[string]*snapshotCopy
+	snapshotOperations map[string]struct{}
+	snapshotListLock   sync.Mutex
+
+	enableProvisioning  bool
+	enableVolumeCopy    bool
+	enableCapacity      bool
+	volumeLock          sync.Mutex
+	volumeCopies        map[string]*backgroundCopy
+	capacityLock        sync.Mutex
+	volumeModifications map[string]*backgroundCopy
+	eventRecorderLock   sync.Mutex
+	eventRecorder       record.EventRecorder
+	inventoryLock       sync.Mutex
+	volumeListingLock   sync.Mutex
+	volumeListing       *volumeListing
+
+	managedParentsLock sync.Mutex
+	managedParents     map[managedParent]struct{}
+
+	copyWorkers int
+
+	trashDir             string
+	trashTTL             time.Duration
+	trashCollectInterval time.Duration
+	trashWorkers         int
+
+	hsmPublishMaxBytes int64
+	hsmArchiveDir      string
+	hsmPublishLock     sync.Mutex
+	hsmPublishTasks    map[string]*backgroundCopy
+
+	pccConfigFile    string
+	pccConfigLock    sync.Mutex
+	pccConfig        *pccConfig
+	pccConfigModTime time.Time
+
+	idmapLock  sync.Mutex
+	idmapTasks map[string]*backgroundCopy
+
+	prefetchWorkers int
+	prefetchSlots   chan struct{}
+	prefetchLock    sync.Mutex
+	prefetchTasks   map[string]*backgroundCopy
+	// Volume IDs of the targets in prefetchTasks
+	prefetchVolumes map[string]string
+
+	mirrorResyncInterval time.Duration
+
+	clientParamsFile     string
+	clientParamsInterval time.Duration
+	clientParamAllowlist []string
+	clientParamsKick     chan struct{}
+	// Only used by the reconciler
+	clientParamsConfig  *clientParamsConfig
+	clientParamsModTime time.Time
+
+	lnetConfigFile     string
+	lnetConfigInterval time.Duration
+	lnetForce          bool
+
 	// Used for testing. Allows the .spec.csi.volumeHandle to be swapped with
 	// another value. The "type" indicates the type of the new volume
 	// (e.g., "xfs", "ext4", etc.).
@@ -120,6 +324,45 @@ func NewDriver(options *DriverOptions) *Driver {
 	d := Driver{
 		enableHpeLustreMockMount: options.EnableHpeLustreMockMount,
 		workingMountDir:          options.WorkingMountDir,
+		enableAttach:             options.EnableAttach,
+		namespace:                options.Namespace,
+		stateDir:                 options.StateDir,
+		sskKeyDir:                options.SSKKeyDir,
+		enableIdmappedMounts:     options.EnableIdmappedMounts,
+		accessPolicyFile:         options.AccessPolicyFile,
+		subDirVariables:          options.SubDirVariables,
+		enableSnapshots:          options.EnableSnapshots,
+		snapshotsDir:             strings.Trim(options.SnapshotsDir, "/"),
+		snapshotCopies:           map[string]*snapshotCopy{},
+		snapshotOperations:       map[string]struct{}{},
+		enableProvisioning:       options.EnableProvisioning,
+		enableVolumeCopy:         options.EnableVolumeCopy,
+		enableCapacity:           options.EnableCapacity,
+		volumeCopies:             map[string]*backgroundCopy{},
+		volumeModifications:      map[string]*backgroundCopy{},
+		copyWorkers:              options.CopyWorkers,
+		trashDir:                 strings.Trim(options.TrashDir, "/"),
+		trashTTL:                 options.TrashTTL,
+		trashCollectInterval:     options.TrashCollectInterval,
+		trashWorkers:             options.TrashWorkers,
+		hsmPublishMaxBytes:       options.HsmPublishMaxBytes,
+		hsmArchiveDir:            strings.Trim(options.HsmArchiveDir, "/"),
+		hsmPublishTasks:          map[string]*backgroundCopy{},
+		pccConfigFile:            options.PCCConfigFile,
+		prefetchWorkers:          options.PrefetchWorkers,
+		prefetchSlots:            make(chan struct{}, max(options.PrefetchWorkers, 1)),
+		idmapTasks:               map[string]*backgroundCopy{},
+		prefetchTasks:            map[string]*backgroundCopy{},
+		prefetchVolumes:          map[string]string{},
+		mirrorResyncInterval:     options.MirrorResyncInterval,
+		clientParamsFile:         options.ClientParamsFile,
+		clientParamsInterval:     options.ClientParamsInterval,
+		clientParamAllowlist:     options.ClientParamAllowlist,
+		clientParamsKick:         make(chan struct{}, 1),
+		lnetConfigFile:           options.LnetConfigFile,
+		lnetConfigInterval:       options.LnetConfigInterval,
+		lnetForce:                options.LnetForce,
+		managedParents:           map[managedParent]struct{}{},
 	}
 	d.Name = options.DriverName
 	d.Version = driverVersion
@@ -156,10 +399,90 @@ func (d *Driver) Run(endpoint string, testBool bool) {
 		klog.Fatalf("Mounter does not support force unmount")
 	}
 
+	controllerCaps := append([]csi.ControllerServiceCapability_RPC_Type{}, controllerServiceCapabilities...)
+	if d.enableAttach {
+		// The node plugin only checks the publish context, so the client for
+		// the attach leases is created the first time the controller needs it.
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
+	}
+	if d.enableProvisioning {
+		if !ensureStrictSubpath(d.trashDir) {
+			klog.Fatalf("trash directory %q must be strict subpath", d.trashDir)
+		}
+		if isSubpathOf(d.trashDir, d.snapshotsDir) || isSubpathOf(d.snapshotsDir, d.trashDir) {
+			klog.Fatalf("trash directory %q must not overlap snapshots directory %q", d.trashDir, d.snapshotsDir)
+		}
+		if !ensureStrictSubpath(d.hsmArchiveDir) {
+			klog.Fatalf("HSM archive directory %q must be strict subpath", d.hsmArchiveDir)
+		}
+		for _, dir := range []string{d.trashDir, d.snapshotsDir} {
+			if isSubpathOf(d.hsmArchiveDir, dir) || isSubpathOf(dir, d.hsmArchiveDir) {
+				klog.Fatalf("HSM archive directory %q must not overlap %q", d.hsmArchiveDir, dir)
+			}
+		}
+		if d.trashCollectInterval <= 0 {
+			klog.Fatalf("trash collect interval must be positive")
+		}
+		go d.runTrashCollector(context.Background())
+		if d.mirrorResyncInterval > 0 {
+			go d.runMirrorResync(context.Background())
+		}
+		controllerCaps = append(controllerCaps,
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+			csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+			csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+		)
+		if d.enableAttach {
+			controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES)
+		}
+	}
+	if d.enableCapacity {
+		if !d.enableProvisioning {
+			klog.Fatalf("capacity requires provisioning to be enabled")
+		}
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_GET_CAPACITY)
+	}
+	if d.enableVolumeCopy {
+		if !d.enableProvisioning {
+			klog.Fatalf("volume copy requires provisioning to be enabled")
+		}
+		controllerCaps = append(controllerCaps, csi.ControllerServiceCapability_RPC_CLONE_VOLUME)
+	}
+	if d.enableSnapshots {
+		if !ensureStrictSubpath(d.snapshotsDir) {
+			klog.Fatalf("snapshots directory %q must be strict subpath", d.snapshotsDir)
+		}
+		controllerCaps = append(controllerCaps,
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		)
+	}
+
+	if len(d.pccConfigFile) != 0 {
+		prometheus.MustRegister(&pccCollector{d: d})
+	}
+	if len(d.lnetConfigFile) != 0 {
+		if d.lnetConfigInterval <= 0 {
+			klog.Fatalf("LNet configuration interval must be positive")
+		}
+		// LNet is configured before any volume is published.
+		d.reconcileLnetOnce()
+		nids := d.updateLnetNIDs(nil)
+		go d.runLnetConfig(context.Background(), nids)
+	}
+	if len(d.clientParamsFile) != 0 {
+		if d.clientParamsInterval <= 0 {
+			klog.Fatalf("client parameters interval must be positive")
+		}
+		go d.runClientParams(context.Background())
+	}
+
 	// TODO_JUSJIN: revisit these caps
 	// Initialize default library driver
 	// TODO_CHYIN: move this to {service}.go
-	d.AddControllerServiceCapabilities(controllerServiceCapabilities)
+	d.AddControllerServiceCapabilities(controllerCaps)
 	d.AddVolumeCapabilityAccessModes(volumeCapabilities)
 	d.AddNodeServiceCapabilities(nodeServiceCapabilities)
 
@@ -169,11 +492,67 @@ func (d *Driver) Run(endpoint string, testBool bool) {
 	s.Wait()
 }
 
+// newKubeClient returns a client for the cluster that the driver is running in
+func newKubeClient() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not get in-cluster config: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create Kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+// getKubeClient returns the driver's client for the cluster, creating it the
+// first time that it is needed.
+func (d *Driver) getKubeClient() (kubernetes.Interface, error) {
+	d.kubeClientLock.Lock()
+	defer d.kubeClientLock.Unlock()
+
+	if d.kubeClient == nil {
+		client, err := newKubeClient()
+		if err != nil {
+			return nil, err
+		}
+		d.kubeClient = client
+	}
+	return d.kubeClient, nil
+}
+
 func IsCorruptedDir(dir string) bool {
 	_, pathErr := mount.PathExists(dir)
 	return pathErr != nil && mount.IsCorruptedMnt(pathErr)
 }
 
+// parseVolumeHandle splits a Lustre mount source, "<mgs nids>:/<fsname>", into
+// the MGS NIDs and the filesystem name.
+func parseVolumeHandle(handle string) (string, string, error) {
+	i := strings.LastIndex(handle, ":/")
+	if i <= 0 || len(strings.Trim(handle[i+2:], "/")) == 0 {
+		return "", "", status.Errorf(codes.InvalidArgument,
+			"volume ID %q is not a Lustre mount source of the form <mgs>:/<fsname>", handle)
+	}
+
+	return handle[:i], strings.Trim(handle[i+2:], "/"), nil
+}
+
+// The volume ID of a volume that is a sub-dir of a filesystem, such as one
+// created by the controller, is "<mgs nids>:/<fsname>#<sub-dir>".
+
+// splitVolumeID splits a volume ID into the volume handle of its filesystem
+// and its sub-dir, which is empty for a volume that is a whole filesystem.
+func splitVolumeID(volumeID string) (string, string) {
+	handle, subDir, _ := strings.Cut(volumeID, separator)
+	return handle, subDir
+}
+
+// makeSubDirVolumeID returns the volume ID of a sub-dir of a filesystem.
+func makeSubDirVolumeID(handle, subDir string) string {
+	return handle + separator + subDir
+}
+
 func getLustreVolFromID(id string) (*lustreVolume, error) {
 	segments := strings.Split(id, separator)
 	if len(segments) < 3 {
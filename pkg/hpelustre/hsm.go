@@ -0,0 +1,435 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// Filesystems that are backed by an HSM copytool may have files whose data
+// has been released to the archive, and the first read of such a file stalls
+// until the copytool restores it. A volume can ask for its released files to be
+// restored when it is published, and for its other files to be read into the
+// page cache of the node as well, in the background and up to a number of
+// bytes.
+//
+// A volume whose hsm-on-delete mutable parameter is archive is not removed
+// from the trash when it is deleted. The trash collector archives its files
+// instead, releases them once they are archived, and then moves the volume
+// into the HSM archive directory of its filesystem.
+
+// Values of VolumeContextHsmOnPublish
+const (
+	HsmOnPublishRestore  = "restore"
+	HsmOnPublishPrefetch = "prefetch"
+)
+
+// Values of ParameterHsmOnDelete
+const (
+	HsmOnDeleteRemove  = "remove"
+	HsmOnDeleteArchive = "archive"
+)
+
+const (
+	// Extended attribute of a volume directory that is archived rather than
+	// removed once it is deleted, which holds the ID of the archive, or 0 for
+	// the default archive of the copytool
+	hsmArchiveIDXattr = "trusted.lustre-csi.hsm-archive-id"
+
+	// Number of files that are passed to lfs at a time
+	hsmBatchSize = 100
+
+	// Number of files of a volume whose HSM state ControllerGetVolume reports
+	hsmStateScanLimit = 1000
+
+	// HSM action of a file that has none in progress
+	hsmActionNone = "NOOP"
+
+	prefetchBufferSize = 1 << 20
+)
+
+type hsmFile struct {
+	path string
+	size int64
+}
+
+// forEachHsmBatch calls fn with the HSM state of the regular files below path,
+// hsmBatchSize files at a time, up to limit files if it is positive. fn stops
+// the walk by returning fs.SkipAll.
+func (d *Driver) forEachHsmBatch(
+	ctx context.Context, path string, limit int,
+	fn func(files []hsmFile, states map[string]volumehelper.LfsHsmState) error,
+) error {
+	batch := []hsmFile{}
+	count := 0
+	stopped := false
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		args := []string{"hsm_state"}
+		for _, file := range batch {
+			args = append(args, file.path)
+		}
+		output, err := d.runLfsContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+		states, err := volumehelper.ParseLfsHsmState(output)
+		if err != nil {
+			return err
+		}
+		err = fn(batch, states)
+		batch = []hsmFile{}
+		if errors.Is(err, fs.SkipAll) {
+			stopped = true
+		}
+		return err
+	}
+
+	err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if limit > 0 && count >= limit {
+			return fs.SkipAll
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		batch = append(batch, hsmFile{path: file, size: info.Size()})
+		count++
+		if len(batch) < hsmBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil || stopped {
+		return err
+	}
+	if err := flush(); err != nil && !errors.Is(err, fs.SkipAll) {
+		return err
+	}
+	return nil
+}
+
+// startHsmPublish restores the released files of a volume that was just
+// published at target, and prefetches its other files if the volume asks for
+// it, in the background. At most the smaller of the volume's and the
+// driver's maximum number of bytes are restored and prefetched.
+func (d *Driver) startHsmPublish(volumeID, target string, vol *lustreVolume) {
+	maxBytes := d.hsmPublishMaxBytes
+	if vol.hsmOnPublishMaxBytes > 0 && vol.hsmOnPublishMaxBytes < maxBytes {
+		maxBytes = vol.hsmOnPublishMaxBytes
+	}
+	prefetch := vol.hsmOnPublish == HsmOnPublishPrefetch
+
+	d.hsmPublishLock.Lock()
+	defer d.hsmPublishLock.Unlock()
+
+	if c, ok := d.hsmPublishTasks[target]; ok {
+		c.stop()
+	}
+	description := fmt.Sprintf("%s of volume %s at %s", vol.hsmOnPublish, volumeID, target)
+	d.hsmPublishTasks[target] = startBackgroundCopy(description, func(ctx context.Context) error {
+		return d.restoreFiles(ctx, target, prefetch, maxBytes)
+	})
+}
+
+// stopHsmPublish stops the restore of the volume published at target, if it
+// is still running, so that the volume can be unmounted.
+func (d *Driver) stopHsmPublish(target string) {
+	d.hsmPublishLock.Lock()
+	defer d.hsmPublishLock.Unlock()
+
+	if c, ok := d.hsmPublishTasks[target]; ok {
+		c.stop()
+		delete(d.hsmPublishTasks, target)
+	}
+}
+
+// restoreFiles asks the copytool to restore the released files below target,
+// and reads the others if prefetch is set, until maxBytes would be exceeded.
+func (d *Driver) restoreFiles(ctx context.Context, target string, prefetch bool, maxBytes int64) error {
+	var restored, prefetched, bytes int64
+
+	err := d.forEachHsmBatch(ctx, target, 0, func(files []hsmFile, states map[string]volumehelper.LfsHsmState) error {
+		restore := []string{}
+		var stop error
+		for _, file := range files {
+			released := states[file.path].Has(volumehelper.HsmReleased)
+			if !released && !prefetch {
+				continue
+			}
+			if bytes+file.size > maxBytes {
+				stop = fs.SkipAll
+				break
+			}
+			if released {
+				restore = append(restore, file.path)
+				bytes += file.size
+				continue
+			}
+
+			n, err := prefetchFile(ctx, file.path)
+			bytes += n
+			if ctx.Err() != nil {
+				return ctx.Err()
+			} else if err != nil {
+				klog.V(4).Infof("could not prefetch %q: %v", file.path, err)
+				continue
+			}
+			prefetched++
+		}
+
+		if len(restore) != 0 {
+			if _, err := d.runLfsContext(ctx, append([]string{"hsm_restore"}, restore...)...); err != nil {
+				return err
+			}
+			restored += int64(len(restore))
+		}
+		return stop
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("requested restore of %d released files and prefetched %d files of %s, %d bytes in all",
+		restored, prefetched, target, bytes)
+	return nil
+}
+
+// prefetchFile reads a file into the page cache, and returns the number of
+// bytes that were read.
+func prefetchFile(ctx context.Context, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, prefetchBufferSize)
+	var total int64
+	for ctx.Err() == nil {
+		n, err := f.Read(buf)
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+	}
+	return total, ctx.Err()
+}
+
+// setHsmOnDelete records what happens to a volume directory once the volume
+// is deleted.
+func setHsmOnDelete(path, policy string, archiveID uint32) error {
+	var err error
+	if policy == HsmOnDeleteArchive {
+		err = unix.Lsetxattr(path, hsmArchiveIDXattr, []byte(strconv.FormatUint(uint64(archiveID), 10)), 0)
+	} else if err = unix.Lremovexattr(path, hsmArchiveIDXattr); errors.Is(err, unix.ENODATA) {
+		err = nil
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to set HSM policy of %q: %v", path, err)
+	}
+	return nil
+}
+
+// getHsmArchiveID returns the archive that a volume directory is archived
+// into once it is deleted, and whether it is archived rather than removed.
+func getHsmArchiveID(path string) (uint32, bool) {
+	buf := make([]byte, 16)
+	n, err := unix.Lgetxattr(path, hsmArchiveIDXattr, buf)
+	if err != nil {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(string(buf[:n]), 10, 32)
+	if err != nil {
+		klog.Warningf("ignoring invalid HSM archive ID %q of %q", buf[:n], path)
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// archiveTrashVolume archives the files of a deleted volume in the trash
+// into an archive, skipping those that have an HSM action in progress, and
+// releases the files that are already archived. Once every file has been
+// archived and released, the volume is moved into the HSM archive directory
+// and true is returned. Files that the copytool may not archive are left as
+// they are.
+func (d *Driver) archiveTrashVolume(ctx context.Context, root, path string, archiveID uint32) (bool, error) {
+	pending := 0
+
+	err := d.forEachHsmBatch(ctx, path, 0, func(files []hsmFile, states map[string]volumehelper.LfsHsmState) error {
+		archive, release := []string{}, []string{}
+		for _, file := range files {
+			state := states[file.path]
+			switch {
+			case state.Has(volumehelper.HsmNoArchive), state.Has(volumehelper.HsmReleased):
+			case !state.Has(volumehelper.HsmArchived), state.Has(volumehelper.HsmDirty):
+				archive = append(archive, file.path)
+				pending++
+			case !state.Has(volumehelper.HsmNoRelease):
+				release = append(release, file.path)
+			}
+		}
+
+		archive, err := d.withoutHsmActions(ctx, archive)
+		if err != nil {
+			return err
+		}
+		if len(archive) != 0 {
+			args := []string{"hsm_archive"}
+			if archiveID != 0 {
+				args = append(args, "--archive", strconv.FormatUint(uint64(archiveID), 10))
+			}
+			if _, err := d.runLfsContext(ctx, append(args, archive...)...); err != nil {
+				return err
+			}
+		}
+		if len(release) != 0 {
+			if _, err := d.runLfsContext(ctx, append([]string{"hsm_release"}, release...)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil || pending != 0 {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, d.hsmArchiveDir), 0o700); err != nil {
+		return false, fmt.Errorf("failed to make HSM archive directory: %w", err)
+	}
+	archivePath, _, err := checkSubDir(root, d.hsmArchiveDir)
+	if err != nil {
+		return false, fmt.Errorf("refusing to move volume into HSM archive directory: %s", status.Convert(err).Message())
+	}
+	target := filepath.Join(archivePath, filepath.Base(path))
+	if err := os.Rename(path, target); err != nil {
+		return false, fmt.Errorf("failed to move %q to %q: %w", path, target, err)
+	}
+
+	return true, nil
+}
+
+// withoutHsmActions returns the files that have no HSM action in progress.
+func (d *Driver) withoutHsmActions(ctx context.Context, files []string) ([]string, error) {
+	if len(files) == 0 {
+		return files, nil
+	}
+	output, err := d.runLfsContext(ctx, append([]string{"hsm_action"}, files...)...)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := volumehelper.ParseLfsHsmAction(output)
+	if err != nil {
+		return nil, err
+	}
+
+	idle := []string{}
+	for _, file := range files {
+		if actions[file] == hsmActionNone {
+			idle = append(idle, file)
+		}
+	}
+	return idle, nil
+}
+
+// addHsmCondition adds the HSM state of the first files of a volume
+// directory, and what happens to it once it is deleted, to its condition.
+// The volume is abnormal if a released file has been lost from the archive.
+func (d *Driver) addHsmCondition(ctx context.Context, path string, condition *csi.VolumeCondition) {
+	messages := []string{}
+	if len(condition.GetMessage()) != 0 {
+		messages = append(messages, condition.GetMessage())
+	}
+
+	var scanned, managed, archived, released, dirty, lost int
+	err := d.forEachHsmBatch(ctx, path, hsmStateScanLimit, func(files []hsmFile, states map[string]volumehelper.LfsHsmState) error {
+		for _, file := range files {
+			state := states[file.path]
+			scanned++
+			if !state.Has(volumehelper.HsmExists) {
+				continue
+			}
+			managed++
+			if state.Has(volumehelper.HsmArchived) {
+				archived++
+			}
+			if state.Has(volumehelper.HsmReleased) {
+				released++
+			}
+			if state.Has(volumehelper.HsmDirty) {
+				dirty++
+			}
+			if state.Has(volumehelper.HsmLost | volumehelper.HsmReleased) {
+				lost++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		klog.V(4).Infof("could not get HSM state of %q: %v", path, err)
+	} else if managed != 0 {
+		messages = append(messages, fmt.Sprintf(
+			"HSM state of the first %d files: %d archived, %d released, %d dirty, %d released and lost",
+			scanned, archived, released, dirty, lost))
+		if lost != 0 {
+			condition.Abnormal = true
+		}
+	}
+
+	if archiveID, ok := getHsmArchiveID(path); ok && archiveID == 0 {
+		messages = append(messages, "Volume is archived into the default HSM archive once it is deleted")
+	} else if ok {
+		messages = append(messages, fmt.Sprintf("Volume is archived into HSM archive %d once it is deleted", archiveID))
+	}
+
+	condition.Message = strings.Join(messages, "; ")
+}
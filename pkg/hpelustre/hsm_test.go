@@ -0,0 +1,264 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+)
+
+func TestParseHsmOnDelete(t *testing.T) {
+	tests := []struct {
+		desc     string
+		params   map[string]string
+		expected *volumeModification
+		code     codes.Code
+	}{
+		{
+			desc:     "archive on delete",
+			params:   map[string]string{"hsm-on-delete": "archive", "hsm-archive-id": "2"},
+			expected: &volumeModification{hsmOnDelete: ptr.To(HsmOnDeleteArchive), hsmArchiveID: 2},
+		},
+		{
+			desc:     "remove on delete",
+			params:   map[string]string{"HSM-On-Delete": "remove"},
+			expected: &volumeModification{hsmOnDelete: ptr.To(HsmOnDeleteRemove)},
+		},
+		{
+			desc:   "unknown HSM delete policy",
+			params: map[string]string{"hsm-on-delete": "release"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "archive ID with remove on delete",
+			params: map[string]string{"hsm-on-delete": "remove", "hsm-archive-id": "2"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "archive ID 0",
+			params: map[string]string{"hsm-on-delete": "archive", "hsm-archive-id": "0"},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			m, err := parseMutableParameters(test.params)
+			require.Equal(t, test.code, status.Code(err), "%v", err)
+			if test.code != codes.OK {
+				return
+			}
+			assert.Equal(t, test.expected, m)
+			assert.False(t, m.changesLayout())
+		})
+	}
+}
+
+// writeHsmFiles creates files of the given sizes in dir, and returns their
+// paths in the order that they are walked.
+func writeHsmFiles(t *testing.T, dir string, sizes ...int) []string {
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	paths := []string{}
+	for i, size := range sizes {
+		path := filepath.Join(dir, fmt.Sprintf("%c", 'a'+i))
+		require.NoError(t, os.WriteFile(path, make([]byte, size), 0o644))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// hsmStateOutput returns the output of lfs hsm_state for files with the
+// given flags.
+func hsmStateOutput(paths []string, flags ...uint32) string {
+	lines := []string{}
+	for i, path := range paths {
+		lines = append(lines, fmt.Sprintf("%s: (0x%08x)", path, flags[i]))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestRestoreFiles(t *testing.T) {
+	const (
+		exists   = 0x1
+		released = 0xd
+	)
+
+	tests := []struct {
+		desc     string
+		prefetch bool
+		maxBytes int64
+		restore  []int
+		err      error
+	}{
+		{
+			desc:     "released files",
+			maxBytes: 100,
+			restore:  []int{0, 2},
+		},
+		{
+			desc:     "up to the maximum bytes",
+			maxBytes: 12,
+			restore:  []int{0},
+		},
+		{
+			desc:     "released and prefetched files",
+			prefetch: true,
+			maxBytes: 100,
+			restore:  []int{0, 2},
+		},
+		{
+			desc:     "restore fails",
+			maxBytes: 100,
+			restore:  []int{0, 2},
+			err:      fakeExitError{status: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d := NewDriver(&DriverOptions{})
+			paths := writeHsmFiles(t, t.TempDir(), 10, 20, 5)
+
+			restore := []string{"lfs", "hsm_restore"}
+			for _, i := range test.restore {
+				restore = append(restore, paths[i])
+			}
+			setFakeCommands(t, d,
+				fakeCommand{
+					argv:   append([]string{"lfs", "hsm_state"}, paths...),
+					output: hsmStateOutput(paths, released, exists, released),
+				},
+				fakeCommand{argv: restore, err: test.err},
+			)
+
+			err := d.restoreFiles(context.Background(), filepath.Dir(paths[0]), test.prefetch, test.maxBytes)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				assert.ErrorContains(t, err, "lfs hsm_restore")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRestoreFilesWithoutHsmState(t *testing.T) {
+	d := NewDriver(&DriverOptions{})
+	paths := writeHsmFiles(t, t.TempDir(), 10)
+	setFakeCommands(t, d, fakeCommand{
+		argv:   append([]string{"lfs", "hsm_state"}, paths...),
+		output: "Operation not supported",
+		err:    fakeExitError{status: 95},
+	})
+
+	err := d.restoreFiles(context.Background(), filepath.Dir(paths[0]), false, 100)
+	assert.ErrorContains(t, err, "lfs hsm_state")
+	assert.ErrorContains(t, err, "Operation not supported")
+}
+
+func TestArchiveTrashVolume(t *testing.T) {
+	const (
+		exists            = 0x1
+		archived          = 0x9
+		archivedDirty     = 0xb
+		released          = 0xd
+		archivedNoRelease = 0x19
+		noArchive         = 0x21
+	)
+
+	t.Run("archive and release", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{HsmArchiveDir: "archive/volumes"})
+		root := t.TempDir()
+		volume := filepath.Join(root, ".trash", "pvc-1234.20261019T132437.000000000Z")
+		paths := writeHsmFiles(t, volume, 1, 1, 1, 1, 1, 1)
+
+		setFakeCommands(t, d,
+			fakeCommand{
+				argv:   append([]string{"lfs", "hsm_state"}, paths...),
+				output: hsmStateOutput(paths, exists, archived, released, noArchive, archivedDirty, archivedNoRelease),
+			},
+			fakeCommand{
+				argv:   []string{"lfs", "hsm_action", paths[0], paths[4]},
+				output: paths[0] + ": NOOP\n" + paths[4] + ": ARCHIVE running (1048576 bytes moved)\n",
+			},
+			fakeCommand{argv: []string{"lfs", "hsm_archive", "--archive", "2", paths[0]}},
+			fakeCommand{argv: []string{"lfs", "hsm_release", paths[1]}},
+		)
+
+		moved, err := d.archiveTrashVolume(context.Background(), root, volume, 2)
+		require.NoError(t, err)
+		assert.False(t, moved)
+		assert.DirExists(t, volume)
+	})
+
+	t.Run("archived and released", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{HsmArchiveDir: "archive/volumes"})
+		root := t.TempDir()
+		volume := filepath.Join(root, ".trash", "pvc-1234.20261019T132437.000000000Z")
+		paths := writeHsmFiles(t, volume, 1, 1)
+
+		setFakeCommands(t, d, fakeCommand{
+			argv:   append([]string{"lfs", "hsm_state"}, paths...),
+			output: hsmStateOutput(paths, released, noArchive),
+		})
+
+		moved, err := d.archiveTrashVolume(context.Background(), root, volume, 0)
+		require.NoError(t, err)
+		assert.True(t, moved)
+		assert.NoDirExists(t, volume)
+		assert.DirExists(t, filepath.Join(root, "archive", "volumes", filepath.Base(volume)))
+	})
+
+	t.Run("archive fails", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{HsmArchiveDir: "archive/volumes"})
+		root := t.TempDir()
+		volume := filepath.Join(root, ".trash", "pvc-1234.20261019T132437.000000000Z")
+		paths := writeHsmFiles(t, volume, 1)
+		archiveErr := errors.New("no copytool")
+
+		setFakeCommands(t, d,
+			fakeCommand{
+				argv:   append([]string{"lfs", "hsm_state"}, paths...),
+				output: hsmStateOutput(paths, exists),
+			},
+			fakeCommand{
+				argv:   []string{"lfs", "hsm_action", paths[0]},
+				output: paths[0] + ": NOOP\n",
+			},
+			fakeCommand{argv: []string{"lfs", "hsm_archive", paths[0]}, err: archiveErr},
+		)
+
+		moved, err := d.archiveTrashVolume(context.Background(), root, volume, 0)
+		assert.ErrorIs(t, err, archiveErr)
+		assert.False(t, moved)
+		assert.DirExists(t, volume)
+	})
+}
@@ -0,0 +1,355 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// A pod with "hostUsers: false" runs in a user namespace, so files owned by
+// host IDs outside of its mappings show up as the overflow ID. When idmapped
+// mounts are enabled, the Lustre mount at the target is replaced with an
+// idmapped mount that uses the pod's own ID mappings, so that a file's owner
+// on Lustre is the owner seen in the pod.
+//
+// kubelet mounts a pod's volumes before it creates the pod's sandbox, and it
+// only allocates the ID mappings of the pod, and records them in the pod's
+// directory, when it creates the sandbox, or when it sets up another volume
+// of the pod that needs them. The mappings are therefore usually not known
+// when the volume is published. The volume is then idmapped in the background
+// once kubelet has recorded the mappings, which it does before asking the
+// container runtime to run the sandbox, and so before it creates any container
+// of the pod with the volume. Nothing orders the background idmap against
+// kubelet, though. If the mappings are only found after kubelet has created a
+// container, the volume is left without ID mapping rather than giving the
+// pod's containers different mounts. kubelet may still create a container in
+// the moment between that check and the replacement of the mount.
+
+const (
+	// kubelet records the ID mappings of a pod's user namespace in this file
+	// in the pod's directory.
+	kubeletUserNamespaceFile = "userns"
+	// kubelet creates this directory in the pod's directory when it creates
+	// the pod's first container, after any ID mappings have been recorded.
+	kubeletContainersDir = "containers"
+
+	// How often, and for how long, the pod's directory is checked for the ID
+	// mappings of a volume that is idmapped in the background
+	idmapPollInterval = 50 * time.Millisecond
+	idmapWaitTimeout  = 10 * time.Minute
+)
+
+var (
+	errIdmapUnsupported = errors.New("idmapped mounts are not supported")
+	// The user namespace file cannot be parsed, as kubelet may still be
+	// writing it.
+	errUserNamespaceIncomplete = errors.New("user namespace is not completely recorded")
+	// A container of the pod uses the volume as it was mounted, so the mount
+	// must no longer be replaced.
+	errPodStarted = errors.New("a container of the pod has already been created")
+)
+
+type idMapping struct {
+	ContainerID uint32 `json:"containerId"`
+	HostID      uint32 `json:"hostId"`
+	Length      uint32 `json:"length"`
+}
+
+type podUserNamespace struct {
+	UIDMappings []idMapping `json:"uidMappings"`
+	GIDMappings []idMapping `json:"gidMappings"`
+}
+
+// podDirFromTarget returns kubelet's directory for the pod, which contains the
+// pod's volume target paths.
+func podDirFromTarget(target, podUID string) (string, bool) {
+	if len(podUID) == 0 {
+		return "", false
+	}
+
+	marker := string(filepath.Separator) + filepath.Join("pods", podUID) + string(filepath.Separator)
+	i := strings.Index(target, marker)
+	if i < 0 {
+		return "", false
+	}
+
+	return target[:i+len(marker)-1], true
+}
+
+// loadPodUserNamespace returns the ID mappings of the pod's user namespace, or
+// nil if the pod uses the host's user namespace.
+func loadPodUserNamespace(podDir string) (*podUserNamespace, error) {
+	data, err := os.ReadFile(filepath.Join(podDir, kubeletUserNamespaceFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ns := &podUserNamespace{}
+	if err := json.Unmarshal(data, ns); err != nil {
+		return nil, fmt.Errorf("%w for pod %q: %v", errUserNamespaceIncomplete, podDir, err)
+	}
+	if len(ns.UIDMappings) == 0 || len(ns.GIDMappings) == 0 {
+		return nil, fmt.Errorf("user namespace of pod %q has no ID mappings", podDir)
+	}
+
+	return ns, nil
+}
+
+func toSysProcIDMap(mappings []idMapping) []syscall.SysProcIDMap {
+	idMap := make([]syscall.SysProcIDMap, 0, len(mappings))
+	for _, m := range mappings {
+		idMap = append(idMap, syscall.SysProcIDMap{
+			ContainerID: int(m.ContainerID),
+			HostID:      int(m.HostID),
+			Size:        int(m.Length),
+		})
+	}
+	return idMap
+}
+
+// openUserNamespace creates a user namespace with the pod's ID mappings and
+// returns a file descriptor for it. A multithreaded process cannot unshare its
+// own user namespace, so the namespace is created by a child process, which is
+// only kept until the namespace has been opened.
+func openUserNamespace(ns *podUserNamespace) (int, error) {
+	cmd := exec.Command("sleep", "infinity")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: toSysProcIDMap(ns.UIDMappings),
+		GidMappings: toSysProcIDMap(ns.GIDMappings),
+	}
+	if err := cmd.Start(); err != nil {
+		return -1, fmt.Errorf("could not create user namespace: %w", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("could not open user namespace: %w", err)
+	}
+
+	return fd, nil
+}
+
+// checkMountSetattr returns the error of setting the ID mapping of the mount at
+// target. The kernel returns EINVAL for a filesystem that does not allow
+// idmapped mounts, as Lustre does not, so EINVAL means that idmapped mounts are
+// not supported, along with ENOSYS and EOPNOTSUPP.
+func checkMountSetattr(target string, err error) error {
+	if errors.Is(err, unix.ENOSYS) {
+		return fmt.Errorf("%w by the kernel", errIdmapUnsupported)
+	} else if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		return fmt.Errorf("%w by the filesystem of %q: %v", errIdmapUnsupported, target, err)
+	} else if err != nil {
+		return fmt.Errorf("could not idmap mount %q: %w", target, err)
+	}
+	return nil
+}
+
+// idmapMount replaces the mount at target with an idmapped clone of it. The
+// mount is left as it is if the kernel or the filesystem does not support
+// idmapped mounts.
+func idmapMount(target string, usernsFd int) error {
+	fd, err := unix.OpenTree(unix.AT_FDCWD, target, unix.OPEN_TREE_CLONE|unix.O_CLOEXEC)
+	if errors.Is(err, unix.ENOSYS) {
+		return fmt.Errorf("%w by the kernel", errIdmapUnsupported)
+	} else if err != nil {
+		return fmt.Errorf("could not clone mount %q: %w", target, err)
+	}
+	defer unix.Close(fd)
+
+	attr := unix.MountAttr{
+		Attr_set:  unix.MOUNT_ATTR_IDMAP,
+		Userns_fd: uint64(usernsFd),
+	}
+	if err := checkMountSetattr(target, unix.MountSetattr(fd, "", unix.AT_EMPTY_PATH, &attr)); err != nil {
+		return err
+	}
+
+	// The detached clone holds the filesystem while the original mount is
+	// replaced.
+	if err := unix.Unmount(target, 0); err != nil {
+		return fmt.Errorf("could not unmount %q: %w", target, err)
+	}
+	if err := unix.MoveMount(fd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return fmt.Errorf("could not move idmapped mount to %q: %w", target, err)
+	}
+
+	return nil
+}
+
+// podStarted reports whether kubelet has created a container of the pod.
+func podStarted(podDir string) bool {
+	_, err := os.Stat(filepath.Join(podDir, kubeletContainersDir))
+	return err == nil
+}
+
+// waitForPodUserNamespace waits for kubelet to record the ID mappings of the
+// pod's user namespace. It returns nil if kubelet creates a container of the
+// pod without recording them, as the pod uses the host's user namespace, and
+// errPodStarted if the mappings were only found after a container of the pod
+// was created.
+func waitForPodUserNamespace(ctx context.Context, podDir string) (*podUserNamespace, error) {
+	ticker := time.NewTicker(idmapPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// The containers are checked first, so that the mappings of a pod
+		// with a user namespace are not missed in between. Until then, the
+		// mappings may be read while kubelet is still writing them.
+		started := podStarted(podDir)
+
+		ns, err := loadPodUserNamespace(podDir)
+		if started && ns != nil {
+			return nil, errPodStarted
+		} else if ns != nil || started {
+			return ns, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// idmapMountAsUserNamespace replaces the mount at target with an idmapped mount
+// that has the ID mappings of a user namespace.
+func idmapMountAsUserNamespace(target string, ns *podUserNamespace) error {
+	usernsFd, err := openUserNamespace(ns)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(usernsFd)
+
+	return idmapMount(target, usernsFd)
+}
+
+// idmapVolume gives the volume mounted at target the ID mappings of the pod's
+// user namespace. Nothing is done for pods that use the host's user namespace,
+// and the volume is left without ID mapping if idmapped mounts are not
+// supported. If kubelet has not yet recorded the pod's ID mappings, or is still
+// writing them, the volume is idmapped in the background once it has.
+func (d *Driver) idmapVolume(target string, context map[string]string) error {
+	if !d.enableIdmappedMounts {
+		return nil
+	}
+
+	podDir, ok := podDirFromTarget(target, context[podUIDKey])
+	if !ok {
+		klog.V(4).Infof("no pod directory for target %q, not idmapping the volume", target)
+		return nil
+	}
+
+	ns, err := loadPodUserNamespace(podDir)
+	if errors.Is(err, errUserNamespaceIncomplete) {
+		klog.V(4).Infof("%v, idmapping volume at %q in the background", err, target)
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "Could not get user namespace of pod: %v", err)
+	}
+	if ns == nil {
+		d.startIdmap(target, podDir)
+		return nil
+	}
+
+	if err := idmapMountAsUserNamespace(target, ns); errors.Is(err, errIdmapUnsupported) {
+		klog.Warningf("volume at %q is mounted without ID mapping: %v", target, err)
+		return nil
+	} else if err != nil {
+		return status.Errorf(codes.Internal, "Could not idmap %q: %v", target, err)
+	}
+
+	klog.V(2).Infof("volume at %q is idmapped to the pod's user namespace", target)
+	return nil
+}
+
+// startIdmap idmaps the volume published at target in the background, once
+// kubelet has recorded the ID mappings of the pod's user namespace. The mount
+// is only replaced while no container of the pod exists, as a container keeps
+// the mount that the volume had when it was created. The volume is left
+// without ID mapping if a container was created first.
+func (d *Driver) startIdmap(target, podDir string) {
+	d.idmapLock.Lock()
+	defer d.idmapLock.Unlock()
+
+	if c, ok := d.idmapTasks[target]; ok {
+		c.stop()
+	}
+
+	description := fmt.Sprintf("ID mappings of the pod to volume at %s", target)
+	d.idmapTasks[target] = startBackgroundCopy(description, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, idmapWaitTimeout)
+		defer cancel()
+
+		ns, err := waitForPodUserNamespace(ctx, podDir)
+		if errors.Is(err, errPodStarted) {
+			klog.Warningf("volume at %q is mounted without ID mapping: %v", target, err)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if ns == nil {
+			klog.V(4).Infof("pod of volume at %q uses the host's user namespace", target)
+			return nil
+		}
+
+		if err := idmapMountAsUserNamespace(target, ns); errors.Is(err, errIdmapUnsupported) {
+			klog.Warningf("volume at %q is mounted without ID mapping: %v", target, err)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		klog.V(2).Infof("volume at %q is idmapped to the pod's user namespace", target)
+		return nil
+	})
+}
+
+// stopIdmap stops idmapping the volume published at target in the background,
+// if it is still waiting, so that the volume can be unmounted.
+func (d *Driver) stopIdmap(target string) {
+	d.idmapLock.Lock()
+	defer d.idmapLock.Unlock()
+
+	if c, ok := d.idmapTasks[target]; ok {
+		c.stop()
+		delete(d.idmapTasks, target)
+	}
+}
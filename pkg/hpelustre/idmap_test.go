@@ -0,0 +1,192 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+const testUserNamespace = `{"uidMappings":[{"containerId":0,"hostId":65536,"length":65536}],"gidMappings":[{"containerId":0,"hostId":65536,"length":65536}]}`
+
+func TestPodDirFromTarget(t *testing.T) {
+	target := "/var/lib/kubelet/pods/1234/volumes/kubernetes.io~csi/pv/mount"
+
+	podDir, ok := podDirFromTarget(target, "1234")
+	require.True(t, ok)
+	assert.Equal(t, "/var/lib/kubelet/pods/1234", podDir)
+
+	_, ok = podDirFromTarget(target, "5678")
+	assert.False(t, ok)
+	_, ok = podDirFromTarget(target, "")
+	assert.False(t, ok)
+}
+
+// kubelet records the ID mappings of a pod after its volumes are published,
+// when it creates the pod's sandbox, and before it creates the pod's first
+// container.
+func TestWaitForPodUserNamespace(t *testing.T) {
+	t.Run("recorded after publish", func(t *testing.T) {
+		podDir := t.TempDir()
+		go func() {
+			time.Sleep(2 * idmapPollInterval)
+			_ = os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(testUserNamespace), 0600)
+		}()
+
+		ns, err := waitForPodUserNamespace(context.Background(), podDir)
+		require.NoError(t, err)
+		require.NotNil(t, ns)
+		assert.Equal(t, []idMapping{{ContainerID: 0, HostID: 65536, Length: 65536}}, ns.UIDMappings)
+	})
+
+	t.Run("host user namespace", func(t *testing.T) {
+		podDir := t.TempDir()
+		go func() {
+			time.Sleep(2 * idmapPollInterval)
+			_ = os.Mkdir(filepath.Join(podDir, kubeletContainersDir), 0750)
+		}()
+
+		ns, err := waitForPodUserNamespace(context.Background(), podDir)
+		require.NoError(t, err)
+		assert.Nil(t, ns)
+	})
+
+	t.Run("recorded after a container was created", func(t *testing.T) {
+		podDir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(podDir, kubeletContainersDir), 0750))
+		require.NoError(t, os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(testUserNamespace), 0600))
+
+		_, err := waitForPodUserNamespace(context.Background(), podDir)
+		assert.ErrorIs(t, err, errPodStarted)
+	})
+
+	t.Run("invalid mappings", func(t *testing.T) {
+		podDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(`{"uidMappings":[]}`), 0600))
+		require.NoError(t, os.Mkdir(filepath.Join(podDir, kubeletContainersDir), 0750))
+
+		_, err := waitForPodUserNamespace(context.Background(), podDir)
+		assert.Error(t, err)
+	})
+
+	t.Run("partly recorded", func(t *testing.T) {
+		podDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(testUserNamespace[:10]), 0600))
+		go func() {
+			time.Sleep(2 * idmapPollInterval)
+			_ = os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(testUserNamespace), 0600)
+		}()
+
+		ns, err := waitForPodUserNamespace(context.Background(), podDir)
+		require.NoError(t, err)
+		assert.NotNil(t, ns)
+	})
+
+	t.Run("not recorded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*idmapPollInterval)
+		defer cancel()
+
+		_, err := waitForPodUserNamespace(ctx, t.TempDir())
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestCheckMountSetattr(t *testing.T) {
+	tests := []struct {
+		desc        string
+		err         error
+		unsupported bool
+	}{
+		{desc: "success"},
+		{desc: "no kernel support", err: unix.ENOSYS, unsupported: true},
+		{desc: "no filesystem support", err: unix.EOPNOTSUPP, unsupported: true},
+		{desc: "filesystem does not allow idmapping", err: unix.EINVAL, unsupported: true},
+		{desc: "permission denied", err: unix.EPERM},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			err := checkMountSetattr("/target", test.err)
+			assert.Equal(t, test.err != nil, err != nil)
+			assert.Equal(t, test.unsupported, errors.Is(err, errIdmapUnsupported))
+		})
+	}
+}
+
+func TestIdmapVolume(t *testing.T) {
+	d := NewDriver(&DriverOptions{EnableIdmappedMounts: true})
+	podDir := filepath.Join(t.TempDir(), "pods", "1234")
+	target := filepath.Join(podDir, "volumes", "kubernetes.io~csi", "pv", "mount")
+	require.NoError(t, os.MkdirAll(target, 0750))
+	volumeContext := map[string]string{podUIDKey: "1234"}
+
+	t.Run("not recorded", func(t *testing.T) {
+		require.NoError(t, d.idmapVolume(target, volumeContext))
+		assert.Contains(t, d.idmapTasks, target)
+		d.stopIdmap(target)
+	})
+
+	t.Run("partly recorded", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(testUserNamespace[:10]), 0600))
+
+		require.NoError(t, d.idmapVolume(target, volumeContext))
+		assert.Contains(t, d.idmapTasks, target)
+		d.stopIdmap(target)
+	})
+
+	t.Run("no ID mappings", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(`{"uidMappings":[]}`), 0600))
+
+		assert.Error(t, d.idmapVolume(target, volumeContext))
+		assert.NotContains(t, d.idmapTasks, target)
+	})
+}
+
+// The mount of a volume must not be replaced once a container of the pod uses
+// it, so a volume whose pod started before kubelet recorded the ID mappings is
+// left as it was mounted.
+func TestStartIdmapAfterPodStarted(t *testing.T) {
+	d := NewDriver(&DriverOptions{EnableIdmappedMounts: true})
+	podDir := t.TempDir()
+	target := filepath.Join(podDir, "volumes", "kubernetes.io~csi", "pv", "mount")
+	require.NoError(t, os.MkdirAll(target, 0750))
+	require.NoError(t, os.Mkdir(filepath.Join(podDir, kubeletContainersDir), 0750))
+	require.NoError(t, os.WriteFile(filepath.Join(podDir, kubeletUserNamespaceFile), []byte(testUserNamespace), 0600))
+
+	d.startIdmap(target, podDir)
+	c := d.idmapTasks[target]
+	require.NotNil(t, c)
+
+	select {
+	case <-c.done:
+	case <-time.After(10 * idmapPollInterval):
+		t.Fatal("volume is still being idmapped")
+	}
+	assert.NoError(t, c.err)
+	d.stopIdmap(target)
+}
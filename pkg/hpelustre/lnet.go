@@ -0,0 +1,500 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// The node plugin can configure LNet on its node from a file, normally a
+// mounted ConfigMap, in the YAML form that lnetctl import takes, with the
+// networks, routes and discovery of the node. The configuration is
+// declarative: networks and routes that it does not have are deleted. Once per
+// interval, and when the node plugin starts, the node plugin compares LNet
+// with the file, and changes it with lnetctl only if no Lustre filesystem is
+// mounted on the node, unless it is forced to, as changing the networks under
+// a mount can make its servers unreachable.
+
+var (
+	lnetNID = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lustre_csi_lnet_nid_info",
+		Help: "Local NIDs of the node, other than those of the loopback network, with the value 1.",
+	}, []string{"nid"})
+	lnetInSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lustre_csi_lnet_config_in_sync",
+		Help: "Whether LNet matched its configuration after it was last reconciled.",
+	})
+	lnetBlocked = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lustre_csi_lnet_config_blocked",
+		Help: "Whether a change to LNet was refused in the last reconciliation because Lustre filesystems are mounted.",
+	})
+	lnetChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lustre_csi_lnet_config_changes_total",
+		Help: "Number of changes that have been made to LNet to match its configuration.",
+	})
+	lnetErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lustre_csi_lnet_config_errors_total",
+		Help: "Number of reconciliations of LNet that failed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(lnetNID, lnetInSync, lnetBlocked, lnetChanges, lnetErrors)
+}
+
+// parseLnetConfig parses an LNet configuration and checks that its networks
+// and routes are valid, and that its interfaces are on the node.
+func parseLnetConfig(data []byte) (*volumehelper.LnetConfig, error) {
+	config, err := volumehelper.ParseLnetConfig(data, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Net) == 0 {
+		return nil, fmt.Errorf("at least one network must be given")
+	}
+
+	netTypes := map[string]bool{}
+	for i := range config.Net {
+		n := &config.Net[i]
+		if err := validateLnetNetwork(n.NetType); err != nil {
+			return nil, fmt.Errorf("network %d: %w", i, err)
+		}
+		if n.NetType == "lo" {
+			return nil, fmt.Errorf("network lo is always configured and must not be given")
+		}
+		if netTypes[n.NetType] {
+			return nil, fmt.Errorf("network %s is given more than once", n.NetType)
+		}
+		netTypes[n.NetType] = true
+
+		interfaces := n.Interfaces()
+		if len(interfaces) == 0 {
+			return nil, fmt.Errorf("network %s must have an interface", n.NetType)
+		}
+		for _, name := range interfaces {
+			if _, err := net.InterfaceByName(name); err != nil {
+				return nil, fmt.Errorf("network %s: interface %q is not on the node: %w", n.NetType, name, err)
+			}
+		}
+	}
+
+	routes := map[string]bool{}
+	for _, route := range config.Route {
+		if err := validateLnetNetwork(route.Net); err != nil {
+			return nil, fmt.Errorf("route to %q: %w", route.Net, err)
+		}
+		if err := validateNID(route.Gateway); err != nil {
+			return nil, fmt.Errorf("route to %s: %w", route.Net, err)
+		}
+		key := route.Net + " " + route.Gateway
+		if routes[key] {
+			return nil, fmt.Errorf("route to %s through %s is given more than once", route.Net, route.Gateway)
+		}
+		routes[key] = true
+		if route.Hop != nil && (*route.Hop < -1 || *route.Hop == 0 || *route.Hop > 255) {
+			return nil, fmt.Errorf("route to %s: hop must be 1 to 255, or -1 for the default", route.Net)
+		}
+		if route.Priority != nil && *route.Priority < 0 {
+			return nil, fmt.Errorf("route to %s: priority must not be negative", route.Net)
+		}
+	}
+
+	if config.Global != nil && config.Global.Discovery != nil && *config.Global.Discovery != 0 && *config.Global.Discovery != 1 {
+		return nil, fmt.Errorf("discovery must be 0 or 1")
+	}
+
+	return config, nil
+}
+
+// validateLnetNetwork checks a network such as "tcp" or "o2ib1".
+func validateLnetNetwork(network string) error {
+	match := lnetNetworkRegexp.FindStringSubmatch(network)
+	if match == nil || !slices.Contains(lnetNetworkTypes, match[1]) {
+		return fmt.Errorf("network %q is unknown; the network type must be one of %s, optionally followed by a number",
+			network, strings.Join(lnetNetworkTypes, ", "))
+	}
+	return nil
+}
+
+// lnetChange is a change that makes LNet match its configuration.
+type lnetChange struct {
+	description string
+	// Arguments of lnetctl, or nil to import the configuration of add
+	args []string
+	add  *volumehelper.LnetConfig
+}
+
+// diffLnetConfig returns the changes that make the current LNet configuration
+// match the desired one, deleting what is not desired before adding what is
+// missing.
+func diffLnetConfig(current, desired *volumehelper.LnetConfig) []lnetChange {
+	changes := []lnetChange{}
+
+	desiredNets := map[string]*volumehelper.LnetNet{}
+	for i := range desired.Net {
+		desiredNets[desired.Net[i].NetType] = &desired.Net[i]
+	}
+	currentNets := map[string]*volumehelper.LnetNet{}
+	for i := range current.Net {
+		currentNets[current.Net[i].NetType] = &current.Net[i]
+	}
+
+	desiredRoutes := map[string]*volumehelper.LnetRoute{}
+	for i := range desired.Route {
+		route := &desired.Route[i]
+		desiredRoutes[route.Net+" "+route.Gateway] = route
+	}
+	currentRoutes := map[string]*volumehelper.LnetRoute{}
+	for i := range current.Route {
+		route := &current.Route[i]
+		currentRoutes[route.Net+" "+route.Gateway] = route
+	}
+
+	add := &volumehelper.LnetConfig{}
+
+	for _, route := range current.Route {
+		want, ok := desiredRoutes[route.Net+" "+route.Gateway]
+		if ok && sameLnetRoute(&route, want) {
+			continue
+		}
+		changes = append(changes, lnetChange{
+			description: fmt.Sprintf("delete route to %s through %s", route.Net, route.Gateway),
+			args:        []string{"route", "del", "--net", route.Net, "--gateway", route.Gateway},
+		})
+	}
+
+	for _, n := range current.Net {
+		if n.NetType == "lo" {
+			continue
+		}
+		want, ok := desiredNets[n.NetType]
+		if ok && slices.Equal(n.Interfaces(), want.Interfaces()) {
+			continue
+		}
+		changes = append(changes, lnetChange{
+			description: fmt.Sprintf("delete network %s on %s", n.NetType, strings.Join(n.Interfaces(), ",")),
+			args:        []string{"net", "del", "--net", n.NetType},
+		})
+	}
+
+	for _, n := range desired.Net {
+		have, ok := currentNets[n.NetType]
+		if ok && slices.Equal(have.Interfaces(), n.Interfaces()) {
+			continue
+		}
+		add.Net = append(add.Net, n)
+		changes = append(changes, lnetChange{
+			description: fmt.Sprintf("add network %s on %s", n.NetType, strings.Join(n.Interfaces(), ",")),
+		})
+	}
+
+	for _, route := range desired.Route {
+		have, ok := currentRoutes[route.Net+" "+route.Gateway]
+		if ok && sameLnetRoute(have, &route) {
+			continue
+		}
+		add.Route = append(add.Route, route)
+		changes = append(changes, lnetChange{
+			description: fmt.Sprintf("add route to %s through %s", route.Net, route.Gateway),
+		})
+	}
+
+	if desired.Global != nil && desired.Global.Discovery != nil {
+		want := *desired.Global.Discovery
+		if current.Global == nil || current.Global.Discovery == nil || *current.Global.Discovery != want {
+			changes = append(changes, lnetChange{
+				description: fmt.Sprintf("set discovery to %d", want),
+				args:        []string{"set", "discovery", strconv.Itoa(want)},
+			})
+		}
+	}
+
+	// The networks and routes to add are imported together, once the others
+	// have been deleted.
+	if len(add.Net) != 0 || len(add.Route) != 0 {
+		descriptions := []string{}
+		rest := []lnetChange{}
+		for _, change := range changes {
+			if change.args == nil {
+				descriptions = append(descriptions, change.description)
+			} else {
+				rest = append(rest, change)
+			}
+		}
+		changes = append(rest, lnetChange{description: strings.Join(descriptions, ", "), add: add})
+	}
+
+	return changes
+}
+
+// sameLnetRoute reports whether a current route has the hop and priority of a
+// desired one, where those that the desired route does not give may be any.
+func sameLnetRoute(current, desired *volumehelper.LnetRoute) bool {
+	if desired.Hop != nil && (current.Hop == nil || *current.Hop != *desired.Hop) {
+		return false
+	}
+	if desired.Priority != nil && (current.Priority == nil || *current.Priority != *desired.Priority) {
+		return false
+	}
+	return true
+}
+
+// runLnetctl runs lnetctl and returns its output.
+func (d *Driver) runLnetctl(args ...string) (string, error) {
+	output, err := d.mounter.Exec.Command("lnetctl", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("lnetctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// getLnetConfig returns the current networks, routes and discovery of LNet,
+// or nil if LNet is not configured.
+func (d *Driver) getLnetConfig() (*volumehelper.LnetConfig, error) {
+	output, err := d.runLnetctl("net", "show")
+	if err != nil {
+		klog.V(4).Infof("LNet is not configured: %v", err)
+		return nil, nil
+	}
+	config, err := volumehelper.ParseLnetConfig([]byte(output), false)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse lnetctl net show: %w", err)
+	}
+	// The loopback network is there once LNet is configured.
+	if !slices.ContainsFunc(config.Net, func(n volumehelper.LnetNet) bool { return n.NetType == "lo" }) {
+		return nil, nil
+	}
+
+	for _, show := range [][]string{{"route", "show"}, {"global", "show"}} {
+		output, err := d.runLnetctl(show...)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := volumehelper.ParseLnetConfig([]byte(output), false)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse lnetctl %s: %w", strings.Join(show, " "), err)
+		}
+		config.Route = append(config.Route, parsed.Route...)
+		if parsed.Global != nil {
+			config.Global = parsed.Global
+		}
+	}
+	return config, nil
+}
+
+// countLustreMounts returns the number of Lustre filesystems mounted on the
+// node, including the driver's own internal mounts.
+func (d *Driver) countLustreMounts() (int, error) {
+	mounts, err := d.mounter.List()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, mount := range mounts {
+		if mount.Type == "lustre" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// reconcileLnet reads the LNet configuration file and changes LNet to match
+// it, unless Lustre filesystems are mounted and the driver is not forced to.
+// Mounts wait for the change to finish.
+func (d *Driver) reconcileLnet() error {
+	data, err := os.ReadFile(d.lnetConfigFile)
+	if err != nil {
+		return err
+	}
+	desired, err := parseLnetConfig(data)
+	if err != nil {
+		return fmt.Errorf("could not parse %q: %w", d.lnetConfigFile, err)
+	}
+
+	d.kernelModuleLock.Lock()
+	defer d.kernelModuleLock.Unlock()
+
+	current, err := d.getLnetConfig()
+	if err != nil {
+		return err
+	}
+	configure := current == nil
+	if configure {
+		current = &volumehelper.LnetConfig{}
+	}
+	changes := diffLnetConfig(current, desired)
+	if !configure && len(changes) == 0 {
+		lnetBlocked.Set(0)
+		return nil
+	}
+
+	descriptions := []string{}
+	if configure {
+		descriptions = append(descriptions, "configure LNet")
+	}
+	for _, change := range changes {
+		descriptions = append(descriptions, change.description)
+	}
+	mounts, err := d.countLustreMounts()
+	if err != nil {
+		return err
+	}
+	if mounts != 0 {
+		if !d.lnetForce {
+			lnetBlocked.Set(1)
+			return fmt.Errorf("refusing to change LNet while %d Lustre filesystems are mounted: %s",
+				mounts, strings.Join(descriptions, "; "))
+		}
+		klog.Warningf("changing LNet while %d Lustre filesystems are mounted", mounts)
+	}
+	lnetBlocked.Set(0)
+
+	klog.Infof("changing LNet to match %q: %s", d.lnetConfigFile, strings.Join(descriptions, "; "))
+	if configure {
+		if output, err := d.mounter.Exec.Command("modprobe", "lnet").CombinedOutput(); err != nil {
+			return fmt.Errorf("modprobe lnet: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+		if _, err := d.runLnetctl("lnet", "configure"); err != nil {
+			return err
+		}
+	}
+	for _, change := range changes {
+		if change.add != nil {
+			err = d.importLnetConfig(change.add)
+		} else {
+			_, err = d.runLnetctl(change.args...)
+		}
+		if err != nil {
+			return fmt.Errorf("could not %s: %w", change.description, err)
+		}
+		lnetChanges.Inc()
+	}
+
+	// LNet is checked again, in case lnetctl left out part of a change.
+	current, err = d.getLnetConfig()
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("LNet is not configured after configuring it")
+	}
+	if changes := diffLnetConfig(current, desired); len(changes) != 0 {
+		descriptions := []string{}
+		for _, change := range changes {
+			descriptions = append(descriptions, change.description)
+		}
+		return fmt.Errorf("LNet does not match %q after changing it, which still needs to %s",
+			d.lnetConfigFile, strings.Join(descriptions, "; "))
+	}
+	return nil
+}
+
+// importLnetConfig adds the networks and routes of a configuration to LNet
+// with lnetctl import.
+func (d *Driver) importLnetConfig(config *volumehelper.LnetConfig) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "lnet-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	_, err = d.runLnetctl("import", f.Name())
+	return err
+}
+
+// updateLnetNIDs exports the local NIDs of the node, logging them when they
+// change.
+func (d *Driver) updateLnetNIDs(previous []string) []string {
+	output, err := d.runLctl("list_nids")
+	nids := []string{}
+	if err == nil {
+		nids, err = volumehelper.ParseLctlNIDs(output)
+	}
+	if err != nil {
+		klog.Warningf("could not list the NIDs of the node: %v", err)
+		return previous
+	}
+
+	slices.Sort(nids)
+	if !slices.Equal(nids, previous) {
+		klog.Infof("local NIDs of the node are %s", strings.Join(nids, ", "))
+		lnetNID.Reset()
+		for _, nid := range nids {
+			lnetNID.WithLabelValues(nid).Set(1)
+		}
+	}
+	return nids
+}
+
+// runLnetConfig reconciles LNet with its configuration once per interval,
+// starting with the reconciliation that has already been done when the node
+// plugin started.
+func (d *Driver) runLnetConfig(ctx context.Context, nids []string) {
+	klog.Infof("reconciling LNet from %q every %v", d.lnetConfigFile, d.lnetConfigInterval)
+
+	ticker := time.NewTicker(d.lnetConfigInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		d.reconcileLnetOnce()
+		nids = d.updateLnetNIDs(nids)
+	}
+}
+
+// reconcileLnetOnce reconciles LNet with its configuration, recording the
+// result.
+func (d *Driver) reconcileLnetOnce() {
+	if err := d.reconcileLnet(); err != nil {
+		lnetErrors.Inc()
+		lnetInSync.Set(0)
+		klog.Errorf("failed to reconcile LNet: %v", err)
+		return
+	}
+	lnetInSync.Set(1)
+}
@@ -0,0 +1,247 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mount "k8s.io/mount-utils"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	lnetConfigured = `net:
+    - net type: lo
+      local NI(s):
+        - nid: 0@lo
+          status: up
+    - net type: tcp
+      local NI(s):
+        - nid: 127.0.0.1@tcp
+          status: up
+          interfaces:
+              0: lo
+`
+	lnetConfiguredWithO2ib = lnetConfigured + `    - net type: o2ib
+      local NI(s):
+        - nid: 10.0.1.5@o2ib
+          status: up
+          interfaces:
+              0: ib0
+`
+	lnetRoutes = `route:
+    - net: o2ib1
+      gateway: 127.0.0.2@tcp
+      hop: -1
+      priority: 0
+      state: up
+`
+	lnetDiscoveryOn  = "global:\n    discovery: 1\n"
+	lnetDiscoveryOff = "global:\n    discovery: 0\n"
+)
+
+var (
+	lnetNetShow    = []string{"lnetctl", "net", "show"}
+	lnetRouteShow  = []string{"lnetctl", "route", "show"}
+	lnetGlobalShow = []string{"lnetctl", "global", "show"}
+)
+
+func TestGetLnetConfig(t *testing.T) {
+	t.Run("not configured", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		setFakeCommands(t, d, fakeCommand{argv: lnetNetShow, output: "show:\n    - net:\n          errno: -100\n", err: fakeExitError{status: 1}})
+
+		config, err := d.getLnetConfig()
+		require.NoError(t, err)
+		assert.Nil(t, config)
+	})
+
+	t.Run("no loopback network", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		setFakeCommands(t, d, fakeCommand{argv: lnetNetShow, output: "net:\n"})
+
+		config, err := d.getLnetConfig()
+		require.NoError(t, err)
+		assert.Nil(t, config)
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		setFakeCommands(t, d,
+			fakeCommand{argv: lnetNetShow, output: lnetConfigured},
+			fakeCommand{argv: lnetRouteShow, output: lnetRoutes},
+			fakeCommand{argv: lnetGlobalShow, output: lnetDiscoveryOn})
+
+		config, err := d.getLnetConfig()
+		require.NoError(t, err)
+		require.Len(t, config.Net, 2)
+		assert.Equal(t, "tcp", config.Net[1].NetType)
+		assert.Equal(t, []string{"lo"}, config.Net[1].Interfaces())
+		assert.Equal(t, []volumehelper.LnetRoute{
+			{Net: "o2ib1", Gateway: "127.0.0.2@tcp", Hop: ptr.To(-1), Priority: ptr.To(0)},
+		}, config.Route)
+		assert.Equal(t, ptr.To(1), config.Global.Discovery)
+	})
+
+	t.Run("route show fails", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		setFakeCommands(t, d,
+			fakeCommand{argv: lnetNetShow, output: lnetConfigured},
+			fakeCommand{argv: lnetRouteShow, output: "no route", err: fakeExitError{status: 1}})
+
+		_, err := d.getLnetConfig()
+		assert.ErrorContains(t, err, "lnetctl route show: exit status 1: no route")
+	})
+}
+
+func TestReconcileLnet(t *testing.T) {
+	desired := `net:
+  - net type: tcp
+    local NI(s):
+      - interfaces:
+          0: lo
+global:
+  discovery: 1
+`
+	newDriver := func(t *testing.T, force bool, mounts []mount.MountPoint, commands ...fakeCommand) *Driver {
+		path := filepath.Join(t.TempDir(), "lnet.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(desired), 0o644))
+		d := NewDriver(&DriverOptions{LnetConfigFile: path, LnetForce: force})
+		setFakeCommands(t, d, commands...)
+		d.mounter.Interface = mount.NewFakeMounter(mounts)
+		return d
+	}
+	lustreMount := []mount.MountPoint{{Device: "10.0.0.1@tcp:/lustre", Path: "/mnt/lustre", Type: "lustre"}}
+	inSync := []fakeCommand{
+		{argv: lnetNetShow, output: lnetConfigured},
+		{argv: lnetRouteShow, output: "route:\n"},
+		{argv: lnetGlobalShow, output: lnetDiscoveryOn},
+	}
+	outOfSync := []fakeCommand{
+		{argv: lnetNetShow, output: lnetConfiguredWithO2ib},
+		{argv: lnetRouteShow, output: lnetRoutes},
+		{argv: lnetGlobalShow, output: lnetDiscoveryOff},
+	}
+
+	t.Run("configure", func(t *testing.T) {
+		d := newDriver(t, false, nil, append([]fakeCommand{
+			{argv: lnetNetShow, err: fakeExitError{status: 1}},
+			{argv: []string{"modprobe", "lnet"}},
+			{argv: []string{"lnetctl", "lnet", "configure"}},
+			{argv: []string{"lnetctl", "set", "discovery", "1"}},
+			{check: func(argv []string) {
+				require.Len(t, argv, 3)
+				assert.Equal(t, []string{"lnetctl", "import"}, argv[:2])
+				data, err := os.ReadFile(argv[2])
+				require.NoError(t, err)
+				imported, err := volumehelper.ParseLnetConfig(data, true)
+				require.NoError(t, err)
+				require.Len(t, imported.Net, 1)
+				assert.Equal(t, "tcp", imported.Net[0].NetType)
+				assert.Equal(t, []string{"lo"}, imported.Net[0].Interfaces())
+				assert.Empty(t, imported.Route)
+			}},
+		}, inSync...)...)
+
+		assert.NoError(t, d.reconcileLnet())
+	})
+
+	t.Run("in sync", func(t *testing.T) {
+		d := newDriver(t, false, lustreMount, inSync...)
+		assert.NoError(t, d.reconcileLnet())
+	})
+
+	t.Run("mounted", func(t *testing.T) {
+		d := newDriver(t, false, lustreMount, outOfSync...)
+		err := d.reconcileLnet()
+		assert.ErrorContains(t, err, "refusing to change LNet while 1 Lustre filesystems are mounted")
+		assert.ErrorContains(t, err, "delete network o2ib on ib0")
+	})
+
+	t.Run("forced while mounted", func(t *testing.T) {
+		d := newDriver(t, true, lustreMount, append(append(outOfSync,
+			fakeCommand{argv: []string{"lnetctl", "route", "del", "--net", "o2ib1", "--gateway", "127.0.0.2@tcp"}},
+			fakeCommand{argv: []string{"lnetctl", "net", "del", "--net", "o2ib"}},
+			fakeCommand{argv: []string{"lnetctl", "set", "discovery", "1"}},
+		), inSync...)...)
+
+		assert.NoError(t, d.reconcileLnet())
+	})
+
+	t.Run("modprobe fails", func(t *testing.T) {
+		d := newDriver(t, false, nil,
+			fakeCommand{argv: lnetNetShow, err: fakeExitError{status: 1}},
+			fakeCommand{argv: []string{"modprobe", "lnet"}, output: "modprobe: FATAL: Module lnet not found", err: fakeExitError{status: 1}})
+
+		assert.ErrorContains(t, d.reconcileLnet(), "modprobe lnet: exit status 1: modprobe: FATAL: Module lnet not found")
+	})
+
+	t.Run("change fails", func(t *testing.T) {
+		d := newDriver(t, false, nil, append(outOfSync,
+			fakeCommand{argv: []string{"lnetctl", "route", "del", "--net", "o2ib1", "--gateway", "127.0.0.2@tcp"}, output: "cannot delete route", err: fakeExitError{status: 1}},
+		)...)
+
+		assert.ErrorContains(t, d.reconcileLnet(),
+			"could not delete route to o2ib1 through 127.0.0.2@tcp: lnetctl route del --net o2ib1 --gateway 127.0.0.2@tcp: exit status 1: cannot delete route")
+	})
+
+	t.Run("change left out", func(t *testing.T) {
+		d := newDriver(t, false, nil, append(outOfSync,
+			fakeCommand{argv: []string{"lnetctl", "route", "del", "--net", "o2ib1", "--gateway", "127.0.0.2@tcp"}},
+			fakeCommand{argv: []string{"lnetctl", "net", "del", "--net", "o2ib"}},
+			fakeCommand{argv: []string{"lnetctl", "set", "discovery", "1"}},
+			fakeCommand{argv: lnetNetShow, output: lnetConfiguredWithO2ib},
+			fakeCommand{argv: lnetRouteShow, output: "route:\n"},
+			fakeCommand{argv: lnetGlobalShow, output: lnetDiscoveryOn},
+		)...)
+
+		assert.ErrorContains(t, d.reconcileLnet(), "which still needs to delete network o2ib on ib0")
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		d := newDriver(t, false, nil)
+		require.NoError(t, os.WriteFile(d.lnetConfigFile, []byte("net:\n  - net type: lo\n"), 0o644))
+
+		assert.ErrorContains(t, d.reconcileLnet(), "network lo is always configured and must not be given")
+	})
+}
+
+func TestUpdateLnetNIDs(t *testing.T) {
+	listNIDs := []string{"lctl", "list_nids"}
+
+	d := NewDriver(&DriverOptions{})
+	setFakeCommands(t, d,
+		fakeCommand{argv: listNIDs, output: "10.0.0.5@tcp\n0@lo\n10.0.1.5@o2ib\n"},
+		fakeCommand{argv: listNIDs, output: "lctl: no NIDs", err: fakeExitError{status: 1}},
+		fakeCommand{argv: listNIDs, output: "not a NID\n"},
+		fakeCommand{argv: listNIDs, err: errors.New("lctl not found")})
+
+	nids := d.updateLnetNIDs(nil)
+	assert.Equal(t, []string{"10.0.0.5@tcp", "10.0.1.5@o2ib"}, nids)
+	assert.Equal(t, nids, d.updateLnetNIDs(nids))
+	assert.Equal(t, nids, d.updateLnetNIDs(nids))
+	assert.Equal(t, nids, d.updateLnetNIDs(nids))
+}
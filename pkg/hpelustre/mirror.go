@@ -0,0 +1,275 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// A StorageClass can ask for the volumes that it creates to be mirrored across
+// OST pools with file-level redundancy (FLR), so that their files survive the
+// loss of an OST. The volume directory is given a default layout with a mirror
+// in each pool, which the files created in it inherit, including the files of
+// a volume that is populated from a snapshot or another volume. Writes to a
+// mirrored file only go to one mirror and mark the others stale, so the
+// controller periodically finds the stale files of the volumes and resyncs
+// them.
+
+const (
+	// Extended attribute of a volume directory with the OST pools that it is
+	// mirrored across
+	mirrorPoolsXattr = "trusted.lustre-csi.mirror-pools"
+
+	// Internal mount of a filesystem whose mirrored volumes are being resynced
+	mirrorMountPath = "mirrors"
+
+	// Most mirrors that a Lustre file can have
+	maxMirrorCount = 16
+)
+
+var (
+	mirrorStaleFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lustre_csi_mirror_stale_files",
+		Help: "Number of files of a mirrored volume that had stale mirrors when it was last resynced.",
+	}, []string{"volume_id"})
+	mirrorStaleBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lustre_csi_mirror_stale_bytes",
+		Help: "Bytes of the files of a mirrored volume that had stale mirrors when it was last resynced.",
+	}, []string{"volume_id"})
+	mirrorResyncedFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_mirror_resynced_files_total",
+		Help: "Number of files of a mirrored volume whose stale mirrors have been resynced.",
+	}, []string{"volume_id"})
+	mirrorResyncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_mirror_resync_errors_total",
+		Help: "Number of files of a mirrored volume whose stale mirrors could not be resynced.",
+	}, []string{"volume_id"})
+)
+
+func init() {
+	prometheus.MustRegister(mirrorStaleFiles, mirrorStaleBytes, mirrorResyncedFiles, mirrorResyncErrors)
+}
+
+// parseMirrorPools parses a comma-separated list of the distinct OST pools
+// that a volume is mirrored across.
+func parseMirrorPools(value string) ([]string, error) {
+	pools := []string{}
+	for _, pool := range strings.Split(value, ",") {
+		pool = strings.TrimSpace(pool)
+		if !ostPoolRegex.MatchString(pool) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Parameter %s must be OST pools of 1 to 15 letters, digits, '_' or '-'", ParameterMirrorPools)
+		}
+		if slices.Contains(pools, pool) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Parameter %s has OST pool %q more than once", ParameterMirrorPools, pool)
+		}
+		pools = append(pools, pool)
+	}
+	if len(pools) < 2 || len(pools) > maxMirrorCount {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Parameter %s must have 2 to %d OST pools", ParameterMirrorPools, maxMirrorCount)
+	}
+	return pools, nil
+}
+
+// setMirroredLayout gives a volume directory a default layout with a mirror
+// in each of the pools of the modification, striped as the modification asks,
+// and records the pools.
+func (d *Driver) setMirroredLayout(path string, m *volumeModification) error {
+	mirror := stripeLayout{}
+	if m.stripeCount != nil {
+		mirror.count = *m.stripeCount
+	}
+	if m.stripeSize != nil {
+		mirror.size = *m.stripeSize
+	}
+
+	args := []string{"setstripe"}
+	for _, pool := range m.mirrorPools {
+		mirror.pool = pool
+		args = append(append(args, "-N"), mirror.args()...)
+	}
+	if _, err := d.runLfs(append(args, path)...); err != nil {
+		return status.Errorf(codes.Internal, "failed to set mirrored layout of %q: %v", path, err)
+	}
+
+	if err := unix.Lsetxattr(path, mirrorPoolsXattr, []byte(strings.Join(m.mirrorPools, ",")), 0); err != nil {
+		return status.Errorf(codes.Internal, "failed to record mirror pools of %q: %v", path, err)
+	}
+	return nil
+}
+
+// getMirrorPools returns the OST pools that a volume directory is mirrored
+// across, or nil if it is not mirrored.
+func getMirrorPools(path string) []string {
+	buf := make([]byte, maxMirrorCount*16)
+	n, err := unix.Lgetxattr(path, mirrorPoolsXattr, buf)
+	if err != nil || n == 0 {
+		return nil
+	}
+	return strings.Split(string(buf[:n]), ",")
+}
+
+// runMirrorResync resyncs the stale files of the mirrored volumes of every
+// filesystem once per interval.
+func (d *Driver) runMirrorResync(ctx context.Context) {
+	klog.Infof("resyncing mirrored volumes every %v", d.mirrorResyncInterval)
+
+	ticker := time.NewTicker(d.mirrorResyncInterval)
+	defer ticker.Stop()
+
+	mirrored := map[string]struct{}{}
+	for {
+		found := map[string]struct{}{}
+		parents := d.getManagedParents(ctx)
+		for len(parents) != 0 {
+			handle := parents[0].filesystem
+			i := 1
+			for i < len(parents) && parents[i].filesystem == handle {
+				i++
+			}
+
+			if err := d.resyncFilesystemMirrors(ctx, handle, parents[:i], found); err != nil {
+				klog.Errorf("failed to resync mirrored volumes of %s: %v", handle, err)
+			}
+			parents = parents[i:]
+		}
+
+		// Volumes that are gone, or no longer mirrored, are no longer stale.
+		for volumeID := range mirrored {
+			if _, ok := found[volumeID]; !ok {
+				mirrorStaleFiles.DeleteLabelValues(volumeID)
+				mirrorStaleBytes.DeleteLabelValues(volumeID)
+			}
+		}
+		mirrored = found
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resyncFilesystemMirrors resyncs the mirrored volumes in the managed parent
+// directories of a filesystem, adding their IDs to found.
+func (d *Driver) resyncFilesystemMirrors(ctx context.Context, handle string, parents []managedParent, found map[string]struct{}) error {
+	root, unmount, err := d.mountFilesystem(handle, mirrorMountPath, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	for _, parent := range parents {
+		if managed, err := isManagedParent(root, parent.parentDir); err != nil {
+			return err
+		} else if !managed {
+			continue
+		}
+
+		parentPath, _, err := checkSubDir(root, parent.parentDir)
+		if err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(parentPath)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read %q: %v", parentPath, err)
+		}
+
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") || !entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(parentPath, entry.Name())
+			if getMirrorPools(path) == nil {
+				continue
+			}
+
+			volumeID := makeSubDirVolumeID(handle, filepath.Join(parent.parentDir, entry.Name()))
+			found[volumeID] = struct{}{}
+			if err := d.resyncVolumeMirrors(ctx, volumeID, path); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				klog.Errorf("failed to resync mirrored volume %s: %v", volumeID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resyncVolumeMirrors resyncs the stale mirrors of the files of a volume.
+// Files that are being written cannot be resynced until they are closed, and
+// are retried in the next round.
+func (d *Driver) resyncVolumeMirrors(ctx context.Context, volumeID, path string) error {
+	output, err := d.runLfsOutput(ctx, "find", path, "-type", "f", "--component-flags", "stale")
+	if err != nil {
+		return err
+	}
+
+	files := []string{}
+	staleBytes := int64(0)
+	for _, file := range strings.Split(output, "\n") {
+		if len(file) == 0 {
+			continue
+		}
+		info, err := os.Lstat(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		files = append(files, file)
+		staleBytes += info.Size()
+	}
+	mirrorStaleFiles.WithLabelValues(volumeID).Set(float64(len(files)))
+	mirrorStaleBytes.WithLabelValues(volumeID).Set(float64(staleBytes))
+
+	resynced := 0
+	for _, file := range files {
+		if _, err := d.runLfsContext(ctx, "mirror", "resync", file); ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			mirrorResyncErrors.WithLabelValues(volumeID).Inc()
+			klog.V(4).Infof("could not resync %q: %v", file, err)
+			continue
+		}
+		mirrorResyncedFiles.WithLabelValues(volumeID).Inc()
+		resynced++
+	}
+	if len(files) != 0 {
+		klog.V(2).Infof("resynced %d of %d stale files of mirrored volume %s", resynced, len(files), volumeID)
+	}
+	return nil
+}
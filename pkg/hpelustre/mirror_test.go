@@ -0,0 +1,162 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/ptr"
+)
+
+func TestParseStorageClassMirrorPools(t *testing.T) {
+	tests := []struct {
+		desc        string
+		params      map[string]string
+		mirrorPools []string
+		code        codes.Code
+	}{
+		{
+			desc:        "mirror pools",
+			params:      map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "mirror-pools": "flash, disk"},
+			mirrorPools: []string{"flash", "disk"},
+		},
+		{
+			desc:   "one pool",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "mirror-pools": "flash"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "pool more than once",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "mirror-pools": "flash,disk,flash"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "empty pool",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "mirror-pools": "flash,,disk"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "OST pool and mirror pools",
+			params: map[string]string{"filesystem": "10.0.0.1@tcp:/lustre", "ost-pool": "flash", "mirror-pools": "flash,disk"},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			parsed, err := parseStorageClassParameters(test.params)
+			require.Equal(t, test.code, status.Code(err), "%v", err)
+			if test.code != codes.OK {
+				return
+			}
+			assert.Equal(t, test.mirrorPools, parsed.mirrorPools)
+		})
+	}
+}
+
+func TestSetMirroredLayout(t *testing.T) {
+	m := &volumeModification{
+		stripeCount: ptr.To(int64(2)),
+		stripeSize:  ptr.To(int64(4 << 20)),
+		mirrorPools: []string{"flash", "disk"},
+	}
+
+	t.Run("mirror in each pool", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		path := t.TempDir()
+		if err := unix.Lsetxattr(path, mirrorPoolsXattr, []byte("probe"), 0); err != nil {
+			t.Skipf("trusted extended attributes are not supported: %v", err)
+		}
+		setFakeCommands(t, d, fakeCommand{argv: []string{
+			"lfs", "setstripe",
+			"-N", "-c", "2", "-S", "4194304", "-p", "flash",
+			"-N", "-c", "2", "-S", "4194304", "-p", "disk",
+			path,
+		}})
+
+		require.NoError(t, d.setMirroredLayout(path, m))
+		assert.Equal(t, []string{"flash", "disk"}, getMirrorPools(path))
+	})
+
+	t.Run("setstripe fails", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		path := t.TempDir()
+		setFakeCommands(t, d, fakeCommand{
+			argv: []string{
+				"lfs", "setstripe",
+				"-N", "-c", "0", "-S", "0", "-p", "flash",
+				"-N", "-c", "0", "-S", "0", "-p", "disk",
+				path,
+			},
+			output: "lfs setstripe: cannot create composite file: Invalid argument",
+			err:    fakeExitError{status: 22},
+		})
+
+		err := d.setMirroredLayout(path, &volumeModification{mirrorPools: m.mirrorPools})
+		assert.Equal(t, codes.Internal, status.Code(err), "%v", err)
+		assert.ErrorContains(t, err, "cannot create composite file")
+		assert.Nil(t, getMirrorPools(path))
+	})
+}
+
+func TestResyncVolumeMirrors(t *testing.T) {
+	path := t.TempDir()
+	stale := []string{filepath.Join(path, "a"), filepath.Join(path, "b")}
+	for _, file := range stale {
+		require.NoError(t, os.WriteFile(file, []byte("data"), 0o644))
+	}
+	find := []string{"lfs", "find", path, "-type", "f", "--component-flags", "stale"}
+
+	t.Run("stale files", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		// A file that is removed after it is found is skipped, and a file
+		// that is still being written is retried in the next round.
+		setFakeCommands(t, d,
+			fakeCommand{argv: find, output: stale[0] + "\n" + filepath.Join(path, "removed") + "\n" + stale[1] + "\n"},
+			fakeCommand{argv: []string{"lfs", "mirror", "resync", stale[0]}},
+			fakeCommand{argv: []string{"lfs", "mirror", "resync", stale[1]}, err: fakeExitError{status: 16}},
+		)
+
+		assert.NoError(t, d.resyncVolumeMirrors(context.Background(), "10.1.1.113@tcp:/lushtx#csi/pvc-1", path))
+	})
+
+	t.Run("no stale files", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		setFakeCommands(t, d, fakeCommand{argv: find})
+
+		assert.NoError(t, d.resyncVolumeMirrors(context.Background(), "10.1.1.113@tcp:/lushtx#csi/pvc-1", path))
+	})
+
+	t.Run("find fails", func(t *testing.T) {
+		d := NewDriver(&DriverOptions{})
+		setFakeCommands(t, d, fakeCommand{argv: find, err: fakeExitError{status: 2}})
+
+		err := d.resyncVolumeMirrors(context.Background(), "10.1.1.113@tcp:/lushtx#csi/pvc-1", path)
+		assert.ErrorContains(t, err, "lfs find")
+	})
+}
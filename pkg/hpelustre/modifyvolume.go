@@ -0,0 +1,708 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	utilexec "k8s.io/utils/exec"
+)
+
+// Mutable parameters of a volume, from the VolumeAttributesClass of its
+// PersistentVolumeClaim. ParameterOSTPool is mutable as well.
+const (
+	// Stripe count of the default layout of the volume directory, or -1 to
+	// stripe over every OST
+	ParameterStripeCount = "stripe-count"
+	// Stripe size of the default layout of the volume directory, such as 1M
+	ParameterStripeSize = "stripe-size"
+	// Limit of the number of inodes in the volume, through a project quota,
+	// or 0 for no limit
+	ParameterInodeLimit = "inode-limit"
+	// Whether to migrate the existing files of the volume to a new layout
+	ParameterMigrate = "migrate"
+	// What happens to the files of the volume once it is deleted: removed,
+	// or archived and released by the HSM copytool
+	ParameterHsmOnDelete = "hsm-on-delete"
+	// Archive that the files of the volume are archived into, if not the
+	// default archive of the copytool
+	ParameterHsmArchiveID = "hsm-archive-id"
+)
+
+const (
+	// Parent of the internal mounts of filesystems that volumes are being
+	// modified on
+	volumeModifyMountDir = "volume-modifications"
+
+	// Project IDs of volumes, for their quotas, are hashed from their volume
+	// IDs into [minVolumeProjectID, maxVolumeProjectID). A volume whose hash is
+	// in use by other files is given the next ID that is not, up to
+	// maxVolumeProjectIDProbes IDs on, and its ID is recorded in
+	// volumeProjectXattr of its directory.
+	minVolumeProjectID       = 1 << 20
+	maxVolumeProjectID       = 1 << 31
+	maxVolumeProjectIDProbes = 64
+	volumeProjectXattr       = "trusted.lustre-csi.project"
+
+	// Time between events with the progress of a migration
+	migrateProgressInterval = 5 * time.Minute
+)
+
+// volumeModification is a change to the mutable parameters of a volume.
+// Parameters that are nil are not changed.
+type volumeModification struct {
+	stripeCount *int64
+	stripeSize  *int64
+	ostPool     *string
+	inodeLimit  *int64
+	migrate     bool
+	hsmOnDelete *string
+	// Only set with hsmOnDelete archive
+	hsmArchiveID uint32
+	// OST pools that a new volume is mirrored across, which only come from
+	// its StorageClass
+	mirrorPools []string
+}
+
+func (m *volumeModification) changesLayout() bool {
+	return m.stripeCount != nil || m.stripeSize != nil || m.ostPool != nil
+}
+
+// parseMutableParameters parses the mutable parameters that are passed to
+// CreateVolume and ControllerModifyVolume.
+func parseMutableParameters(params map[string]string) (*volumeModification, error) {
+	m := &volumeModification{}
+
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case ParameterStripeCount:
+			count, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || count < -1 {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be a number of OSTs, or -1 for all of them", k)
+			}
+			m.stripeCount = &count
+		case ParameterStripeSize:
+			size, err := volumehelper.ParseStripeSize(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Parameter %s is invalid: %v", k, err)
+			}
+			m.stripeSize = &size
+		case ParameterOSTPool:
+			if !ostPoolRegex.MatchString(v) {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be 1 to 15 letters, digits, '_' or '-'", k)
+			}
+			pool := v
+			m.ostPool = &pool
+		case ParameterInodeLimit:
+			limit, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || limit < 0 {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be a number of inodes, or 0 for no limit", k)
+			}
+			m.inodeLimit = &limit
+		case ParameterMigrate:
+			migrate, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Parameter %s must be true or false", k)
+			}
+			m.migrate = migrate
+		case ParameterHsmOnDelete:
+			if v != HsmOnDeleteRemove && v != HsmOnDeleteArchive {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be %q or %q, not %q", k, HsmOnDeleteRemove, HsmOnDeleteArchive, v)
+			}
+			policy := v
+			m.hsmOnDelete = &policy
+		case ParameterHsmArchiveID:
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || id == 0 {
+				return nil, status.Errorf(codes.InvalidArgument,
+					"Parameter %s must be a positive archive number", k)
+			}
+			m.hsmArchiveID = uint32(id)
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown mutable parameter %s", k)
+		}
+	}
+
+	if m.migrate && !m.changesLayout() {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Parameter %s requires a new layout to migrate to", ParameterMigrate)
+	}
+
+	if m.hsmArchiveID != 0 && (m.hsmOnDelete == nil || *m.hsmOnDelete != HsmOnDeleteArchive) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Parameter %s is only allowed with %s %s", ParameterHsmArchiveID, ParameterHsmOnDelete, HsmOnDeleteArchive)
+	}
+
+	return m, nil
+}
+
+// ControllerModifyVolume changes the default layout, the inode limit and the
+// HSM policy of a volume that the driver created. Migrating its existing files
+// to the new layout, and giving them the project of its quota, is done in the
+// background, and reported in events on its PersistentVolumeClaim.
+func (d *Driver) ControllerModifyVolume(
+	_ context.Context,
+	req *csi.ControllerModifyVolumeRequest,
+) (*csi.ControllerModifyVolumeResponse, error) {
+	if !d.enableProvisioning {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	handle, volumeDir := splitVolumeID(volumeID)
+	if len(volumeDir) == 0 || ValidateVolumeHandle(volumeID) != nil || d.checkManagedVolumeDir(volumeDir) != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q was not created by the driver", volumeID)
+	}
+
+	m, err := parseMutableParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	d.volumeLock.Lock()
+	defer d.volumeLock.Unlock()
+
+	if c, ok := d.volumeCopies[volumeID]; ok && !c.finished() {
+		return nil, status.Errorf(codes.Aborted, "Volume %q is being populated", volumeID)
+	}
+
+	root, unmount, err := d.mountFilesystem(handle, provisionMountPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	if managed, err := isManagedParent(root, filepath.Dir(volumeDir)); err != nil {
+		return nil, err
+	} else if !managed {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found", volumeID)
+	}
+	path, exists, err := checkSubDir(root, volumeDir)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found", volumeID)
+	}
+
+	klog.V(2).Infof("ControllerModifyVolume: modifying volume %s with %v", volumeID, req.GetMutableParameters())
+	if err := d.modifyVolume(root, path, volumeID, m, false); err != nil {
+		return nil, err
+	}
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// modifyVolume applies a modification to the directory of a volume, on the
+// filesystem mounted at root, starting a background task for the files that
+// are already in it unless it was just created. The caller holds volumeLock.
+func (d *Driver) modifyVolume(root, path, volumeID string, m *volumeModification, created bool) error {
+	var layout *stripeLayout
+	if len(m.mirrorPools) != 0 {
+		if m.ostPool != nil {
+			return status.Errorf(codes.InvalidArgument,
+				"Parameter %s is not allowed with %s", ParameterOSTPool, ParameterMirrorPools)
+		}
+		if err := d.setMirroredLayout(path, m); err != nil {
+			return err
+		}
+	} else if m.changesLayout() {
+		if pools := getMirrorPools(path); pools != nil {
+			return status.Errorf(codes.InvalidArgument,
+				"Volume %q is mirrored across OST pools %s, whose layout cannot be changed",
+				volumeID, strings.Join(pools, ","))
+		}
+		var err error
+		layout, err = d.setDefaultLayout(path, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	newProjectID := uint32(0)
+	if m.inodeLimit != nil {
+		projectID, changed, err := d.setVolumeProject(root, path, volumeID)
+		if err != nil {
+			return err
+		}
+		if err := d.setInodeLimit(root, projectID, *m.inodeLimit); err != nil {
+			return err
+		}
+		if changed {
+			newProjectID = projectID
+		}
+	}
+
+	if m.hsmOnDelete != nil {
+		if err := setHsmOnDelete(path, *m.hsmOnDelete, m.hsmArchiveID); err != nil {
+			return err
+		}
+	}
+
+	if !m.migrate {
+		layout = nil
+	}
+	if !created && (newProjectID != 0 || layout != nil) {
+		d.startVolumeModification(volumeID, newProjectID, layout)
+	}
+
+	return nil
+}
+
+// stripeLayout is the default layout of a directory. Zero values are the
+// defaults of the filesystem.
+type stripeLayout struct {
+	count int64
+	size  int64
+	pool  string
+}
+
+func (l *stripeLayout) args() []string {
+	args := []string{"-c", strconv.FormatInt(l.count, 10), "-S", strconv.FormatInt(l.size, 10)}
+	if len(l.pool) != 0 {
+		args = append(args, "-p", l.pool)
+	}
+	return args
+}
+
+func (l *stripeLayout) String() string {
+	return strings.Join(l.args(), " ")
+}
+
+// setDefaultLayout changes the default layout of a volume directory, keeping
+// the parts of its current layout that the modification does not change, and
+// returns the new layout.
+func (d *Driver) setDefaultLayout(path string, m *volumeModification) (*stripeLayout, error) {
+	layout := &stripeLayout{}
+	if output, err := d.runLfs("getstripe", "-d", "-c", path); err == nil {
+		layout.count, _ = strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	}
+	if output, err := d.runLfs("getstripe", "-d", "-S", path); err == nil {
+		layout.size, _ = strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	}
+	if output, err := d.runLfs("getstripe", "-d", "-p", path); err == nil {
+		layout.pool = strings.TrimSpace(output)
+	}
+
+	if m.stripeCount != nil {
+		layout.count = *m.stripeCount
+	}
+	if m.stripeSize != nil {
+		layout.size = *m.stripeSize
+	}
+	if m.ostPool != nil {
+		layout.pool = *m.ostPool
+	}
+
+	args := append([]string{"setstripe"}, layout.args()...)
+	if _, err := d.runLfs(append(args, path)...); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set layout of %q: %v", path, err)
+	}
+
+	return layout, nil
+}
+
+// volumeProjectID returns the first project ID that a volume is given if it
+// is not in use.
+func volumeProjectID(volumeID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(volumeID))
+	return minVolumeProjectID + h.Sum32()%(maxVolumeProjectID-minVolumeProjectID)
+}
+
+// nextVolumeProjectID returns the project ID that a volume is given if the
+// project ID is in use.
+func nextVolumeProjectID(projectID uint32) uint32 {
+	return minVolumeProjectID + (projectID-minVolumeProjectID+1)%(maxVolumeProjectID-minVolumeProjectID)
+}
+
+// getRecordedProject returns the project ID recorded for a volume directory,
+// or 0 if it has none.
+func getRecordedProject(path string) uint32 {
+	buf := make([]byte, 16)
+	n, err := unix.Lgetxattr(path, volumeProjectXattr, buf)
+	if err != nil {
+		return 0
+	}
+	projectID, err := strconv.ParseUint(string(buf[:n]), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(projectID)
+}
+
+// isProjectInUse reports whether any files on the filesystem mounted at root
+// are charged to a project, or the project has limits.
+func (d *Driver) isProjectInUse(root string, projectID uint32) (bool, error) {
+	output, err := d.runLfs("quota", "-q", "-p", strconv.FormatUint(uint64(projectID), 10), root)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "failed to get quota of project %d: %v", projectID, err)
+	}
+	quota, err := volumehelper.ParseLfsQuota(output)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "failed to get quota of project %d: %v", projectID, err)
+	}
+	return quota.UsedKiB != 0 || quota.Files != 0 ||
+		quota.SoftLimitKiB != 0 || quota.HardLimitKiB != 0 ||
+		quota.SoftLimit != 0 || quota.HardLimit != 0, nil
+}
+
+// allocateVolumeProject returns the project ID of a volume directory on the
+// filesystem mounted at root. It is the ID recorded for the directory, or else
+// the first ID from the hash of the volume ID that is not in use, which is then
+// recorded. A directory that already has the project of its hash, given before
+// IDs were recorded, keeps it.
+func (d *Driver) allocateVolumeProject(root, path, volumeID string) (uint32, error) {
+	if projectID := getRecordedProject(path); projectID != 0 {
+		return projectID, nil
+	}
+
+	projectID := volumeProjectID(volumeID)
+	current := uint32(0)
+	if output, err := d.runLfs("project", "-d", path); err == nil {
+		current, _ = volumehelper.ParseLfsProject(output)
+	}
+
+	for range maxVolumeProjectIDProbes {
+		inUse := false
+		if current != projectID {
+			var err error
+			if inUse, err = d.isProjectInUse(root, projectID); err != nil {
+				return 0, err
+			}
+		}
+		if inUse {
+			klog.V(2).Infof("project %d is in use, trying the next for volume %s", projectID, volumeID)
+			projectID = nextVolumeProjectID(projectID)
+			continue
+		}
+
+		err := unix.Lsetxattr(path, volumeProjectXattr, []byte(strconv.FormatUint(uint64(projectID), 10)), unix.XATTR_CREATE)
+		if errors.Is(err, unix.EEXIST) {
+			// Another publish of the volume recorded its project first.
+			if recorded := getRecordedProject(path); recorded != 0 {
+				return recorded, nil
+			}
+		}
+		if err != nil {
+			return 0, status.Errorf(codes.Internal, "failed to record project of %q: %v", path, err)
+		}
+		return projectID, nil
+	}
+
+	return 0, status.Errorf(codes.ResourceExhausted,
+		"No free project ID for volume %s in the %d after %d", volumeID, maxVolumeProjectIDProbes, volumeProjectID(volumeID))
+}
+
+// setVolumeProject gives a volume directory, on the filesystem mounted at
+// root, the project ID of its quota, which new files inherit, and returns it
+// along with whether the directory had a different project. The files that
+// are already in it are given the project in the background.
+func (d *Driver) setVolumeProject(root, path, volumeID string) (uint32, bool, error) {
+	projectID, err := d.allocateVolumeProject(root, path, volumeID)
+	if err != nil {
+		return 0, false, err
+	}
+	if output, err := d.runLfs("project", "-d", path); err == nil {
+		if current, err := volumehelper.ParseLfsProject(output); err == nil && current == projectID {
+			return projectID, false, nil
+		}
+	}
+
+	if _, err := d.runLfs("project", "-p", strconv.FormatUint(uint64(projectID), 10), "-s", path); err != nil {
+		return 0, false, status.Errorf(codes.Internal, "failed to set project of %q: %v", path, err)
+	}
+	return projectID, true, nil
+}
+
+func (d *Driver) setInodeLimit(root string, projectID uint32, limit int64) error {
+	_, err := d.runLfs("setquota", "-p", strconv.FormatUint(uint64(projectID), 10),
+		"-i", "0", "-I", strconv.FormatInt(limit, 10), root)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to set inode limit of project %d: %v", projectID, err)
+	}
+	return nil
+}
+
+// runLfs runs lfs and returns its output.
+func (d *Driver) runLfs(args ...string) (string, error) {
+	return d.runLfsContext(context.Background(), args...)
+}
+
+// runLfsContext runs lfs until ctx is done, and returns its output.
+func (d *Driver) runLfsContext(ctx context.Context, args ...string) (string, error) {
+	output, err := d.mounter.Exec.CommandContext(ctx, "lfs", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("lfs %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// runLfsOutput runs lfs until ctx is done, and returns only its standard
+// output, without the warnings that lfs writes to standard error.
+func (d *Driver) runLfsOutput(ctx context.Context, args ...string) (string, error) {
+	stderr := &bytes.Buffer{}
+	cmd := d.mounter.Exec.CommandContext(ctx, "lfs", args...)
+	cmd.SetStderr(stderr)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("lfs %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// startVolumeModification gives the files of a volume its project and
+// migrates them to a new layout in the background, replacing any earlier
+// modification that is still running. The caller holds volumeLock.
+func (d *Driver) startVolumeModification(volumeID string, projectID uint32, layout *stripeLayout) {
+	if c, ok := d.volumeModifications[volumeID]; ok {
+		c.stop()
+	}
+
+	description := fmt.Sprintf("modification of volume %s", volumeID)
+	d.volumeModifications[volumeID] = startBackgroundCopy(description, func(ctx context.Context) error {
+		err := d.modifyVolumeFiles(ctx, volumeID, projectID, layout)
+		if err != nil && ctx.Err() == nil {
+			d.recordVolumeEvent(volumeID, corev1.EventTypeWarning, "ModifyVolumeFailed",
+				"Failed to modify the files of the volume: %v", err)
+		}
+		return err
+	})
+}
+
+func (d *Driver) modifyVolumeFiles(ctx context.Context, volumeID string, projectID uint32, layout *stripeLayout) error {
+	handle, volumeDir := splitVolumeID(volumeID)
+
+	root, unmount, err := d.mountFilesystem(handle, filepath.Join(volumeModifyMountDir, filepath.Base(volumeDir)), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	path, exists, err := checkSubDir(root, volumeDir)
+	if err != nil {
+		return err
+	} else if !exists {
+		return nil
+	}
+
+	if projectID != 0 {
+		if _, err := d.runLfs("project", "-p", strconv.FormatUint(uint64(projectID), 10), "-s", "-r", path); err != nil {
+			return status.Errorf(codes.Internal, "failed to set project of %q: %v", path, err)
+		}
+	}
+
+	if layout != nil {
+		d.recordVolumeEvent(volumeID, corev1.EventTypeNormal, "MigrateStarted",
+			"Migrating the files of the volume to layout %s", layout)
+		migrated, skipped, err := d.migrateFiles(ctx, volumeID, path, layout)
+		if err != nil {
+			return err
+		}
+		d.recordVolumeEvent(volumeID, corev1.EventTypeNormal, "MigrateCompleted",
+			"Migrated %d files of the volume to layout %s; %d files that were in use were skipped",
+			migrated, layout, skipped)
+	}
+
+	return nil
+}
+
+// isBusyExit reports whether lfs failed with EBUSY, which it exits with as its
+// status, as a file that is migrated without blocking is in use.
+func isBusyExit(err error) bool {
+	var exitErr utilexec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitStatus() == int(unix.EBUSY)
+}
+
+// migrateFiles migrates the regular files below path to a layout with
+// copyWorkers workers, and returns the number that were migrated and the
+// number that were skipped because they were in use.
+func (d *Driver) migrateFiles(ctx context.Context, volumeID, path string, layout *stripeLayout) (int64, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var migrated, skipped atomic.Int64
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	args := append([]string{"migrate", "--non-block"}, layout.args()...)
+	files := make(chan string)
+	wg := sync.WaitGroup{}
+	for range max(d.copyWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				output, err := d.mounter.Exec.CommandContext(ctx, "lfs", append(args, file)...).CombinedOutput()
+				switch {
+				case err == nil:
+					migrated.Add(1)
+				case ctx.Err() != nil:
+				case isBusyExit(err):
+					skipped.Add(1)
+				default:
+					fail(fmt.Errorf("failed to migrate %q: %w: %s", file, err, strings.TrimSpace(string(output))))
+				}
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(migrateProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.recordVolumeEvent(volumeID, corev1.EventTypeNormal, "MigrateProgress",
+					"Migrated %d files of the volume so far", migrated.Load())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	walkErr := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		select {
+		case files <- file:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, 0, firstErr
+	}
+	if walkErr != nil {
+		return 0, 0, walkErr
+	}
+	return migrated.Load(), skipped.Load(), nil
+}
+
+// getEventRecorder returns the driver's recorder of events, creating it the
+// first time that it is needed.
+func (d *Driver) getEventRecorder() (record.EventRecorder, error) {
+	client, err := d.getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	d.eventRecorderLock.Lock()
+	defer d.eventRecorderLock.Unlock()
+
+	if d.eventRecorder == nil {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+		d.eventRecorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: d.Name})
+	}
+	return d.eventRecorder, nil
+}
+
+// recordVolumeEvent records an event on the PersistentVolumeClaim of a volume,
+// or on its PersistentVolume if it is not bound.
+func (d *Driver) recordVolumeEvent(volumeID, eventType, reason, messageFmt string, args ...interface{}) {
+	recorder, err := d.getEventRecorder()
+	if err != nil {
+		klog.Warningf("could not record event for volume %s: %v", volumeID, err)
+		return
+	}
+
+	client, err := d.getKubeClient()
+	if err != nil {
+		klog.Warningf("could not record event for volume %s: %v", volumeID, err)
+		return
+	}
+
+	// The directory of a volume is named after the PersistentVolume that it
+	// was provisioned for.
+	_, volumeDir := splitVolumeID(volumeID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pv, err := client.CoreV1().PersistentVolumes().Get(ctx, filepath.Base(volumeDir), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && (pv.Spec.CSI == nil || pv.Spec.CSI.Driver != d.Name || pv.Spec.CSI.VolumeHandle != volumeID)) {
+		klog.V(4).Infof("no PersistentVolume for volume %s to record event %s on", volumeID, reason)
+		return
+	} else if err != nil {
+		klog.Warningf("could not record event for volume %s: %v", volumeID, err)
+		return
+	}
+
+	ref := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolume",
+		Name:       pv.Name,
+		UID:        pv.UID,
+	}
+	if claim := pv.Spec.ClaimRef; claim != nil {
+		ref = &corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Namespace:  claim.Namespace,
+			Name:       claim.Name,
+			UID:        claim.UID,
+		}
+	}
+	recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/ptr"
+)
+
+type fakeExitError struct {
+	status int
+}
+
+func (e fakeExitError) Error() string   { return fmt.Sprintf("exit status %d", e.status) }
+func (e fakeExitError) String() string  { return e.Error() }
+func (e fakeExitError) Exited() bool    { return true }
+func (e fakeExitError) ExitStatus() int { return e.status }
+
+var _ utilexec.ExitError = fakeExitError{}
+
+// fakeCommand is a command that a test expects the driver to run, and what
+// the command outputs.
+type fakeCommand struct {
+	argv []string
+	// Checks the command instead of argv, if set
+	check  func(argv []string)
+	output string
+	err    error
+}
+
+// setFakeCommands makes the driver run the commands through a fake exec,
+// which checks that the driver runs exactly those commands, in order.
+func setFakeCommands(t *testing.T, d *Driver, commands ...fakeCommand) {
+	fake := &testingexec.FakeExec{}
+	for _, c := range commands {
+		fake.CommandScript = append(fake.CommandScript, func(cmd string, args ...string) utilexec.Cmd {
+			if c.check != nil {
+				c.check(append([]string{cmd}, args...))
+			} else {
+				assert.Equal(t, c.argv, append([]string{cmd}, args...))
+			}
+			action := func() ([]byte, []byte, error) { return []byte(c.output), nil, c.err }
+			return testingexec.InitFakeCmd(&testingexec.FakeCmd{
+				CombinedOutputScript: []testingexec.FakeAction{action},
+				OutputScript:         []testingexec.FakeAction{action},
+			}, cmd, args...)
+		})
+	}
+	t.Cleanup(func() {
+		assert.Equal(t, len(commands), fake.CommandCalls, "number of commands run")
+	})
+
+	d.mounter = &mount.SafeFormatAndMount{Exec: fake}
+}
+
+func TestIsBusyExit(t *testing.T) {
+	assert.True(t, isBusyExit(fakeExitError{status: int(unix.EBUSY)}))
+	assert.True(t, isBusyExit(fmt.Errorf("lfs migrate: %w", fakeExitError{status: int(unix.EBUSY)})))
+	assert.False(t, isBusyExit(fakeExitError{status: 1}))
+	assert.False(t, isBusyExit(errors.New("Device or resource busy")))
+}
+
+func TestNextVolumeProjectID(t *testing.T) {
+	projectID := volumeProjectID("10.1.1.113@tcp:/lushtx#csi/pvc-1")
+	assert.GreaterOrEqual(t, projectID, uint32(minVolumeProjectID))
+	assert.Less(t, projectID, uint32(maxVolumeProjectID))
+	assert.Equal(t, projectID, volumeProjectID("10.1.1.113@tcp:/lushtx#csi/pvc-1"))
+
+	assert.Equal(t, uint32(minVolumeProjectID+1), nextVolumeProjectID(minVolumeProjectID))
+	assert.Equal(t, uint32(minVolumeProjectID), nextVolumeProjectID(maxVolumeProjectID-1))
+}
+
+func TestGetRecordedProject(t *testing.T) {
+	path := t.TempDir()
+	assert.Zero(t, getRecordedProject(path))
+
+	if err := unix.Lsetxattr(path, volumeProjectXattr, []byte("1048577"), 0); err != nil {
+		t.Skipf("trusted extended attributes are not supported: %v", err)
+	}
+	assert.Equal(t, uint32(1048577), getRecordedProject(path))
+}
+
+func TestParseMutableParameters(t *testing.T) {
+	tests := []struct {
+		desc          string
+		params        map[string]string
+		expected      *volumeModification
+		changesLayout bool
+		code          codes.Code
+	}{
+		{
+			desc:     "nothing",
+			expected: &volumeModification{},
+		},
+		{
+			desc:   "layout and migration",
+			params: map[string]string{"stripe-count": "-1", "stripe-size": "4M", "ost-pool": "flash", "migrate": "true"},
+			expected: &volumeModification{
+				stripeCount: ptr.To(int64(-1)),
+				stripeSize:  ptr.To(int64(4 << 20)),
+				ostPool:     ptr.To("flash"),
+				migrate:     true,
+			},
+			changesLayout: true,
+		},
+		{
+			desc:     "inode limit",
+			params:   map[string]string{"Inode-Limit": "0"},
+			expected: &volumeModification{inodeLimit: ptr.To(int64(0))},
+		},
+		{
+			desc:   "stripe count below -1",
+			params: map[string]string{"stripe-count": "-2"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "stripe size not a multiple of 64K",
+			params: map[string]string{"stripe-size": "100K"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "negative inode limit",
+			params: map[string]string{"inode-limit": "-1"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "migrate without a layout",
+			params: map[string]string{"migrate": "true", "inode-limit": "10"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "StorageClass parameter",
+			params: map[string]string{"parent-dir": "volumes"},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			m, err := parseMutableParameters(test.params)
+			require.Equal(t, test.code, status.Code(err), "%v", err)
+			if test.code != codes.OK {
+				return
+			}
+			assert.Equal(t, test.expected, m)
+			assert.Equal(t, test.changesLayout, m.changesLayout())
+		})
+	}
+}
@@ -17,17 +17,23 @@ limitations under the License.
 package hpelustre
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/volume"
 	mount "k8s.io/mount-utils"
@@ -35,7 +41,7 @@ import (
 
 // NodePublishVolume mount the volume from staging to target path
 func (d *Driver) NodePublishVolume(
-	_ context.Context,
+	ctx context.Context,
 	req *csi.NodePublishVolumeRequest,
 ) (*csi.NodePublishVolumeResponse, error) {
 
@@ -47,6 +53,17 @@ func (d *Driver) NodePublishVolume(
 	userMountFlags := volCap.GetMount().GetMountFlags()
 	volumeType := volCap.GetMount().GetFsType()
 
+	// The pod's fsGroup, delegated to the driver through VOLUME_MOUNT_GROUP
+	volumeMountGroup := int64(-1)
+	if group := volCap.GetMount().GetVolumeMountGroup(); len(group) != 0 {
+		gid, err := strconv.ParseUint(group, 10, 32)
+		if err != nil || gid == math.MaxUint32 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Volume mount group %q is not a numeric group ID", group)
+		}
+		volumeMountGroup = int64(gid)
+	}
+
 	volumeID := req.GetVolumeId()
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument,
@@ -65,18 +82,42 @@ func (d *Driver) NodePublishVolume(
 			"Volume context must be provided")
 	}
 
-	vol, err := getVolume(volumeID, context)
+	if err := d.verifyPublishContext(req); err != nil {
+		return nil, err
+	}
+
+	ephemeral := isEphemeralVolume(context)
+	var vol *lustreVolume
+	var err error
+	if ephemeral {
+		vol, err = getEphemeralVolume(context)
+	} else {
+		vol, err = getPersistentVolume(volumeID, context)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if vol.encrypted {
+		if vol.encryptionKey, err = getEncryptionKey(req.GetSecrets()); err != nil {
+			return nil, err
+		}
+	}
+
 	//source := getSourceString(vol.mgsIPAddress, vol.hpeLustreName)
-	source := volumeID
+	source := vol.id
 
-	mountOptions, readOnly := getMountOptions(req, userMountFlags)
+	mountOptions, readOnly, err := getMountOptions(req, userMountFlags)
+	if err != nil {
+		return nil, err
+	}
 
-	if len(vol.subDir) > 0 && !d.enableHpeLustreMockMount {
-		interpolatedSubDir := interpolateSubDirVariables(context, vol)
+	interpolatedSubDir := ""
+	if len(vol.subDir) > 0 {
+		interpolatedSubDir, err = d.interpolateSubDir(ctx, context, vol)
+		if err != nil {
+			return nil, err
+		}
 
 		if isSubpath := ensureStrictSubpath(interpolatedSubDir); !isSubpath {
 			return nil, status.Error(
@@ -84,7 +125,53 @@ func (d *Driver) NodePublishVolume(
 				"Context sub-dir must be strict subpath",
 			)
 		}
+	}
 
+	if err := d.checkAccessPolicy(volumeID, vol, interpolatedSubDir, target, context); err != nil {
+		return nil, err
+	}
+
+	// The lock is released before waiting for a prefetch, which may take
+	// long, so that other volumes can be published and unpublished meanwhile.
+	d.publishStateLock.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			d.publishStateLock.Unlock()
+		}
+	}()
+	unlock := func() {
+		d.publishStateLock.Unlock()
+		locked = false
+	}
+
+	rec := &publishRecord{
+		VolumeID:   volumeID,
+		TargetPath: target,
+	}
+	published := false
+
+	// Sensitive mount options are passed to mount without being logged
+	sensitiveMountOptions := []string{}
+	if sskKey, ok := req.GetSecrets()[SecretSSKKey]; ok && !d.enableHpeLustreMockMount {
+		keyPath, err := d.installSSKKey([]byte(sskKey))
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if published {
+				return
+			}
+			if err := d.releaseSSKKey(keyPath); err != nil {
+				klog.Warningf("failed to remove SSK key %q: %v", keyPath, err)
+			}
+		}()
+
+		rec.SSKKeyPath = keyPath
+		sensitiveMountOptions = append(sensitiveMountOptions, "skpath="+keyPath)
+	}
+
+	if len(vol.subDir) > 0 && !d.enableHpeLustreMockMount {
 		if readOnly {
 			klog.V(2).Info("NodePublishVolume: not attempting to create sub-dir on read-only volume, assuming existing path")
 		} else {
@@ -93,11 +180,20 @@ func (d *Driver) NodePublishVolume(
 				interpolatedSubDir,
 			)
 
-			if err = d.createSubDir(vol, target, interpolatedSubDir, mountOptions); err != nil {
+			if err = d.createSubDir(vol, target, interpolatedSubDir, mountOptions, sensitiveMountOptions); err != nil {
 				return nil, err
 			}
+
+			if vol.subDirOnUnpublish == SubDirOnUnpublishDelete || vol.subDirOnUnpublish == SubDirOnUnpublishArchive {
+				rec.SubDirOnUnpublish = vol.subDirOnUnpublish
+				rec.SubDirArchive = vol.subDirArchive
+				rec.MountOptions = mountOptions
+			}
 		}
 
+		rec.SubDir = interpolatedSubDir
+		rec.Filesystem = vol.id
+
 		source = filepath.Join(source, interpolatedSubDir)
 		klog.V(2).Infof(
 			"NodePublishVolume: full mount source with sub-dir: %q",
@@ -118,6 +214,14 @@ func (d *Driver) NodePublishVolume(
 			volumeID,
 			target,
 		)
+		// A publish that timed out waiting for its prefetch waits again
+		if vol.prefetchWait {
+			c := d.startPrefetch(volumeID, target, vol, true)
+			unlock()
+			if err := waitForPrefetch(ctx, target, c); err != nil {
+				return nil, err
+			}
+		}
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
@@ -142,7 +246,7 @@ func (d *Driver) NodePublishVolume(
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	err = mountVolumeAtPath(d, source, target, volumeType, mountOptions)
+	err = mountVolumeAtPath(d, source, target, volumeType, mountOptions, sensitiveMountOptions)
 	if err != nil {
 		if removeErr := os.Remove(target); removeErr != nil {
 			return nil, status.Errorf(
@@ -156,6 +260,61 @@ func (d *Driver) NodePublishVolume(
 			"Could not mount %q at %q: %v", source, target, err)
 	}
 
+	if len(vol.clientTuning) != 0 {
+		if err := d.tuneClient(target, vol); err != nil {
+			d.unmountFailedPublish(target, rec)
+			return nil, err
+		}
+	}
+
+	if vol.encrypted {
+		keyID, err := unlockEncryptedVolume(target, []byte(vol.encryptionKey))
+		if err != nil {
+			d.unmountFailedPublish(target, rec)
+			return nil, err
+		}
+		rec.EncryptionKeyID = keyID.String()
+	}
+
+	if err := applyVolumeMountGroupToVolume(vol, target, volumeMountGroup, readOnly); err != nil {
+		d.unmountFailedPublish(target, rec)
+		return nil, err
+	}
+
+	if err := d.idmapVolume(target, context); err != nil {
+		d.unmountFailedPublish(target, rec)
+		return nil, err
+	}
+
+	if len(vol.pccMode) != 0 {
+		rec.PCCPath, err = d.attachPCC(volumeID, target, vol, readOnly)
+		if err != nil {
+			d.unmountFailedPublish(target, rec)
+			return nil, err
+		}
+	}
+
+	if err := d.savePublishRecord(rec); err != nil {
+		d.unmountFailedPublish(target, rec)
+		return nil, status.Errorf(codes.Internal,
+			"Could not record volume %s mounted at %q: %v", volumeID, target, err)
+	}
+	published = true
+	d.kickClientParams()
+
+	if len(vol.hsmOnPublish) != 0 {
+		d.startHsmPublish(volumeID, target, vol)
+	}
+	if len(vol.prefetchPaths) != 0 || len(vol.prefetchManifest) != 0 {
+		c := d.startPrefetch(volumeID, target, vol, false)
+		if vol.prefetchWait {
+			unlock()
+			if err := waitForPrefetch(ctx, target, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	//klog.V(2).Infof(
 	//	"NodePublishVolume: volume %s mount %s at %s successfully",
 	//	volumeID,
@@ -171,40 +330,41 @@ func (d *Driver) NodePublishVolume(
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func interpolateSubDirVariables(context map[string]string, vol *lustreVolume) string {
-	subDirReplaceMap := map[string]string{}
+// unmountFailedPublish unmounts a volume whose publish failed after it was
+// mounted at target, undoing what has been done for it so far. As no record of
+// the publish has been saved, NodeUnpublishVolume would not undo it. The caller
+// holds publishStateLock.
+func (d *Driver) unmountFailedPublish(target string, rec *publishRecord) {
+	d.stopIdmap(target)
 
-	// get metadata values
-	for k, v := range context {
-		switch strings.ToLower(k) {
-		case podNameKey:
-			subDirReplaceMap[podNameMetadata] = v
-		case podNamespaceKey:
-			subDirReplaceMap[podNamespaceMetadata] = v
-		case podUIDKey:
-			subDirReplaceMap[podUIDMetadata] = v
-		case serviceAccountNameKey:
-			subDirReplaceMap[serviceAccountNameMetadata] = v
-		case pvcNamespaceKey:
-			subDirReplaceMap[pvcNamespaceMetadata] = v
-		case pvcNameKey:
-			subDirReplaceMap[pvcNameMetadata] = v
-		case pvNameKey:
-			subDirReplaceMap[pvNameMetadata] = v
+	if len(rec.PCCPath) != 0 {
+		if _, err := d.runLctl("pcc", "del", target, rec.PCCPath); err != nil {
+			klog.Warningf("failed to detach PCC directory %s from %q: %v", rec.PCCPath, target, err)
+		}
+	}
+	if len(rec.EncryptionKeyID) != 0 {
+		keyID, err := parseFscryptKeyID(rec.EncryptionKeyID)
+		if err == nil {
+			err = fscryptRemoveKey(target, keyID)
+		}
+		if err != nil {
+			klog.Warningf("failed to lock encrypted volume at %q: %v", target, err)
 		}
 	}
 
-	interpolatedSubDir := volumehelper.ReplaceWithMap(vol.subDir, subDirReplaceMap)
-	return interpolatedSubDir
+	if err := unmountVolumeAtPath(d, target); err != nil {
+		klog.Warningf("failed to unmount %q: %v", target, err)
+	}
 }
 
-func getMountOptions(req *csi.NodePublishVolumeRequest, userMountFlags []string) ([]string, bool) {
+func getMountOptions(req *csi.NodePublishVolumeRequest, userMountFlags []string) ([]string, bool, error) {
 	readOnly := false
 	mountOptions := []string{}
 	if req.GetReadonly() {
 		readOnly = true
 		mountOptions = append(mountOptions, "ro")
 	}
+	seLinuxContext := ""
 	for _, userMountFlag := range userMountFlags {
 		if userMountFlag == "ro" {
 			readOnly = true
@@ -213,13 +373,375 @@ func getMountOptions(req *csi.NodePublishVolumeRequest, userMountFlags []string)
 				continue
 			}
 		}
+		if strings.HasPrefix(userMountFlag, seLinuxContextOption) {
+			option, err := getSELinuxContextOption(userMountFlag)
+			if err != nil {
+				return nil, false, err
+			}
+			// kubelet adds the pod's context to any that is given in the
+			// PV's mountOptions, so the same context may appear twice.
+			if len(seLinuxContext) != 0 {
+				if option != seLinuxContext {
+					return nil, false, status.Errorf(codes.InvalidArgument,
+						"Conflicting SELinux mount options %s and %s", seLinuxContext, option)
+				}
+				continue
+			}
+			seLinuxContext = option
+			userMountFlag = option
+		}
 		mountOptions = append(mountOptions, userMountFlag)
 	}
-	return mountOptions, readOnly
+	return mountOptions, readOnly, nil
+}
+
+// getSELinuxContextOption validates a context= mount option, such as the one
+// that kubelet adds for the SELinuxMount feature, and returns it in the form
+// that mount.lustre passes on to the kernel. The label of a pod with MCS
+// categories contains commas, so the label must be quoted to keep it from
+// being split into separate options.
+func getSELinuxContextOption(option string) (string, error) {
+	label := strings.TrimPrefix(option, seLinuxContextOption)
+	if len(label) >= 2 && label[0] == '"' && label[len(label)-1] == '"' {
+		label = label[1 : len(label)-1]
+	}
+
+	// user:role:type[:level], where the level may have categories such as
+	// s0:c1,c2 or s0-s0:c0.c1023
+	parts := strings.SplitN(label, ":", 4)
+	if len(parts) < 3 || strings.ContainsFunc(label, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_.:,-", r))
+	}) {
+		return "", status.Errorf(codes.InvalidArgument, "Invalid SELinux mount option %q", option)
+	}
+	for _, part := range parts {
+		if len(part) == 0 {
+			return "", status.Errorf(codes.InvalidArgument, "Invalid SELinux mount option %q", option)
+		}
+	}
+
+	return fmt.Sprintf("%s%q", seLinuxContextOption, label), nil
 }
 
+// getVolume returns the volume described by the volume ID and context. The
+// volume ID is the Lustre mount source, which only has to be split into the MGS
+// NIDs and filesystem name when the driver mounts the filesystem itself to work
+// on a sub-dir.
 func getVolume(volumeID string, context map[string]string) (*lustreVolume, error) {
-	return &lustreVolume{}, nil
+	handle, idSubDir := splitVolumeID(volumeID)
+	vol := newVolume(handle)
+	if err := parseVolumeContext(vol, context); err != nil {
+		return nil, err
+	}
+
+	// The sub-dir in the ID of a volume is a path, not a template.
+	if len(idSubDir) != 0 {
+		if len(vol.subDir) != 0 {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Context sub-dir must not be set for volume %q, whose ID has a sub-dir", volumeID)
+		}
+		if !ensureStrictSubpath(idSubDir) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Sub-dir of volume %q must be strict subpath", volumeID)
+		}
+		vol.subDir = volumehelper.EscapeTemplate(idSubDir)
+	}
+
+	if len(vol.subDir) == 0 {
+		return vol, nil
+	}
+
+	mgsIPAddress, lustreName, err := parseVolumeHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	vol.mgsIPAddress = mgsIPAddress
+	vol.hpeLustreName = lustreName
+
+	return vol, nil
+}
+
+func newVolume(volumeID string) *lustreVolume {
+	return &lustreVolume{
+		name: volumeID,
+		id:   volumeID,
+		uid:  -1,
+		gid:  -1,
+		mode: -1,
+	}
+}
+
+// parseVolumeContext sets the volume's attributes from the volume context.
+func parseVolumeContext(vol *lustreVolume, context map[string]string) error {
+	subDirAttributes := []string{}
+	clientTuning := []string{}
+
+	for k, v := range context {
+		switch strings.ToLower(k) {
+		case VolumeContextSubDir:
+			vol.subDir = strings.Trim(v, "/")
+
+			if len(vol.subDir) == 0 {
+				return status.Error(
+					codes.InvalidArgument,
+					"Context sub-dir must not be empty or root if provided",
+				)
+			}
+			if _, err := parseSubDirTemplate(vol.subDir); err != nil {
+				return err
+			}
+		case VolumeContextEncrypted:
+			encrypted, err := strconv.ParseBool(v)
+			if err != nil {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context encrypted must be a boolean: %v", err,
+				)
+			}
+			vol.encrypted = encrypted
+			subDirAttributes = append(subDirAttributes, k)
+		case VolumeContextUID, VolumeContextGID:
+			id, err := strconv.ParseUint(v, 10, 32)
+			if err != nil || id == math.MaxUint32 {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be a numeric ID, not %q", k, v,
+				)
+			}
+			if strings.ToLower(k) == VolumeContextUID {
+				vol.uid = int64(id)
+			} else {
+				vol.gid = int64(id)
+			}
+			subDirAttributes = append(subDirAttributes, k)
+		case VolumeContextMode:
+			mode, err := strconv.ParseUint(v, 8, 32)
+			if err != nil || mode > 0o7777 {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context mode must be an octal mode no greater than 7777, not %q", v,
+				)
+			}
+			vol.mode = int64(mode)
+			subDirAttributes = append(subDirAttributes, k)
+		case VolumeContextSubDirOnUnpublish:
+			switch v {
+			case SubDirOnUnpublishDelete, SubDirOnUnpublishRetain, SubDirOnUnpublishArchive:
+				vol.subDirOnUnpublish = v
+			default:
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be %q, %q or %q, not %q", k,
+					SubDirOnUnpublishDelete, SubDirOnUnpublishRetain, SubDirOnUnpublishArchive, v,
+				)
+			}
+			subDirAttributes = append(subDirAttributes, k)
+		case VolumeContextSubDirArchive:
+			vol.subDirArchive = strings.Trim(v, "/")
+			if !ensureStrictSubpath(vol.subDirArchive) {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be strict subpath", k,
+				)
+			}
+			subDirAttributes = append(subDirAttributes, k)
+		case VolumeContextHsmOnPublish:
+			if v != HsmOnPublishRestore && v != HsmOnPublishPrefetch {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be %q or %q, not %q", k, HsmOnPublishRestore, HsmOnPublishPrefetch, v,
+				)
+			}
+			vol.hsmOnPublish = v
+		case VolumeContextHsmOnPublishMaxBytes:
+			quantity, err := resource.ParseQuantity(v)
+			if err != nil || quantity.Sign() <= 0 {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be a positive quantity of bytes, not %q", k, v,
+				)
+			}
+			vol.hsmOnPublishMaxBytes = quantity.Value()
+		case VolumeContextPCCMode:
+			if v != PCCModeReadOnly && v != PCCModeReadWrite {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be %q or %q, not %q", k, PCCModeReadOnly, PCCModeReadWrite, v,
+				)
+			}
+			vol.pccMode = v
+		case VolumeContextPCCBackend:
+			if !pccBackendNameRegex.MatchString(v) {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be 1 to 63 letters, digits, '_', '.' or '-'", k,
+				)
+			}
+			vol.pccBackend = v
+		case VolumeContextPCCRule:
+			rule, err := parsePCCRule(v)
+			if err != nil {
+				return err
+			}
+			vol.pccRule = rule
+		case VolumeContextPrefetchPaths:
+			patterns, err := parsePrefetchPatterns(v)
+			if err != nil {
+				return err
+			}
+			vol.prefetchPaths = strings.Join(patterns, ",")
+		case VolumeContextPrefetchManifest:
+			vol.prefetchManifest = strings.Trim(v, "/")
+			if !ensureStrictSubpath(vol.prefetchManifest) {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be strict subpath", k,
+				)
+			}
+		case VolumeContextPrefetchWait:
+			wait, err := strconv.ParseBool(v)
+			if err != nil {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context %s must be a boolean: %v", k, err,
+				)
+			}
+			vol.prefetchWait = wait
+		case VolumeContextDefaultACL:
+			acl, err := volumehelper.ParseACL(v)
+			if err != nil {
+				return status.Errorf(
+					codes.InvalidArgument,
+					"Context default-acl is invalid: %v", err,
+				)
+			}
+			vol.defaultACL = string(acl)
+			subDirAttributes = append(subDirAttributes, k)
+		default:
+			if strings.HasPrefix(strings.ToLower(k), clientTuningPrefix) {
+				setting, err := parseClientTuning(strings.ToLower(k), v)
+				if err != nil {
+					return err
+				}
+				clientTuning = append(clientTuning, setting)
+			}
+		}
+	}
+	slices.Sort(clientTuning)
+	vol.clientTuning = strings.Join(clientTuning, ",")
+
+	if len(vol.subDir) == 0 && len(subDirAttributes) != 0 {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Context %s requires a sub-dir", strings.Join(subDirAttributes, ", "),
+		)
+	}
+
+	if (vol.subDirOnUnpublish == SubDirOnUnpublishArchive) != (len(vol.subDirArchive) != 0) {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Context %s is required with, and only allowed with, %s %s",
+			VolumeContextSubDirArchive, VolumeContextSubDirOnUnpublish, SubDirOnUnpublishArchive,
+		)
+	}
+	if vol.hsmOnPublishMaxBytes != 0 && len(vol.hsmOnPublish) == 0 {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Context %s requires %s", VolumeContextHsmOnPublishMaxBytes, VolumeContextHsmOnPublish,
+		)
+	}
+	if len(vol.pccMode) == 0 && (len(vol.pccBackend) != 0 || len(vol.pccRule) != 0) {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Context %s and %s require %s", VolumeContextPCCBackend, VolumeContextPCCRule, VolumeContextPCCMode,
+		)
+	}
+	if vol.prefetchWait && len(vol.prefetchPaths) == 0 && len(vol.prefetchManifest) == 0 {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Context %s requires %s or %s", VolumeContextPrefetchWait,
+			VolumeContextPrefetchPaths, VolumeContextPrefetchManifest,
+		)
+	}
+	if len(vol.pccMode) != 0 && vol.encrypted {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Context %s is not supported for encrypted volumes", VolumeContextPCCMode,
+		)
+	}
+	if isSubpathOf(vol.subDir, vol.subDirArchive) || isSubpathOf(vol.subDirArchive, vol.subDir) {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"Context %s must not overlap the sub-dir", VolumeContextSubDirArchive,
+		)
+	}
+
+	return nil
+}
+
+// isSubpathOf reports whether path is dir or is below it.
+func isSubpathOf(path, dir string) bool {
+	if len(path) == 0 || len(dir) == 0 {
+		return false
+	}
+	path = filepath.Clean(path)
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+func isEphemeralVolume(context map[string]string) bool {
+	return context[ephemeralKey] == "true"
+}
+
+// getPersistentVolume returns the volume of a PV, which is mounted from its
+// volume handle.
+func getPersistentVolume(volumeID string, context map[string]string) (*lustreVolume, error) {
+	if err := checkPersistentVolumeContext(context); err != nil {
+		return nil, err
+	}
+
+	return getVolume(volumeID, context)
+}
+
+func checkPersistentVolumeContext(context map[string]string) error {
+	if _, ok := context[VolumeContextFilesystem]; ok {
+		return status.Errorf(codes.InvalidArgument,
+			"Context %s is only supported for ephemeral volumes", VolumeContextFilesystem)
+	}
+
+	return nil
+}
+
+// getEphemeralVolume returns the volume of an ephemeral inline volume. kubelet
+// generates its volume ID, so the mount source is given by the filesystem
+// attribute instead. Each pod gets its own sub-dir, which is deleted when the
+// volume is unpublished unless it is to be retained.
+func getEphemeralVolume(context map[string]string) (*lustreVolume, error) {
+	filesystem := context[VolumeContextFilesystem]
+	if len(filesystem) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Ephemeral volume requires context %s", VolumeContextFilesystem)
+	}
+
+	vol, err := getVolume(filesystem, context)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := parseSubDirTemplate(vol.subDir)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(tmpl.Variables(), podUIDMetadata) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Ephemeral volume requires a %s that contains ${%s}, so that each pod has its own directory",
+			VolumeContextSubDir, podUIDMetadata)
+	}
+	if len(vol.subDirOnUnpublish) == 0 {
+		vol.subDirOnUnpublish = SubDirOnUnpublishDelete
+	}
+
+	return vol, nil
 }
 
 // The original getVolume(). It's attempting to interpret more complex volume
@@ -246,7 +768,7 @@ func xx_getVolume(volumeID string, context map[string]string) (*lustreVolume, er
 	return vol, nil
 }
 
-func mountVolumeAtPath(d *Driver, source, target string, volumeType string, mountOptions []string) error {
+func mountVolumeAtPath(d *Driver, source, target string, volumeType string, mountOptions, sensitiveMountOptions []string) error {
 	d.kernelModuleLock.Lock()
 	defer d.kernelModuleLock.Unlock()
 	klog.Infof("Mount source '%s' (%s) at '%s', with options (%s)", source, volumeType, target, mountOptions)
@@ -260,7 +782,7 @@ func mountVolumeAtPath(d *Driver, source, target string, volumeType string, moun
 		target,
 		volumeType, // "lustre",
 		mountOptions,
-		nil,
+		sensitiveMountOptions,
 		[]string{"--no-mtab"},
 	)
 	return err
@@ -284,6 +806,15 @@ func (d *Driver) NodeUnpublishVolume(
 			"Target path missing in request")
 	}
 
+	d.stopHsmPublish(targetPath)
+	d.stopPrefetch(targetPath)
+	d.stopIdmap(targetPath)
+	if err := d.detachPCC(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to detach PCC from target %q: %v", targetPath, err)
+	}
+	d.lockEncryptedVolume(targetPath)
+
 	klog.V(2).Infof("NodeUnpublishVolume: unmounting volume %s on %s",
 		volumeID, targetPath)
 	err := unmountVolumeAtPath(d, targetPath)
@@ -291,6 +822,12 @@ func (d *Driver) NodeUnpublishVolume(
 		return nil, status.Errorf(codes.Internal,
 			"failed to unmount target %q: %v", targetPath, err)
 	}
+
+	if err := d.releasePublishRecord(targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal,
+			"failed to release volume %s on %s: %v", volumeID, targetPath, err)
+	}
+
 	klog.V(2).Infof(
 		"NodeUnpublishVolume: unmount volume %s on %s successfully",
 		volumeID,
@@ -546,8 +1083,8 @@ func (d *Driver) ensureMountPoint(target string) (bool, error) {
 	return !notMnt, nil
 }
 
-func (d *Driver) createSubDir(vol *lustreVolume, mountPath, subDirPath string, mountOptions []string) error {
-	if err := d.internalMount(vol, mountPath, mountOptions); err != nil {
+func (d *Driver) createSubDir(vol *lustreVolume, mountPath, subDirPath string, mountOptions, sensitiveMountOptions []string) error {
+	if err := d.internalMount(vol, mountPath, mountOptions, sensitiveMountOptions); err != nil {
 		return err
 	}
 
@@ -564,10 +1101,279 @@ func (d *Driver) createSubDir(vol *lustreVolume, mountPath, subDirPath string, m
 
 	klog.V(2).Infof("Making subdirectory at %q", internalVolumePath)
 
-	if err := os.MkdirAll(internalVolumePath, 0o775); err != nil {
+	if err := os.MkdirAll(filepath.Dir(internalVolumePath), 0o775); err != nil {
+		return status.Errorf(codes.Internal, "failed to make subdirectory: %v", err.Error())
+	}
+
+	err = os.Mkdir(internalVolumePath, 0o775)
+	created := err == nil
+	if err != nil && !os.IsExist(err) {
 		return status.Errorf(codes.Internal, "failed to make subdirectory: %v", err.Error())
 	}
 
+	if err := setupSubDir(internalVolumePath, vol, created); err != nil {
+		// Remove a sub-dir that was only partly set up, so that it is set up
+		// from scratch when publishing is retried.
+		if created {
+			if removeErr := os.Remove(internalVolumePath); removeErr != nil {
+				klog.Warningf("failed to remove subdirectory %q: %v", internalVolumePath, removeErr)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// cleanUpSubDir deletes or archives the sub-dir of a volume that has been
+// unpublished, through an internal mount of its filesystem. A deleted sub-dir
+// is only moved aside, and the returned function removes its files and the
+// internal mount, which can take a long time and so is called without holding
+// publishStateLock.
+func (d *Driver) cleanUpSubDir(rec *publishRecord) (func() error, error) {
+	sensitiveMountOptions := []string{}
+	if len(rec.SSKKeyPath) != 0 {
+		sensitiveMountOptions = append(sensitiveMountOptions, "skpath="+rec.SSKKeyPath)
+	}
+
+	internalMountPath, unmount, err := d.mountFilesystem(rec.Filesystem, rec.TargetPath, rec.MountOptions, sensitiveMountOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.SubDirOnUnpublish == SubDirOnUnpublishArchive {
+		defer unmount()
+		klog.V(2).Infof("Archiving subdirectory %q of %s into %q", rec.SubDir, rec.Filesystem, rec.SubDirArchive)
+		return nil, archiveSubDir(internalMountPath, rec.SubDir, rec.SubDirArchive)
+	}
+
+	klog.V(2).Infof("Deleting subdirectory %q of %s", rec.SubDir, rec.Filesystem)
+	deletingDir, err := moveSubDirAside(internalMountPath, rec.SubDir)
+	if err != nil {
+		unmount()
+		return nil, err
+	}
+
+	return func() error {
+		defer unmount()
+		return removeSubDir(internalMountPath, deletingDir)
+	}, nil
+}
+
+// checkSubDir returns the path of the sub-dir below the root of a mounted
+// filesystem, and whether it exists. It fails for anything that is not a
+// directory of that filesystem strictly below the root, so that a sub-dir
+// that resolves through a symlink or onto another filesystem is left alone.
+func checkSubDir(root, subDir string) (string, bool, error) {
+	if !ensureStrictSubpath(subDir) {
+		return "", false, status.Errorf(codes.Internal, "sub-dir %q is not strict subpath", subDir)
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", false, status.Errorf(codes.Internal, "failed to resolve %q: %v", root, err)
+	}
+	path := filepath.Join(resolvedRoot, subDir)
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return path, false, nil
+	} else if err != nil {
+		return "", false, status.Errorf(codes.Internal, "failed to resolve sub-dir %q: %v", path, err)
+	}
+	if resolved != path {
+		return "", false, status.Errorf(codes.Internal, "sub-dir %q resolves to %q", path, resolved)
+	}
+
+	var rootStat, pathStat unix.Stat_t
+	if err := unix.Stat(resolvedRoot, &rootStat); err != nil {
+		return "", false, status.Errorf(codes.Internal, "failed to stat %q: %v", resolvedRoot, err)
+	}
+	if err := unix.Lstat(path, &pathStat); err != nil {
+		return "", false, status.Errorf(codes.Internal, "failed to stat %q: %v", path, err)
+	}
+	if pathStat.Dev != rootStat.Dev || pathStat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return "", false, status.Errorf(codes.Internal, "%q is not a directory of the filesystem", path)
+	}
+
+	return path, true, nil
+}
+
+// removeSubDir removes the sub-dir below the root of a mounted filesystem, and
+// everything in it.
+func removeSubDir(root, subDir string) error {
+	path, exists, err := checkSubDir(root, subDir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "refusing to delete sub-dir: %v", status.Convert(err).Message())
+	}
+	if !exists {
+		return nil
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return status.Errorf(codes.Internal, "failed to delete sub-dir %q: %v", path, err)
+	}
+
+	return nil
+}
+
+// moveSubDirAside moves the sub-dir below the root of a mounted filesystem into
+// a hidden sibling directory that holds the sub-dirs that are being deleted,
+// so that the sub-dir can be published again while its old files are removed.
+// It returns the sibling directory, which is left for the caller to remove
+// even if the sub-dir no longer exists, so that an earlier removal that failed
+// part of the way through is finished.
+func moveSubDirAside(root, subDir string) (string, error) {
+	deletingDir := filepath.Join(filepath.Dir(subDir), "."+filepath.Base(subDir)+subDirDeletingSuffix)
+
+	path, exists, err := checkSubDir(root, subDir)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "refusing to delete sub-dir: %v", status.Convert(err).Message())
+	}
+	if !exists {
+		return deletingDir, nil
+	}
+
+	deletingPath, _, err := checkSubDir(root, deletingDir)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "refusing to delete sub-dir: %v", status.Convert(err).Message())
+	}
+	if err := os.Mkdir(deletingPath, 0o700); err != nil && !os.IsExist(err) {
+		return "", status.Errorf(codes.Internal, "failed to create %q: %v", deletingPath, err)
+	}
+
+	dest := filepath.Join(deletingPath, strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err := os.Rename(path, dest); err != nil {
+		return "", status.Errorf(codes.Internal, "failed to move sub-dir %q to %q: %v", path, dest, err)
+	}
+
+	return deletingDir, nil
+}
+
+// archiveSubDir moves the sub-dir below the root of a mounted filesystem into
+// the archive directory, keeping its path relative to the root and adding the
+// time that it was archived, so that it does not collide with an earlier
+// archive of the same sub-dir.
+func archiveSubDir(root, subDir, archiveDir string) error {
+	if isSubpathOf(subDir, archiveDir) || isSubpathOf(archiveDir, subDir) {
+		return status.Errorf(codes.Internal, "refusing to archive sub-dir %q into overlapping %q", subDir, archiveDir)
+	}
+
+	path, exists, err := checkSubDir(root, subDir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "refusing to archive sub-dir: %v", status.Convert(err).Message())
+	}
+	if !exists {
+		return nil
+	}
+
+	archiveParent := filepath.Join(archiveDir, filepath.Dir(subDir))
+	if err := os.MkdirAll(filepath.Join(root, archiveParent), 0o700); err != nil {
+		return status.Errorf(codes.Internal, "failed to make archive directory: %v", err)
+	}
+	archiveParentPath, _, err := checkSubDir(root, archiveParent)
+	if err != nil {
+		return status.Errorf(codes.Internal, "refusing to archive sub-dir: %v", status.Convert(err).Message())
+	}
+
+	archivePath := filepath.Join(archiveParentPath,
+		filepath.Base(subDir)+"."+time.Now().UTC().Format("20060102T150405.000000000Z"))
+	if err := os.Rename(path, archivePath); err != nil {
+		return status.Errorf(codes.Internal, "failed to archive sub-dir %q to %q: %v", path, archivePath, err)
+	}
+
+	return nil
+}
+
+// setupSubDir encrypts the sub-dir, or verifies its encryption, and applies the
+// volume's ownership and permissions. These are applied only to a sub-dir that
+// the driver has just created, never to one that already existed.
+func setupSubDir(path string, vol *lustreVolume, created bool) error {
+	if vol.encrypted {
+		if err := ensureEncryptionPolicy(path, []byte(vol.encryptionKey)); err != nil {
+			return err
+		}
+	}
+
+	if created {
+		return setSubDirAttributes(path, vol)
+	}
+
+	return nil
+}
+
+// setSubDirAttributes applies the volume's default ACL, ownership and mode to a
+// newly created sub-dir.
+func setSubDirAttributes(path string, vol *lustreVolume) error {
+	if len(vol.defaultACL) != 0 {
+		if err := unix.Setxattr(path, volumehelper.DefaultACLXattr, []byte(vol.defaultACL), 0); err != nil {
+			if errors.Is(err, unix.EOPNOTSUPP) {
+				return status.Errorf(codes.FailedPrecondition,
+					"failed to set default ACL on %q: ACLs are not enabled on the filesystem", path)
+			}
+			return status.Errorf(codes.Internal, "failed to set default ACL on %q: %v", path, err)
+		}
+	}
+
+	if vol.uid >= 0 || vol.gid >= 0 {
+		if err := os.Lchown(path, int(vol.uid), int(vol.gid)); err != nil {
+			return status.Errorf(codes.Internal, "failed to change owner of %q: %v", path, err)
+		}
+	}
+
+	// Set the mode last, as changing the owner may clear the setgid bit.
+	if vol.mode >= 0 {
+		if err := unix.Chmod(path, uint32(vol.mode)); err != nil {
+			return status.Errorf(codes.Internal, "failed to change mode of %q: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// applyVolumeMountGroupToVolume applies the volume mount group, if any, to the
+// volume mounted at target. The group is only applied to sub-dirs, which the
+// driver creates, and never to the root of a filesystem that is shared with
+// others.
+func applyVolumeMountGroupToVolume(vol *lustreVolume, target string, volumeMountGroup int64, readOnly bool) error {
+	if volumeMountGroup < 0 || readOnly {
+		return nil
+	}
+	if len(vol.subDir) == 0 {
+		klog.V(2).Infof("not applying volume mount group %d to the root of filesystem %s", volumeMountGroup, vol.id)
+		return nil
+	}
+
+	return applyVolumeMountGroup(target, uint32(volumeMountGroup))
+}
+
+// applyVolumeMountGroup gives the sub-dir of a volume the same group ownership
+// and permissions that kubelet gives a volume for a pod's fsGroup: the group
+// owns the directory, has read, write and search permission, and new files
+// inherit the group through the setgid bit. Unlike kubelet, the tree below the
+// sub-dir is not walked, which would take far too long on a Lustre filesystem.
+func applyVolumeMountGroup(path string, gid uint32) error {
+	st := unix.Stat_t{}
+	if err := unix.Stat(path, &st); err != nil {
+		return status.Errorf(codes.Internal, "failed to stat %q: %v", path, err)
+	}
+
+	if st.Gid != gid {
+		klog.V(2).Infof("changing group of %q to %d", path, gid)
+		if err := os.Lchown(path, -1, int(gid)); err != nil {
+			return status.Errorf(codes.Internal, "failed to change group of %q: %v", path, err)
+		}
+	}
+
+	// Set the mode after the group, as changing the group may clear the
+	// setgid bit.
+	mode := st.Mode & 0o7777
+	if wanted := mode | unix.S_ISGID | 0o070; st.Gid != gid || wanted != mode {
+		if err := unix.Chmod(path, wanted); err != nil {
+			return status.Errorf(codes.Internal, "failed to change mode of %q: %v", path, err)
+		}
+	}
+
 	return nil
 }
 
@@ -615,7 +1421,7 @@ func getInternalVolumePath(workingMountDir, mountPath, subDirPath string) (strin
 	return filepath.Join(internalMountPath, subDirPath), nil
 }
 
-func (d *Driver) internalMount(vol *lustreVolume, mountPath string, mountOptions []string) error {
+func (d *Driver) internalMount(vol *lustreVolume, mountPath string, mountOptions, sensitiveMountOptions []string) error {
 	source := getSourceString(vol.mgsIPAddress, vol.hpeLustreName)
 
 	target, err := getInternalMountPath(d.workingMountDir, mountPath)
@@ -654,7 +1460,7 @@ func (d *Driver) internalMount(vol *lustreVolume, mountPath string, mountOptions
 		vol.id, source, target, mountOptions,
 	)
 
-	err = mountVolumeAtPath(d, source, target, "lustre", mountOptions)
+	err = mountVolumeAtPath(d, source, target, "lustre", mountOptions, sensitiveMountOptions)
 	if err != nil {
 		if removeErr := os.Remove(target); removeErr != nil {
 			return status.Errorf(
@@ -672,6 +1478,35 @@ func (d *Driver) internalMount(vol *lustreVolume, mountPath string, mountOptions
 	return nil
 }
 
+// mountFilesystem mounts the filesystem of a volume handle in the working
+// directory, at the path given by mountPath, and returns where it is mounted
+// along with a function that unmounts it.
+func (d *Driver) mountFilesystem(handle, mountPath string, mountOptions, sensitiveMountOptions []string) (string, func(), error) {
+	mgsIPAddress, lustreName, err := parseVolumeHandle(handle)
+	if err != nil {
+		return "", nil, err
+	}
+	vol := newVolume(handle)
+	vol.mgsIPAddress = mgsIPAddress
+	vol.hpeLustreName = lustreName
+
+	internalMountPath, err := getInternalMountPath(d.workingMountDir, mountPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := d.internalMount(vol, mountPath, mountOptions, sensitiveMountOptions); err != nil {
+		return "", nil, err
+	}
+	unmount := func() {
+		if err := d.internalUnmount(mountPath); err != nil {
+			klog.Warningf("failed to unmount lustre server: %v", err.Error())
+		}
+	}
+
+	return internalMountPath, unmount, nil
+}
+
 func (d *Driver) internalUnmount(mountPath string) error {
 	target, err := getInternalMountPath(d.workingMountDir, mountPath)
 	if err != nil {
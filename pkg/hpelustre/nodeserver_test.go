@@ -0,0 +1,306 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetVolume(t *testing.T) {
+	tests := []struct {
+		desc          string
+		volumeID      string
+		context       map[string]string
+		id            string
+		mgsIPAddress  string
+		hpeLustreName string
+		subDir        string
+		code          codes.Code
+	}{
+		{
+			desc:     "whole filesystem",
+			volumeID: "10.1.1.113@tcp:/lushtx",
+			id:       "10.1.1.113@tcp:/lushtx",
+		},
+		{
+			desc:          "sub-dir in the volume ID",
+			volumeID:      "10.1.1.113@tcp:/lushtx#volumes/pvc-1",
+			id:            "10.1.1.113@tcp:/lushtx",
+			mgsIPAddress:  "10.1.1.113@tcp",
+			hpeLustreName: "lushtx",
+			subDir:        "volumes/pvc-1",
+		},
+		{
+			desc:          "sub-dir in the volume ID with failover MGS",
+			volumeID:      "10.1.1.113@tcp:10.1.1.114@tcp:/lushtx#volumes/pvc-1",
+			id:            "10.1.1.113@tcp:10.1.1.114@tcp:/lushtx",
+			mgsIPAddress:  "10.1.1.113@tcp:10.1.1.114@tcp",
+			hpeLustreName: "lushtx",
+			subDir:        "volumes/pvc-1",
+		},
+		{
+			desc:          "sub-dir in the context",
+			volumeID:      "10.1.1.113@tcp:/lushtx",
+			context:       map[string]string{"sub-dir": "/team/${pvc.metadata.name}/"},
+			id:            "10.1.1.113@tcp:/lushtx",
+			mgsIPAddress:  "10.1.1.113@tcp",
+			hpeLustreName: "lushtx",
+			subDir:        "team/${pvc.metadata.name}",
+		},
+		{
+			desc:     "sub-dir in both the volume ID and the context",
+			volumeID: "10.1.1.113@tcp:/lushtx#volumes/pvc-1",
+			context:  map[string]string{"sub-dir": "team"},
+			code:     codes.InvalidArgument,
+		},
+		{
+			desc:     "sub-dir in the volume ID escapes the filesystem",
+			volumeID: "10.1.1.113@tcp:/lushtx#../other",
+			code:     codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			vol, err := getVolume(test.volumeID, test.context)
+			if test.code != codes.OK {
+				require.Error(t, err)
+				assert.Equal(t, test.code, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.id, vol.id)
+			assert.Equal(t, test.mgsIPAddress, vol.mgsIPAddress)
+			assert.Equal(t, test.hpeLustreName, vol.hpeLustreName)
+			assert.Equal(t, test.subDir, vol.subDir)
+		})
+	}
+}
+
+func TestMoveSubDirAside(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "csi/a/data"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "csi/a/data/file"), []byte("data"), 0o644))
+
+	deletingDir, err := moveSubDirAside(root, "csi/a")
+	require.NoError(t, err)
+	assert.Equal(t, "csi/.a"+subDirDeletingSuffix, deletingDir)
+	assert.NoDirExists(t, filepath.Join(root, "csi/a"))
+
+	// The sub-dir may be published again before the old files are removed.
+	require.NoError(t, os.Mkdir(filepath.Join(root, "csi/a"), 0o755))
+	deletingDir, err = moveSubDirAside(root, "csi/a")
+	require.NoError(t, err)
+	entries, err := os.ReadDir(filepath.Join(root, deletingDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	require.NoError(t, removeSubDir(root, deletingDir))
+	assert.NoDirExists(t, filepath.Join(root, deletingDir))
+
+	// A sub-dir that is gone leaves only the removal to finish.
+	deletingDir, err = moveSubDirAside(root, "csi/a")
+	require.NoError(t, err)
+	assert.Equal(t, "csi/.a"+subDirDeletingSuffix, deletingDir)
+
+	require.NoError(t, os.Symlink(root, filepath.Join(root, "csi/b")))
+	_, err = moveSubDirAside(root, "csi/b")
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestApplyVolumeMountGroupToVolume(t *testing.T) {
+	// Only root may give a directory a group that it is not a member of.
+	gid := int64(os.Getgid())
+	if os.Geteuid() == 0 {
+		gid = 1234
+	}
+
+	tests := []struct {
+		desc     string
+		vol      *lustreVolume
+		group    int64
+		readOnly bool
+		applied  bool
+	}{
+		{
+			desc:    "sub-dir",
+			vol:     &lustreVolume{id: "10.1.1.113@tcp:/lushtx", subDir: "scratch"},
+			group:   gid,
+			applied: true,
+		},
+		{
+			desc:  "whole filesystem",
+			vol:   &lustreVolume{id: "10.1.1.113@tcp:/lushtx"},
+			group: gid,
+		},
+		{
+			desc:     "read-only sub-dir",
+			vol:      &lustreVolume{id: "10.1.1.113@tcp:/lushtx", subDir: "scratch"},
+			group:    gid,
+			readOnly: true,
+		},
+		{
+			desc:  "no volume mount group",
+			vol:   &lustreVolume{id: "10.1.1.113@tcp:/lushtx", subDir: "scratch"},
+			group: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "mount")
+			require.NoError(t, os.Mkdir(target, 0o700))
+			before := unix.Stat_t{}
+			require.NoError(t, unix.Stat(target, &before))
+
+			require.NoError(t, applyVolumeMountGroupToVolume(test.vol, target, test.group, test.readOnly))
+
+			after := unix.Stat_t{}
+			require.NoError(t, unix.Stat(target, &after))
+			if test.applied {
+				assert.Equal(t, uint32(test.group), after.Gid)
+				assert.Equal(t, uint32(0o2770), after.Mode&0o7777)
+			} else {
+				assert.Equal(t, before.Gid, after.Gid)
+				assert.Equal(t, uint32(0o700), after.Mode&0o7777)
+			}
+		})
+	}
+}
+
+func TestGetSELinuxContextOption(t *testing.T) {
+	tests := []struct {
+		desc     string
+		option   string
+		expected string
+		valid    bool
+	}{
+		{
+			desc:     "label",
+			option:   "context=system_u:object_r:container_file_t:s0",
+			expected: `context="system_u:object_r:container_file_t:s0"`,
+			valid:    true,
+		},
+		{
+			desc:     "label with categories",
+			option:   "context=system_u:object_r:container_file_t:s0:c1,c2",
+			expected: `context="system_u:object_r:container_file_t:s0:c1,c2"`,
+			valid:    true,
+		},
+		{
+			desc:     "quoted label",
+			option:   `context="system_u:object_r:container_file_t:s0:c1,c2"`,
+			expected: `context="system_u:object_r:container_file_t:s0:c1,c2"`,
+			valid:    true,
+		},
+		{
+			desc:     "label without level",
+			option:   "context=system_u:object_r:container_file_t",
+			expected: `context="system_u:object_r:container_file_t"`,
+			valid:    true,
+		},
+		{
+			desc:   "too few parts",
+			option: "context=container_file_t",
+		},
+		{
+			desc:   "empty part",
+			option: "context=system_u::container_file_t:s0",
+		},
+		{
+			desc:   "another option in the label",
+			option: `context="system_u:object_r:container_file_t:s0",rw`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			option, err := getSELinuxContextOption(test.option)
+			if !test.valid {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, option)
+		})
+	}
+}
+
+func TestGetMountOptions(t *testing.T) {
+	const seLinuxContext = "context=system_u:object_r:container_file_t:s0:c1,c2"
+	const quotedSELinuxContext = `context="system_u:object_r:container_file_t:s0:c1,c2"`
+
+	tests := []struct {
+		desc     string
+		readOnly bool
+		flags    []string
+		expected []string
+		code     codes.Code
+	}{
+		{
+			desc:     "pass-through",
+			flags:    []string{"flock", "noatime"},
+			expected: []string{"flock", "noatime"},
+		},
+		{
+			desc:     "read-only request",
+			readOnly: true,
+			flags:    []string{"ro", "flock"},
+			expected: []string{"ro", "flock"},
+		},
+		{
+			desc:     "SELinux context",
+			flags:    []string{"flock", seLinuxContext},
+			expected: []string{"flock", quotedSELinuxContext},
+		},
+		{
+			desc:     "SELinux context already present",
+			flags:    []string{quotedSELinuxContext, "flock", seLinuxContext},
+			expected: []string{quotedSELinuxContext, "flock"},
+		},
+		{
+			desc:  "conflicting SELinux contexts",
+			flags: []string{seLinuxContext, "context=system_u:object_r:container_file_t:s0:c3,c4"},
+			code:  codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := &csi.NodePublishVolumeRequest{Readonly: test.readOnly}
+			options, readOnly, err := getMountOptions(req, test.flags)
+			require.Equal(t, test.code, status.Code(err), "%v", err)
+			if test.code != codes.OK {
+				return
+			}
+			assert.Equal(t, test.expected, options)
+			assert.Equal(t, test.readOnly, readOnly)
+		})
+	}
+}
@@ -0,0 +1,381 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Lustre Persistent Client Cache (PCC) caches the files of a client mount on
+// the node's local storage. The backends that a node may cache files in are
+// read from a file, normally a mounted ConfigMap, and reloaded when it
+// changes:
+//
+//	backends:
+//	  - name: nvme
+//	    path: /mnt/pcc
+//	    readOnlyID: 5
+//	    readWriteID: 2
+//
+// A volume that asks for caching has a directory of a backend attached to the
+// client mount that it is published through, with a rule that selects which of
+// its files are cached. As the client mount only has the volume's fileset,
+// nothing else is cached through it. The backend is detached before the last
+// user of the mount, its target, is unpublished, and its directory removed.
+type pccConfig struct {
+	Backends []pccBackend `json:"backends"`
+}
+
+type pccBackend struct {
+	// Name that volumes refer to the backend by
+	Name string `json:"name"`
+	// Directory on the node's local storage that files are cached in. A
+	// backend whose directory does not exist is not available on the node.
+	Path string `json:"path"`
+	// HSM archive IDs of read-only and read-write caching, which is not
+	// available through the backend if its ID is 0
+	ReadOnlyID  uint32 `json:"readOnlyID,omitempty"`
+	ReadWriteID uint32 `json:"readWriteID,omitempty"`
+}
+
+// Values of VolumeContextPCCMode
+const (
+	PCCModeReadOnly  = "ro"
+	PCCModeReadWrite = "rw"
+)
+
+// Values of VolumeContextPCCRule, which are followed by "=<value>" except for
+// the project of the volume
+const (
+	PCCRuleProject   = "project"
+	PCCRuleProjectID = "project-id"
+	PCCRuleFileName  = "fname"
+)
+
+var (
+	pccBackendNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,63}$`)
+
+	pccStatSamples = prometheus.NewDesc("lustre_csi_pcc_stat_samples",
+		"Samples of a PCC or I/O counter of the client mounts that a volume is cached through on the node.",
+		[]string{"volume_id", "stat"}, nil)
+	pccStatBytes = prometheus.NewDesc("lustre_csi_pcc_stat_bytes",
+		"Bytes of a PCC or I/O counter of the client mounts that a volume is cached through on the node.",
+		[]string{"volume_id", "stat"}, nil)
+)
+
+func parsePCCConfig(data []byte) (*pccConfig, error) {
+	config := &pccConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for i := range config.Backends {
+		backend := &config.Backends[i]
+		if !pccBackendNameRegex.MatchString(backend.Name) {
+			return nil, fmt.Errorf("backend %d: name must be 1 to 63 letters, digits, '_', '.' or '-'", i)
+		}
+		if names[backend.Name] {
+			return nil, fmt.Errorf("backend %q is given more than once", backend.Name)
+		}
+		names[backend.Name] = true
+		if !filepath.IsAbs(backend.Path) {
+			return nil, fmt.Errorf("backend %q: path must be absolute", backend.Name)
+		}
+		backend.Path = filepath.Clean(backend.Path)
+		if backend.ReadOnlyID == 0 && backend.ReadWriteID == 0 {
+			return nil, fmt.Errorf("backend %q: readOnlyID or readWriteID must be given", backend.Name)
+		}
+	}
+
+	return config, nil
+}
+
+// loadPCCConfig returns the PCC configuration of the node, reading the
+// configuration file again if it has changed since it was last read.
+func (d *Driver) loadPCCConfig() (*pccConfig, error) {
+	d.pccConfigLock.Lock()
+	defer d.pccConfigLock.Unlock()
+
+	info, err := os.Stat(d.pccConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if d.pccConfig != nil && info.ModTime().Equal(d.pccConfigModTime) {
+		return d.pccConfig, nil
+	}
+
+	data, err := os.ReadFile(d.pccConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parsePCCConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %w", d.pccConfigFile, err)
+	}
+
+	klog.V(2).Infof("loaded PCC configuration %q with %d backends", d.pccConfigFile, len(config.Backends))
+	d.pccConfig = config
+	d.pccConfigModTime = info.ModTime()
+	return config, nil
+}
+
+// parsePCCRule parses the PCC rule of a volume context, and returns it in the
+// form that lctl pcc add takes, or "" for the project of the volume, which is
+// only known once it is mounted.
+func parsePCCRule(rule string) (string, error) {
+	kind, value, _ := strings.Cut(rule, "=")
+	switch kind {
+	case PCCRuleProject:
+		if len(value) == 0 {
+			return "", nil
+		}
+	case PCCRuleProjectID:
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err == nil && id != 0 {
+			return fmt.Sprintf("projid={%d}", id), nil
+		}
+	case PCCRuleFileName:
+		patterns := strings.Split(value, ",")
+		valid := len(value) != 0
+		for _, pattern := range patterns {
+			valid = valid && len(pattern) != 0 && !strings.ContainsAny(pattern, " \t{}&")
+		}
+		if valid {
+			return fmt.Sprintf("fname={%s}", strings.Join(patterns, " ")), nil
+		}
+	}
+
+	return "", status.Errorf(codes.InvalidArgument,
+		"Context %s must be %s, %s=<id> or %s=<pattern>[,<pattern>...], not %q",
+		VolumeContextPCCRule, PCCRuleProject, PCCRuleProjectID, PCCRuleFileName, rule)
+}
+
+// pccCacheDir returns the directory of a backend that the files of the
+// client mount at target are cached in.
+func pccCacheDir(backend *pccBackend, target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(backend.Path, hex.EncodeToString(sum[:8]))
+}
+
+// attachPCC attaches a directory of a PCC backend to the client mount of a
+// volume at target, and returns the directory.
+func (d *Driver) attachPCC(volumeID, target string, vol *lustreVolume, readOnly bool) (string, error) {
+	if len(d.pccConfigFile) == 0 {
+		return "", status.Errorf(codes.FailedPrecondition,
+			"Volume %s asks for PCC caching, which is not enabled on node %s", volumeID, d.NodeID)
+	}
+	config, err := d.loadPCCConfig()
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Could not load PCC configuration: %v", err)
+	}
+
+	var backend *pccBackend
+	for i := range config.Backends {
+		if config.Backends[i].Name == vol.pccBackend || (len(vol.pccBackend) == 0 && len(config.Backends) == 1) {
+			backend = &config.Backends[i]
+		}
+	}
+	if backend == nil {
+		return "", status.Errorf(codes.FailedPrecondition,
+			"PCC backend %q of volume %s is not configured on node %s", vol.pccBackend, volumeID, d.NodeID)
+	}
+
+	archiveID, param := backend.ReadWriteID, "rwid"
+	if vol.pccMode == PCCModeReadOnly {
+		archiveID, param = backend.ReadOnlyID, "roid"
+	} else if readOnly {
+		return "", status.Errorf(codes.InvalidArgument,
+			"Volume %s asks for read-write PCC caching, but is published read-only", volumeID)
+	}
+	if archiveID == 0 {
+		return "", status.Errorf(codes.FailedPrecondition,
+			"PCC backend %q does not allow %s caching", backend.Name, vol.pccMode)
+	}
+	if info, err := os.Stat(backend.Path); err != nil || !info.IsDir() {
+		return "", status.Errorf(codes.FailedPrecondition,
+			"PCC backend %q is not available on node %s: %s is not a directory", backend.Name, d.NodeID, backend.Path)
+	}
+
+	rule := vol.pccRule
+	if len(rule) == 0 {
+		projectID, err := d.getPCCProject(volumeID, target, readOnly)
+		if err != nil {
+			return "", err
+		}
+		rule = fmt.Sprintf("projid={%d}", projectID)
+	}
+	params := fmt.Sprintf("%s %s=%d", rule, param, archiveID)
+	if vol.pccMode == PCCModeReadOnly {
+		params += " ro"
+	}
+
+	cacheDir := pccCacheDir(backend, target)
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return "", status.Errorf(codes.Internal, "Could not make PCC directory %q: %v", cacheDir, err)
+	}
+	klog.V(2).Infof("attaching PCC directory %s to %s with %q", cacheDir, target, params)
+	if _, err := d.runLctl("pcc", "add", target, cacheDir, "--param", params); err != nil {
+		_ = os.RemoveAll(cacheDir)
+		return "", status.Errorf(codes.Internal, "Could not attach PCC backend %q: %v", backend.Name, err)
+	}
+
+	return cacheDir, nil
+}
+
+// getPCCProject returns the project of a volume published at target, giving
+// it a project allocated for its volume ID if it has none, which only the
+// files that are created from then on inherit.
+func (d *Driver) getPCCProject(volumeID, target string, readOnly bool) (uint32, error) {
+	if output, err := d.runLfs("project", "-d", target); err == nil {
+		if projectID, err := volumehelper.ParseLfsProject(output); err == nil && projectID != 0 {
+			return projectID, nil
+		}
+	}
+	if readOnly {
+		return 0, status.Errorf(codes.FailedPrecondition,
+			"Volume %s has no project to select the files that PCC caches; give %s another rule", volumeID, VolumeContextPCCRule)
+	}
+
+	projectID, err := d.allocateVolumeProject(target, target, volumeID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := d.runLfs("project", "-p", strconv.FormatUint(uint64(projectID), 10), "-s", target); err != nil {
+		return 0, status.Errorf(codes.Internal, "Could not set project of %q: %v", target, err)
+	}
+	return projectID, nil
+}
+
+// detachPCC detaches the PCC directory from the client mount at target, if
+// the volume published there has one, so that the files cached through it
+// are detached before the mount is unmounted.
+func (d *Driver) detachPCC(target string) error {
+	d.publishStateLock.Lock()
+	defer d.publishStateLock.Unlock()
+
+	rec, err := d.loadPublishRecord(target)
+	if err != nil || rec == nil || len(rec.PCCPath) == 0 {
+		return err
+	}
+	if notMnt, err := d.mounter.IsLikelyNotMountPoint(target); err != nil || notMnt {
+		return nil
+	}
+
+	output, err := d.runLctl("pcc", "list", target)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(output, rec.PCCPath) {
+		return nil
+	}
+	klog.V(2).Infof("detaching PCC directory %s from %s", rec.PCCPath, target)
+	_, err = d.runLctl("pcc", "del", target, rec.PCCPath)
+	return err
+}
+
+// runLctl runs lctl and returns its output.
+func (d *Driver) runLctl(args ...string) (string, error) {
+	output, err := d.mounter.Exec.Command("lctl", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("lctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// pccCollector reports the counters of the llite stats of the client mounts
+// that volumes are cached through: the PCC counters, from which cache hits
+// are seen, along with the bytes read and written.
+type pccCollector struct {
+	d *Driver
+}
+
+func (c *pccCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pccStatSamples
+	ch <- pccStatBytes
+}
+
+func (c *pccCollector) Collect(ch chan<- prometheus.Metric) {
+	c.d.publishStateLock.Lock()
+	records, err := c.d.listPublishRecords()
+	c.d.publishStateLock.Unlock()
+	if err != nil {
+		klog.Warningf("could not list published volumes for PCC metrics: %v", err)
+		return
+	}
+
+	type key struct{ volumeID, stat string }
+	samples, bytes := map[key]int64{}, map[key]int64{}
+	for _, rec := range records {
+		if len(rec.PCCPath) == 0 {
+			continue
+		}
+		stats, err := c.d.getClientStats(rec.TargetPath)
+		if err != nil {
+			klog.V(4).Infof("could not get stats of %q: %v", rec.TargetPath, err)
+			continue
+		}
+		for name, stat := range stats {
+			if !strings.HasPrefix(name, "pcc_") && name != "read_bytes" && name != "write_bytes" {
+				continue
+			}
+			k := key{volumeID: rec.VolumeID, stat: name}
+			samples[k] += stat.Samples
+			if stat.Unit == "bytes" {
+				bytes[k] += stat.Sum
+			}
+		}
+	}
+
+	for k, v := range samples {
+		ch <- prometheus.MustNewConstMetric(pccStatSamples, prometheus.CounterValue, float64(v), k.volumeID, k.stat)
+	}
+	for k, v := range bytes {
+		ch <- prometheus.MustNewConstMetric(pccStatBytes, prometheus.CounterValue, float64(v), k.volumeID, k.stat)
+	}
+}
+
+// getClientStats returns the llite stats of the client mount at target.
+func (d *Driver) getClientStats(target string) (map[string]volumehelper.LctlStat, error) {
+	instance, err := d.getClientInstance(target)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := d.runLctl("get_param", "-n", "llite."+instance+".stats")
+	if err != nil {
+		return nil, err
+	}
+	return volumehelper.ParseLctlStats(output)
+}
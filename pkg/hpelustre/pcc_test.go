@@ -0,0 +1,95 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePCCRule(t *testing.T) {
+	tests := []struct {
+		desc     string
+		rule     string
+		expected string
+		code     codes.Code
+	}{
+		{
+			desc: "project of the volume",
+			rule: "project",
+		},
+		{
+			desc:     "project ID",
+			rule:     "project-id=1000",
+			expected: "projid={1000}",
+		},
+		{
+			desc:     "file names",
+			rule:     "fname=*.h5,*.tfrecord",
+			expected: "fname={*.h5 *.tfrecord}",
+		},
+		{
+			desc: "project with a value",
+			rule: "project=1000",
+			code: codes.InvalidArgument,
+		},
+		{
+			desc: "project ID 0",
+			rule: "project-id=0",
+			code: codes.InvalidArgument,
+		},
+		{
+			desc: "project ID not a number",
+			rule: "project-id=team",
+			code: codes.InvalidArgument,
+		},
+		{
+			desc: "empty file name pattern",
+			rule: "fname=*.h5,",
+			code: codes.InvalidArgument,
+		},
+		{
+			desc: "file name pattern with a space",
+			rule: "fname=a b",
+			code: codes.InvalidArgument,
+		},
+		{
+			desc: "file name pattern closing the rule",
+			rule: "fname=*}&uid={0",
+			code: codes.InvalidArgument,
+		},
+		{
+			desc: "unknown kind",
+			rule: "uid=1000",
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			parsed, err := parsePCCRule(test.rule)
+			assert.Equal(t, test.code, status.Code(err))
+			assert.Equal(t, test.expected, parsed)
+		})
+	}
+}
@@ -0,0 +1,395 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// A volume with a large, read-mostly dataset can ask for some of its files to
+// be prefetched when it is published, by patterns in its volume context or in
+// a manifest file in the volume. The node plugin walks the volume in the
+// background and has the OSTs read the matching files ahead with
+// "lfs ladvise -a willneed". Every prefetch on the node shares
+// --prefetch-workers workers. NodePublishVolume only waits for the prefetch if
+// the volume asks it to.
+
+const (
+	// Lines of a prefetch manifest that start with this are comments
+	prefetchManifestComment = "#"
+	// Most bytes of a prefetch manifest that are read
+	maxPrefetchManifestBytes = 1 << 20
+)
+
+var (
+	prefetchActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lustre_csi_prefetch_active",
+		Help: "Number of prefetches of published volumes that are running on the node.",
+	})
+	prefetchFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_prefetch_files_total",
+		Help: "Number of files of a volume that have been advised to be prefetched on the node.",
+	}, []string{"volume_id"})
+	prefetchBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_prefetch_bytes_total",
+		Help: "Number of bytes of a volume that have been advised to be prefetched on the node.",
+	}, []string{"volume_id"})
+	prefetchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_prefetch_errors_total",
+		Help: "Number of files of a volume that could not be advised to be prefetched on the node.",
+	}, []string{"volume_id"})
+	prefetchCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_prefetch_completed_total",
+		Help: "Number of prefetches of a volume that have walked the whole volume on the node.",
+	}, []string{"volume_id"})
+)
+
+func init() {
+	prometheus.MustRegister(prefetchActive, prefetchFiles, prefetchBytes, prefetchErrors, prefetchCompleted)
+}
+
+// parsePrefetchPatterns parses a comma-separated list of patterns of the
+// files of a volume to prefetch.
+func parsePrefetchPatterns(value string) ([]string, error) {
+	patterns := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		if err := checkPrefetchPattern(pattern); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Context %s is invalid: %v", VolumeContextPrefetchPaths, err)
+		}
+		patterns = append(patterns, cleanPrefetchPattern(pattern))
+	}
+	if len(patterns) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Context %s must have a pattern", VolumeContextPrefetchPaths)
+	}
+	return patterns, nil
+}
+
+// checkPrefetchPattern checks that a pattern is a valid glob relative to the
+// root of the volume.
+func checkPrefetchPattern(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	if !ensureStrictSubpath(strings.Trim(pattern, "/")) {
+		return fmt.Errorf("pattern %q must be strict subpath", pattern)
+	}
+	return nil
+}
+
+// cleanPrefetchPattern returns a pattern relative to the root of the volume,
+// with a single "/" between its components.
+func cleanPrefetchPattern(pattern string) string {
+	return filepath.Clean(strings.Trim(pattern, "/"))
+}
+
+// matchesPrefetchPattern reports whether a path relative to the root of a
+// volume, or any directory above it, matches one of the patterns.
+func matchesPrefetchPattern(patterns []string, rel string) bool {
+	for dir := rel; dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, dir); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mayMatchBelowPrefetchPattern reports whether files below a directory,
+// relative to the root of a volume, may match one of the patterns. As "*"
+// does not match "/", each component of the directory has to match the
+// component of a longer pattern, and the walk of a volume skips the
+// directories that cannot.
+func mayMatchBelowPrefetchPattern(patterns []string, dir string) bool {
+	parts := strings.Split(dir, "/")
+	for _, pattern := range patterns {
+		patternParts := strings.Split(pattern, "/")
+		if len(patternParts) <= len(parts) {
+			continue
+		}
+		matched := true
+		for i, part := range parts {
+			if ok, _ := filepath.Match(patternParts[i], part); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// openInVolume opens a regular file below root one path component at a time,
+// without following symbolic links, so that a pod cannot have the node plugin
+// open a file out of the volume by swapping a component for a link while it
+// is being opened.
+func openInVolume(root, rel string) (*os.File, error) {
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	parts := strings.Split(filepath.Clean(rel), "/")
+	for i, part := range parts {
+		flags := unix.O_RDONLY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+		if i < len(parts)-1 {
+			flags |= unix.O_DIRECTORY
+		} else {
+			// Opening a FIFO must not block.
+			flags |= unix.O_NONBLOCK
+		}
+		next, err := unix.Openat(fd, part, flags, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: filepath.Join(root, filepath.Join(parts[:i+1]...)), Err: err}
+		}
+		fd = next
+	}
+
+	f := os.NewFile(uintptr(fd), filepath.Join(root, rel))
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%q is not a regular file", rel)
+	}
+	return f, nil
+}
+
+// readPrefetchManifest reads the patterns of a manifest file in the volume
+// published at target, one per line.
+func readPrefetchManifest(target, manifest string) ([]string, error) {
+	f, err := openInVolume(target, manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(io.LimitReader(f, maxPrefetchManifestBytes))
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if len(pattern) == 0 || strings.HasPrefix(pattern, prefetchManifestComment) {
+			continue
+		}
+		if err := checkPrefetchPattern(pattern); err != nil {
+			return nil, fmt.Errorf("manifest %q line %d: %w", manifest, line, err)
+		}
+		patterns = append(patterns, cleanPrefetchPattern(pattern))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// startPrefetch prefetches the matching files of a volume published at
+// target in the background. A volume that was just mounted replaces any
+// prefetch of the target that is still running, while a volume that was
+// already mounted keeps a prefetch that is running or has succeeded.
+func (d *Driver) startPrefetch(volumeID, target string, vol *lustreVolume, mounted bool) *backgroundCopy {
+	d.prefetchLock.Lock()
+	defer d.prefetchLock.Unlock()
+
+	if c, ok := d.prefetchTasks[target]; ok {
+		if mounted && (!c.finished() || c.err == nil) {
+			return c
+		}
+		c.stop()
+	}
+
+	description := fmt.Sprintf("prefetch of volume %s at %s", volumeID, target)
+	c := startBackgroundCopy(description, func(ctx context.Context) error {
+		prefetchActive.Inc()
+		defer prefetchActive.Dec()
+		return d.prefetchVolume(ctx, volumeID, target, vol)
+	})
+	d.prefetchTasks[target] = c
+	d.prefetchVolumes[target] = volumeID
+	return c
+}
+
+// waitForPrefetch waits for a prefetch to finish, or until ctx is done, for a
+// volume that asks for publishing to wait for it.
+func waitForPrefetch(ctx context.Context, target string, c *backgroundCopy) error {
+	select {
+	case <-c.done:
+		if c.err != nil {
+			return status.Errorf(codes.Internal, "Could not prefetch volume at %q: %v", target, c.err)
+		}
+		return nil
+	case <-ctx.Done():
+		return status.Errorf(codes.DeadlineExceeded, "Volume at %q is still being prefetched", target)
+	}
+}
+
+// stopPrefetch stops the prefetch of the volume published at target, if it is
+// still running. The metrics of the volume are removed once it has no other
+// prefetch on the node.
+func (d *Driver) stopPrefetch(target string) {
+	d.prefetchLock.Lock()
+	defer d.prefetchLock.Unlock()
+
+	c, ok := d.prefetchTasks[target]
+	if !ok {
+		return
+	}
+	c.stop()
+	volumeID := d.prefetchVolumes[target]
+	delete(d.prefetchTasks, target)
+	delete(d.prefetchVolumes, target)
+
+	for _, other := range d.prefetchVolumes {
+		if other == volumeID {
+			return
+		}
+	}
+	for _, metric := range []*prometheus.CounterVec{prefetchFiles, prefetchBytes, prefetchErrors, prefetchCompleted} {
+		metric.DeleteLabelValues(volumeID)
+	}
+}
+
+// prefetchVolume advises the OSTs to read the files of the volume published at
+// target that match its patterns or manifest.
+func (d *Driver) prefetchVolume(ctx context.Context, volumeID, target string, vol *lustreVolume) error {
+	patterns := []string{}
+	if len(vol.prefetchPaths) != 0 {
+		patterns = strings.Split(vol.prefetchPaths, ",")
+	}
+	if len(vol.prefetchManifest) != 0 {
+		manifest, err := readPrefetchManifest(target, vol.prefetchManifest)
+		if err != nil {
+			return fmt.Errorf("could not read prefetch manifest: %w", err)
+		}
+		patterns = append(patterns, manifest...)
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := make(chan hsmFile)
+	wg := sync.WaitGroup{}
+	for range max(d.prefetchWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				d.adviseWillNeed(ctx, volumeID, file)
+			}
+		}()
+	}
+
+	err := filepath.WalkDir(target, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == target {
+			return nil
+		}
+		rel, err := filepath.Rel(target, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if matchesPrefetchPattern(patterns, rel) || mayMatchBelowPrefetchPattern(patterns, rel) {
+				return nil
+			}
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() || !matchesPrefetchPattern(patterns, rel) {
+			return nil
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		select {
+		case files <- hsmFile{path: path, size: info.Size()}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	prefetchCompleted.WithLabelValues(volumeID).Inc()
+	return nil
+}
+
+// adviseWillNeed has the OSTs read a file ahead, in one of the node's
+// prefetch slots.
+func (d *Driver) adviseWillNeed(ctx context.Context, volumeID string, file hsmFile) {
+	if file.size == 0 {
+		return
+	}
+
+	select {
+	case d.prefetchSlots <- struct{}{}:
+		defer func() { <-d.prefetchSlots }()
+	case <-ctx.Done():
+		return
+	}
+
+	_, err := d.runLfsContext(ctx, "ladvise", "-a", "willneed", "-b",
+		"-s", "0", "-e", strconv.FormatInt(file.size, 10), file.path)
+	if ctx.Err() != nil {
+		return
+	} else if err != nil {
+		prefetchErrors.WithLabelValues(volumeID).Inc()
+		klog.V(4).Infof("could not prefetch %q: %v", file.path, err)
+		return
+	}
+	prefetchFiles.WithLabelValues(volumeID).Inc()
+	prefetchBytes.WithLabelValues(volumeID).Add(float64(file.size))
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePrefetchPatterns(t *testing.T) {
+	tests := []struct {
+		desc     string
+		value    string
+		patterns []string
+	}{
+		{desc: "single pattern", value: "data/*.bin", patterns: []string{"data/*.bin"}},
+		{desc: "patterns are cleaned", value: " /data//train/ , models", patterns: []string{"data/train", "models"}},
+		{desc: "no pattern", value: " , "},
+		{desc: "escaping pattern", value: "../other"},
+		{desc: "root pattern", value: "/"},
+		{desc: "malformed glob", value: "data/[a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			patterns, err := parsePrefetchPatterns(test.value)
+			if test.patterns == nil {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.patterns, patterns)
+		})
+	}
+}
+
+func TestPrefetchPatternMatching(t *testing.T) {
+	patterns := []string{"data/*/train", "models/*.bin"}
+	tests := []struct {
+		desc    string
+		rel     string
+		matches bool
+		walks   bool
+	}{
+		{desc: "file below matching directory", rel: "data/a/train/x/y", matches: true, walks: true},
+		{desc: "matching directory", rel: "data/a/train", matches: true, walks: true},
+		{desc: "directory above pattern", rel: "data/a", walks: true},
+		{desc: "top directory of pattern", rel: "data", walks: true},
+		{desc: "sibling directory", rel: "data/a/test"},
+		{desc: "unrelated directory", rel: "logs"},
+		{desc: "matching file", rel: "models/m.bin", matches: true, walks: true},
+		{desc: "star does not match separator", rel: "models/a/m.bin"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.matches, matchesPrefetchPattern(patterns, test.rel))
+			assert.Equal(t, test.walks, matchesPrefetchPattern(patterns, test.rel) || mayMatchBelowPrefetchPattern(patterns, test.rel))
+		})
+	}
+}
+
+func TestReadPrefetchManifest(t *testing.T) {
+	target := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "manifest"), []byte("secret\n"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(target, "dir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(target, "dir", "manifest"), []byte("# comment\n\n/data/*.bin\nmodels/\n"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "manifest"), filepath.Join(target, "link")))
+	require.NoError(t, os.Symlink("manifest", filepath.Join(target, "dir", "inner-link")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(target, "outside")))
+	require.NoError(t, os.WriteFile(filepath.Join(target, "bad"), []byte("../escape\n"), 0644))
+
+	tests := []struct {
+		desc     string
+		manifest string
+		patterns []string
+	}{
+		{desc: "manifest", manifest: "dir/manifest", patterns: []string{"data/*.bin", "models"}},
+		{desc: "link out of the volume", manifest: "link"},
+		{desc: "link within the volume", manifest: "dir/inner-link"},
+		{desc: "linked directory", manifest: "outside/manifest"},
+		{desc: "directory", manifest: "dir"},
+		{desc: "missing", manifest: "missing"},
+		{desc: "escaping pattern", manifest: "bad"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			patterns, err := readPrefetchManifest(target, test.manifest)
+			if test.patterns == nil {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.patterns, patterns)
+		})
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// NodeUnpublishVolume only receives the volume ID and the target path, so any
+// per-volume setup done by NodePublishVolume that must be undone later is
+// recorded in a publishRecord. Records are kept in the driver's state
+// directory on the host, one file per target path, so that they survive a
+// restart of the node plugin. Callers hold publishStateLock while reading or
+// changing records.
+type publishRecord struct {
+	VolumeID   string `json:"volumeID"`
+	TargetPath string `json:"targetPath"`
+
+	// Path of the SSK key file passed to the mount with skpath=
+	SSKKeyPath string `json:"sskKeyPath,omitempty"`
+	// Identifier of the encryption key added to the target's client mount
+	EncryptionKeyID string `json:"encryptionKeyID,omitempty"`
+
+	// Sub-dir of the filesystem that is published at the target
+	SubDir     string `json:"subDir,omitempty"`
+	Filesystem string `json:"filesystem,omitempty"`
+	// What happens to the sub-dir when the last target that it is published
+	// at on this node is unpublished, along with the options of the mount
+	// that it is deleted or archived through
+	SubDirOnUnpublish string   `json:"subDirOnUnpublish,omitempty"`
+	SubDirArchive     string   `json:"subDirArchive,omitempty"`
+	MountOptions      []string `json:"mountOptions,omitempty"`
+
+	// Directory of a PCC backend that is attached to the target's client
+	// mount, which is removed once the target is unpublished
+	PCCPath string `json:"pccPath,omitempty"`
+}
+
+const publishRecordSuffix = ".json"
+
+func (d *Driver) publishRecordPath(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return filepath.Join(d.stateDir, hex.EncodeToString(sum[:])+publishRecordSuffix)
+}
+
+func (d *Driver) savePublishRecord(rec *publishRecord) error {
+	if err := os.MkdirAll(d.stateDir, 0o700); err != nil {
+		return fmt.Errorf("could not create state directory %q: %w", d.stateDir, err)
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	path := d.publishRecordPath(rec.TargetPath)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("could not write publish record %q: %w", tmp, err)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// loadPublishRecord returns the record for the target path, or nil if there is
+// none.
+func (d *Driver) loadPublishRecord(target string) (*publishRecord, error) {
+	data, err := os.ReadFile(d.publishRecordPath(target))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rec := &publishRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("could not parse publish record for %q: %w", target, err)
+	}
+
+	return rec, nil
+}
+
+func (d *Driver) removePublishRecord(target string) error {
+	err := os.Remove(d.publishRecordPath(target))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// releasePublishRecord removes the record for a target path that has been
+// unpublished, and undoes the setup that is no longer needed by any other
+// volume on this node.
+func (d *Driver) releasePublishRecord(target string) error {
+	// The lock is released while the files of a deleted sub-dir are
+	// removed, which may take long, so that other volumes can be published
+	// and unpublished meanwhile.
+	d.publishStateLock.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			d.publishStateLock.Unlock()
+		}
+	}()
+
+	rec, err := d.loadPublishRecord(target)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return nil
+	}
+
+	// The record is kept until the sub-dir is gone, so that a failed
+	// unpublish is retried.
+	if rec.SubDirOnUnpublish == SubDirOnUnpublishDelete || rec.SubDirOnUnpublish == SubDirOnUnpublishArchive {
+		inUse, err := d.subDirInUse(rec)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			klog.V(2).Infof("sub-dir %q of %s is still published on this node, not cleaning it up", rec.SubDir, rec.Filesystem)
+		} else {
+			finish, err := d.cleanUpSubDir(rec)
+			if err != nil {
+				return err
+			}
+			if finish != nil {
+				d.publishStateLock.Unlock()
+				locked = false
+				if err := finish(); err != nil {
+					return err
+				}
+				d.publishStateLock.Lock()
+				locked = true
+			}
+		}
+	}
+
+	if len(rec.PCCPath) != 0 {
+		if err := os.RemoveAll(rec.PCCPath); err != nil {
+			return fmt.Errorf("could not remove PCC directory %q: %w", rec.PCCPath, err)
+		}
+	}
+
+	if err := d.removePublishRecord(target); err != nil {
+		return err
+	}
+
+	if len(rec.SSKKeyPath) != 0 {
+		if err := d.releaseSSKKey(rec.SSKKeyPath); err != nil {
+			return fmt.Errorf("could not remove SSK key %q: %w", rec.SSKKeyPath, err)
+		}
+	}
+
+	return nil
+}
+
+// subDirInUse reports whether the record's sub-dir is published at any other
+// target on this node.
+func (d *Driver) subDirInUse(rec *publishRecord) (bool, error) {
+	records, err := d.listPublishRecords()
+	if err != nil {
+		return false, err
+	}
+
+	for _, other := range records {
+		if other.TargetPath != rec.TargetPath && other.Filesystem == rec.Filesystem &&
+			filepath.Clean(other.SubDir) == filepath.Clean(rec.SubDir) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// listPublishRecords returns the records of every target published on this node.
+func (d *Driver) listPublishRecords() ([]*publishRecord, error) {
+	entries, err := os.ReadDir(d.stateDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	records := []*publishRecord{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), publishRecordSuffix) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(d.stateDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		rec := &publishRecord{}
+		if err := json.Unmarshal(data, rec); err != nil {
+			return nil, fmt.Errorf("could not parse publish record %q: %w", e.Name(), err)
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishRecords(t *testing.T) {
+	d := NewDriver(&DriverOptions{StateDir: filepath.Join(t.TempDir(), "state")})
+	target := "/var/lib/kubelet/pods/1234/volumes/kubernetes.io~csi/pv/mount"
+
+	rec, err := d.loadPublishRecord(target)
+	require.NoError(t, err)
+	assert.Nil(t, rec)
+	records, err := d.listPublishRecords()
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	saved := &publishRecord{
+		VolumeID:          "10.1.1.113@tcp:/lushtx",
+		TargetPath:        target,
+		SubDir:            "scratch/1234",
+		Filesystem:        "10.1.1.113@tcp:/lushtx",
+		SubDirOnUnpublish: SubDirOnUnpublishDelete,
+		MountOptions:      []string{"flock"},
+	}
+	require.NoError(t, d.savePublishRecord(saved))
+
+	rec, err = d.loadPublishRecord(target)
+	require.NoError(t, err)
+	assert.Equal(t, saved, rec)
+	records, err = d.listPublishRecords()
+	require.NoError(t, err)
+	assert.Equal(t, []*publishRecord{saved}, records)
+
+	info, err := os.Stat(d.stateDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	// Saving the record again replaces it.
+	saved.PCCPath = "/mnt/pcc/1234"
+	require.NoError(t, d.savePublishRecord(saved))
+	rec, err = d.loadPublishRecord(target)
+	require.NoError(t, err)
+	assert.Equal(t, "/mnt/pcc/1234", rec.PCCPath)
+
+	require.NoError(t, d.removePublishRecord(target))
+	rec, err = d.loadPublishRecord(target)
+	require.NoError(t, err)
+	assert.Nil(t, rec)
+
+	// Removing a record that is already gone is not an error.
+	assert.NoError(t, d.removePublishRecord(target))
+}
+
+func TestCorruptPublishRecords(t *testing.T) {
+	d := NewDriver(&DriverOptions{StateDir: t.TempDir()})
+	target := "/var/lib/kubelet/pods/1234/volumes/kubernetes.io~csi/pv/mount"
+	require.NoError(t, d.savePublishRecord(&publishRecord{VolumeID: "10.1.1.113@tcp:/lushtx", TargetPath: target}))
+
+	// A record that was being written when the node plugin stopped is left
+	// as a temporary file, which is not a record.
+	other := "/var/lib/kubelet/pods/5678/volumes/kubernetes.io~csi/pv/mount"
+	require.NoError(t, os.WriteFile(d.publishRecordPath(other)+".tmp", []byte(`{"volumeID":"10.1.1.1`), 0o600))
+
+	rec, err := d.loadPublishRecord(other)
+	require.NoError(t, err)
+	assert.Nil(t, rec)
+	records, err := d.listPublishRecords()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, target, records[0].TargetPath)
+
+	// A record that cannot be parsed is an error rather than a volume
+	// without any setup to undo.
+	require.NoError(t, os.WriteFile(d.publishRecordPath(other), []byte(`{"volumeID":"10.1.1.1`), 0o600))
+
+	_, err = d.loadPublishRecord(other)
+	assert.ErrorContains(t, err, "could not parse publish record")
+	_, err = d.listPublishRecords()
+	assert.ErrorContains(t, err, "could not parse publish record")
+	assert.Error(t, d.releasePublishRecord(other))
+	assert.FileExists(t, d.publishRecordPath(other))
+}
@@ -0,0 +1,603 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+// A snapshot of a sub-dir volume is a read-only copy of the sub-dir in the
+// snapshots directory of the same filesystem. Each snapshot is a directory,
+// named after the snapshot, that holds the copy in its data directory along
+// with a snapshotInfo file. The snapshot ID is the sub-dir volume ID of the
+// snapshot's directory, so the snapshot can be found from its ID alone.
+//
+// The copy is made in the background. CreateSnapshot returns the snapshot as
+// not ready to use until the copy completes, and the CO calls it again until it
+// is. A copy that was interrupted by a restart of the controller is resumed.
+//
+// A snapshot is not a point-in-time copy. It is only ready to use once nothing
+// in the volume changed while it was copied; otherwise the copy is discarded
+// and made again the next time the CO asks for the snapshot. In attach mode,
+// snapshots of volumes that are published on a node are refused.
+//
+// snapshotLock only guards the driver's maps of snapshots. A snapshot that an
+// operation is working on is in snapshotOperations, and other operations on it
+// are aborted until it is done, so that the internal mounts and copies of
+// snapshots are worked on without holding the lock.
+
+const (
+	snapshotInfoFile = "snapshot.json"
+	snapshotDataDir  = "data"
+
+	// Internal mount of a filesystem for listing snapshots
+	snapshotMountPath = "snapshots"
+	// Parent of the internal mounts of filesystems that snapshots are being
+	// created or deleted on
+	snapshotOperationMountDir = "snapshot-operations"
+	// Parent of the internal mounts of filesystems that snapshots are being
+	// copied on
+	snapshotCopyMountDir = "snapshot-copies"
+
+	// Times of changes to a volume are compared with the start of its copy
+	// with this margin, for the granularity of the times and the difference
+	// between the clocks of the Lustre clients
+	snapshotChangeMargin = time.Second
+)
+
+type snapshotInfo struct {
+	SourceVolumeID string    `json:"sourceVolumeID"`
+	CreationTime   time.Time `json:"creationTime"`
+	SizeBytes      int64     `json:"sizeBytes"`
+	ReadyToUse     bool      `json:"readyToUse"`
+	// Time that the copy started, which is kept while it is resumed
+	CopyStartTime *time.Time `json:"copyStartTime,omitempty"`
+}
+
+// snapshotCopy is a copy that is being made for a snapshot.
+type snapshotCopy struct {
+	*backgroundCopy
+	info snapshotInfo
+}
+
+// CreateSnapshot creates a snapshot of a sub-dir volume
+func (d *Driver) CreateSnapshot(
+	ctx context.Context,
+	req *csi.CreateSnapshotRequest,
+) (*csi.CreateSnapshotResponse, error) {
+	if !d.enableSnapshots {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Snapshot name missing in request")
+	}
+	if strings.Contains(name, "/") || !ensureStrictSubpath(name) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Snapshot name %q must be a single path component", name)
+	}
+
+	sourceVolumeID := req.GetSourceVolumeId()
+	if len(sourceVolumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Source volume ID missing in request")
+	}
+
+	handle, subDir := splitVolumeID(sourceVolumeID)
+	if len(subDir) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Volume %q is not a sub-dir volume; only sub-dir volumes can be snapshotted", sourceVolumeID)
+	}
+	if !ensureStrictSubpath(subDir) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Sub-dir of volume %q must be strict subpath", sourceVolumeID)
+	}
+	if _, _, err := parseVolumeHandle(handle); err != nil {
+		return nil, err
+	}
+	if isSubpathOf(subDir, d.snapshotsDir) || isSubpathOf(d.snapshotsDir, subDir) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Volume %q overlaps the snapshots directory %q", sourceVolumeID, d.snapshotsDir)
+	}
+
+	snapshotDir := filepath.Join(d.snapshotsDir, name)
+	snapshotID := makeSubDirVolumeID(handle, snapshotDir)
+
+	if err := d.beginSnapshotOperation(snapshotID); err != nil {
+		return nil, err
+	}
+	defer d.endSnapshotOperation(snapshotID)
+
+	if c := d.getSnapshotCopy(snapshotID); c != nil {
+		if !c.finished() {
+			if c.info.SourceVolumeID != sourceVolumeID {
+				return nil, status.Errorf(codes.AlreadyExists,
+					"Snapshot %q already exists for volume %q", name, c.info.SourceVolumeID)
+			}
+			return newCreateSnapshotResponse(snapshotID, &c.info), nil
+		}
+		d.removeSnapshotCopy(snapshotID)
+		if c.err != nil {
+			return nil, c.err
+		}
+	}
+
+	root, unmount, err := d.mountFilesystem(handle, filepath.Join(snapshotOperationMountDir, snapshotMountName(snapshotID)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	info, err := readSnapshotInfo(root, snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if info != nil {
+		if info.SourceVolumeID != sourceVolumeID {
+			return nil, status.Errorf(codes.AlreadyExists,
+				"Snapshot %q already exists for volume %q", name, info.SourceVolumeID)
+		}
+		if info.ReadyToUse {
+			return newCreateSnapshotResponse(snapshotID, info), nil
+		}
+		if err := d.checkSnapshotSourceUnpublished(ctx, sourceVolumeID); err != nil {
+			return nil, err
+		}
+		klog.Infof("CreateSnapshot: resuming copy of %s for snapshot %s", sourceVolumeID, snapshotID)
+	} else {
+		if _, exists, err := checkSubDir(root, subDir); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, status.Errorf(codes.NotFound, "Volume %q not found", sourceVolumeID)
+		}
+		if err := d.checkSnapshotSourceUnpublished(ctx, sourceVolumeID); err != nil {
+			return nil, err
+		}
+
+		info = &snapshotInfo{
+			SourceVolumeID: sourceVolumeID,
+			CreationTime:   time.Now().UTC(),
+		}
+		if err := createSnapshotDir(root, snapshotDir, info); err != nil {
+			return nil, err
+		}
+	}
+
+	d.startSnapshotCopy(snapshotID, subDir, *info)
+
+	return newCreateSnapshotResponse(snapshotID, info), nil
+}
+
+// beginSnapshotOperation marks an operation on a snapshot as in progress. It
+// fails if another operation on the snapshot is in progress.
+func (d *Driver) beginSnapshotOperation(snapshotID string) error {
+	d.snapshotLock.Lock()
+	defer d.snapshotLock.Unlock()
+
+	if _, ok := d.snapshotOperations[snapshotID]; ok {
+		return status.Errorf(codes.Aborted, "An operation on snapshot %s is already in progress", snapshotID)
+	}
+	d.snapshotOperations[snapshotID] = struct{}{}
+	return nil
+}
+
+func (d *Driver) endSnapshotOperation(snapshotID string) {
+	d.snapshotLock.Lock()
+	defer d.snapshotLock.Unlock()
+
+	delete(d.snapshotOperations, snapshotID)
+}
+
+func (d *Driver) getSnapshotCopy(snapshotID string) *snapshotCopy {
+	d.snapshotLock.Lock()
+	defer d.snapshotLock.Unlock()
+
+	return d.snapshotCopies[snapshotID]
+}
+
+func (d *Driver) removeSnapshotCopy(snapshotID string) {
+	d.snapshotLock.Lock()
+	defer d.snapshotLock.Unlock()
+
+	delete(d.snapshotCopies, snapshotID)
+}
+
+// snapshotMountName returns the name of the internal mounts for a snapshot,
+// which differs between snapshots of the same name on different filesystems.
+func snapshotMountName(snapshotID string) string {
+	sum := sha256.Sum256([]byte(snapshotID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkSnapshotSourceUnpublished refuses to copy a volume that is published
+// on a node, which in attach mode the controller knows of.
+func (d *Driver) checkSnapshotSourceUnpublished(ctx context.Context, sourceVolumeID string) error {
+	publishedNodes, err := d.getPublishedNodes(ctx)
+	if err != nil {
+		return err
+	}
+	if nodes := publishedNodes[sourceVolumeID]; len(nodes) != 0 {
+		return status.Errorf(codes.FailedPrecondition,
+			"Volume %q is published on %s; only volumes that are not in use can be snapshotted",
+			sourceVolumeID, strings.Join(nodes, ", "))
+	}
+	return nil
+}
+
+func newCreateSnapshotResponse(snapshotID string, info *snapshotInfo) *csi.CreateSnapshotResponse {
+	return &csi.CreateSnapshotResponse{
+		Snapshot: newSnapshot(snapshotID, info),
+	}
+}
+
+func newSnapshot(snapshotID string, info *snapshotInfo) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     snapshotID,
+		SourceVolumeId: info.SourceVolumeID,
+		CreationTime:   timestamppb.New(info.CreationTime),
+		SizeBytes:      info.SizeBytes,
+		ReadyToUse:     info.ReadyToUse,
+	}
+}
+
+// createSnapshotDir creates the directory of a snapshot, along with the
+// snapshots directory, which only root can use.
+func createSnapshotDir(root, snapshotDir string, info *snapshotInfo) error {
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(snapshotDir)), 0o700); err != nil {
+		return status.Errorf(codes.Internal, "failed to make snapshots directory: %v", err)
+	}
+
+	path, _, err := checkSubDir(root, snapshotDir)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, 0o700); err != nil {
+		return status.Errorf(codes.Internal, "failed to make snapshot directory %q: %v", path, err)
+	}
+
+	return writeSnapshotInfo(path, info)
+}
+
+// readSnapshotInfo returns the information about the snapshot in a directory
+// below the root of a mounted filesystem, or nil if there is no such
+// directory.
+func readSnapshotInfo(root, snapshotDir string) (*snapshotInfo, error) {
+	path, exists, err := checkSubDir(root, snapshotDir)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(path, snapshotInfoFile))
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%q exists but is not a snapshot", snapshotDir)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read snapshot %q: %v", snapshotDir, err)
+	}
+
+	info := &snapshotInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to parse snapshot %q: %v", snapshotDir, err)
+	}
+
+	return info, nil
+}
+
+func writeSnapshotInfo(path string, info *snapshotInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode snapshot: %v", err)
+	}
+
+	file := filepath.Join(path, snapshotInfoFile)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return status.Errorf(codes.Internal, "failed to write %q: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return status.Errorf(codes.Internal, "failed to write %q: %v", file, err)
+	}
+
+	return nil
+}
+
+// startSnapshotCopy copies a volume into a snapshot in the background.
+func (d *Driver) startSnapshotCopy(snapshotID, subDir string, info snapshotInfo) {
+	d.snapshotLock.Lock()
+	defer d.snapshotLock.Unlock()
+
+	description := fmt.Sprintf("%s for snapshot %s", info.SourceVolumeID, snapshotID)
+	d.snapshotCopies[snapshotID] = &snapshotCopy{
+		backgroundCopy: startBackgroundCopy(description, func(ctx context.Context) error {
+			return d.copySnapshot(ctx, snapshotID, subDir, info)
+		}),
+		info: info,
+	}
+}
+
+func (d *Driver) copySnapshot(ctx context.Context, snapshotID, subDir string, info snapshotInfo) error {
+	handle, snapshotDir := splitVolumeID(snapshotID)
+
+	root, unmount, err := d.mountFilesystem(handle, filepath.Join(snapshotCopyMountDir, snapshotMountName(snapshotID)), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	src, exists, err := checkSubDir(root, subDir)
+	if err != nil {
+		return err
+	} else if !exists {
+		return status.Errorf(codes.NotFound, "Volume %q not found", info.SourceVolumeID)
+	}
+
+	path, exists, err := checkSubDir(root, snapshotDir)
+	if err != nil {
+		return err
+	} else if !exists {
+		return status.Errorf(codes.Aborted, "snapshot %q was deleted", snapshotID)
+	}
+
+	if info.CopyStartTime == nil {
+		info.CopyStartTime = ptr.To(time.Now().UTC())
+		if err := writeSnapshotInfo(path, &info); err != nil {
+			return err
+		}
+	}
+
+	dataPath := filepath.Join(path, snapshotDataDir)
+	size, err := volumehelper.CopyTree(ctx, src, dataPath, volumehelper.CopyTreeOptions{
+		Workers:  d.copyWorkers,
+		ReadOnly: true,
+		Resume:   true,
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to copy volume %q: %v", info.SourceVolumeID, err)
+	}
+
+	// A volume that changed since its copy started, including while a copy
+	// that was interrupted waited to be resumed, has to be copied again from
+	// the start.
+	changed, err := volumehelper.ChangedSince(ctx, src, info.CopyStartTime.Add(-snapshotChangeMargin))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check volume %q for changes: %v", info.SourceVolumeID, err)
+	}
+	if len(changed) != 0 {
+		if err := volumehelper.RemoveTree(ctx, dataPath, d.copyWorkers, nil); err != nil {
+			return status.Errorf(codes.Internal, "failed to discard copy of volume %q: %v", info.SourceVolumeID, err)
+		}
+		info.CopyStartTime = nil
+		if err := writeSnapshotInfo(path, &info); err != nil {
+			return err
+		}
+		return status.Errorf(codes.Unavailable,
+			"volume %q changed while it was copied, at %q; the snapshot is copied again once the volume is not in use",
+			info.SourceVolumeID, changed)
+	}
+
+	info.SizeBytes = size
+	info.ReadyToUse = true
+	return writeSnapshotInfo(path, &info)
+}
+
+// DeleteSnapshot deletes a snapshot, stopping its copy if it is still being
+// made
+func (d *Driver) DeleteSnapshot(
+	_ context.Context,
+	req *csi.DeleteSnapshotRequest,
+) (*csi.DeleteSnapshotResponse, error) {
+	if !d.enableSnapshots {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Snapshot ID missing in request")
+	}
+
+	handle, snapshotDir, ok := d.parseSnapshotID(snapshotID)
+	if !ok {
+		klog.Warningf("DeleteSnapshot: %q is not a snapshot ID of this driver, assuming it is deleted", snapshotID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	if err := d.beginSnapshotOperation(snapshotID); err != nil {
+		return nil, err
+	}
+	defer d.endSnapshotOperation(snapshotID)
+
+	if c := d.getSnapshotCopy(snapshotID); c != nil {
+		c.stop()
+		d.removeSnapshotCopy(snapshotID)
+	}
+
+	root, unmount, err := d.mountFilesystem(handle, filepath.Join(snapshotOperationMountDir, snapshotMountName(snapshotID)), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	// A directory is only deleted if it is a snapshot.
+	info, err := readSnapshotInfo(root, snapshotDir)
+	if err != nil {
+		return nil, err
+	} else if info == nil {
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	klog.V(2).Infof("DeleteSnapshot: deleting snapshot %s of %s", snapshotID, info.SourceVolumeID)
+	if err := removeSubDir(root, snapshotDir); err != nil {
+		return nil, err
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// parseSnapshotID returns the volume handle and the directory of a snapshot,
+// and whether the ID is one of a snapshot.
+func (d *Driver) parseSnapshotID(snapshotID string) (string, string, bool) {
+	handle, snapshotDir := splitVolumeID(snapshotID)
+	if len(snapshotDir) == 0 || !ensureStrictSubpath(snapshotDir) {
+		return "", "", false
+	}
+	if _, _, err := parseVolumeHandle(handle); err != nil {
+		return "", "", false
+	}
+
+	return handle, snapshotDir, true
+}
+
+// ListSnapshots returns a snapshot given by its ID, or the snapshots of a
+// volume in the snapshots directory of its filesystem. Snapshots can only be
+// found through the filesystem that they are on, so without either filter no
+// snapshots are returned.
+func (d *Driver) ListSnapshots(
+	_ context.Context,
+	req *csi.ListSnapshotsRequest,
+) (*csi.ListSnapshotsResponse, error) {
+	if !d.enableSnapshots {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	start := 0
+	if token := req.GetStartingToken(); len(token) != 0 {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 {
+			return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", token)
+		}
+	}
+
+	snapshots, err := d.findSnapshots(req.GetSnapshotId(), req.GetSourceVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	if start > len(snapshots) {
+		return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", req.GetStartingToken())
+	}
+	end := len(snapshots)
+	if maxEntries := int(req.GetMaxEntries()); maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	resp := &csi.ListSnapshotsResponse{}
+	for _, snapshot := range snapshots[start:end] {
+		resp.Entries = append(resp.Entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+	if end < len(snapshots) {
+		resp.NextToken = strconv.Itoa(end)
+	}
+
+	return resp, nil
+}
+
+// findSnapshots returns the snapshots that match the filters of ListSnapshots,
+// sorted by their IDs.
+func (d *Driver) findSnapshots(snapshotID, sourceVolumeID string) ([]*csi.Snapshot, error) {
+	handle := ""
+	snapshotDirs := []string{}
+
+	switch {
+	case len(snapshotID) != 0:
+		var snapshotDir string
+		var ok bool
+		handle, snapshotDir, ok = d.parseSnapshotID(snapshotID)
+		if !ok {
+			return nil, nil
+		}
+		snapshotDirs = append(snapshotDirs, snapshotDir)
+	case len(sourceVolumeID) != 0:
+		handle, _ = splitVolumeID(sourceVolumeID)
+		if _, _, err := parseVolumeHandle(handle); err != nil {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	// Snapshots are listed through a single internal mount, without holding
+	// snapshotLock.
+	d.snapshotListLock.Lock()
+	defer d.snapshotListLock.Unlock()
+
+	root, unmount, err := d.mountFilesystem(handle, snapshotMountPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	if len(snapshotDirs) == 0 {
+		path, exists, err := checkSubDir(root, d.snapshotsDir)
+		if err != nil || !exists {
+			return nil, err
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list snapshots: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				snapshotDirs = append(snapshotDirs, filepath.Join(d.snapshotsDir, entry.Name()))
+			}
+		}
+	}
+
+	snapshots := []*csi.Snapshot{}
+	for _, snapshotDir := range snapshotDirs {
+		info, err := readSnapshotInfo(root, snapshotDir)
+		if status.Code(err) == codes.FailedPrecondition {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if info == nil || (len(sourceVolumeID) != 0 && info.SourceVolumeID != sourceVolumeID) {
+			continue
+		}
+		snapshots = append(snapshots, newSnapshot(makeSubDirVolumeID(handle, snapshotDir), info))
+	}
+
+	slices.SortFunc(snapshots, func(a, b *csi.Snapshot) int {
+		return strings.Compare(a.SnapshotId, b.SnapshotId)
+	})
+
+	return snapshots, nil
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestSnapshotOperations(t *testing.T) {
+	d := NewDriver(&DriverOptions{EnableSnapshots: true})
+	snapshotID := "10.1.1.113@tcp:/lushtx#.snapshots/a"
+
+	assert.NoError(t, d.beginSnapshotOperation(snapshotID))
+	assert.Equal(t, codes.Aborted, status.Code(d.beginSnapshotOperation(snapshotID)))
+	assert.NoError(t, d.beginSnapshotOperation("10.1.1.114@tcp:/lushtx#.snapshots/a"))
+
+	d.endSnapshotOperation(snapshotID)
+	assert.NoError(t, d.beginSnapshotOperation(snapshotID))
+
+	assert.NotEqual(t, snapshotMountName(snapshotID), snapshotMountName("10.1.1.114@tcp:/lushtx#.snapshots/a"))
+}
+
+func TestCheckSnapshotSourceUnpublished(t *testing.T) {
+	published := "10.1.1.113@tcp:/lushtx#csi/a"
+	unpublished := "10.1.1.113@tcp:/lushtx#csi/b"
+
+	d := NewDriver(&DriverOptions{DriverName: "lustre-csi.hpe.com", EnableAttach: true, EnableSnapshots: true})
+	d.kubeClient = fake.NewSimpleClientset(
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-a"},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "lustre-csi.hpe.com", VolumeHandle: published},
+			}},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-b"},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: "lustre-csi.hpe.com", VolumeHandle: unpublished},
+			}},
+		},
+		&storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: "attachment-a"},
+			Spec: storagev1.VolumeAttachmentSpec{
+				Attacher: "lustre-csi.hpe.com",
+				NodeName: "node1",
+				Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: ptr.To("pv-a")},
+			},
+			Status: storagev1.VolumeAttachmentStatus{Attached: true},
+		},
+	)
+
+	tests := []struct {
+		desc         string
+		enableAttach bool
+		volumeID     string
+		code         codes.Code
+	}{
+		{
+			desc:         "published",
+			enableAttach: true,
+			volumeID:     published,
+			code:         codes.FailedPrecondition,
+		},
+		{
+			desc:         "unpublished",
+			enableAttach: true,
+			volumeID:     unpublished,
+		},
+		{
+			desc:     "published without attach mode",
+			volumeID: published,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			d.enableAttach = test.enableAttach
+			err := d.checkSnapshotSourceUnpublished(context.Background(), test.volumeID)
+			assert.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
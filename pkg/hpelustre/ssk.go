@@ -0,0 +1,105 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// SecretSSKKey is the key in the node publish secret that holds a Lustre
+// shared-secret key, as written by "lgss_sk -w".
+const SecretSSKKey = "ssk"
+
+// installSSKKey writes the shared-secret key into the keyring directory and
+// returns the path of the key file, suitable for the skpath= mount option.
+// Key files are named after a digest of their contents, so volumes that use the
+// same key share a single file.
+func (d *Driver) installSSKKey(key []byte) (string, error) {
+	if err := os.MkdirAll(d.sskKeyDir, 0o700); err != nil {
+		return "", status.Errorf(codes.Internal, "could not create SSK key directory %q: %v", d.sskKeyDir, err)
+	}
+	if err := os.Chmod(d.sskKeyDir, 0o700); err != nil {
+		return "", status.Errorf(codes.Internal, "could not restrict SSK key directory %q: %v", d.sskKeyDir, err)
+	}
+
+	// Never let a key reach persistent storage.
+	var fs unix.Statfs_t
+	if err := unix.Statfs(d.sskKeyDir, &fs); err != nil {
+		return "", status.Errorf(codes.Internal, "could not stat SSK key directory %q: %v", d.sskKeyDir, err)
+	}
+	if fs.Type != unix.TMPFS_MAGIC {
+		return "", status.Errorf(codes.FailedPrecondition, "SSK key directory %q is not on tmpfs", d.sskKeyDir)
+	}
+
+	sum := sha256.Sum256(key)
+	path := filepath.Join(d.sskKeyDir, hex.EncodeToString(sum[:])+".key")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	tmp, err := os.CreateTemp(d.sskKeyDir, ".key-")
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "could not create SSK key file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(key); err != nil {
+		tmp.Close()
+		return "", status.Errorf(codes.Internal, "could not write SSK key file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", status.Errorf(codes.Internal, "could not write SSK key file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", status.Errorf(codes.Internal, "could not install SSK key file: %v", err)
+	}
+
+	klog.V(2).Infof("installed SSK key %q", path)
+	return path, nil
+}
+
+// releaseSSKKey removes the key file unless a published volume still uses it.
+func (d *Driver) releaseSSKKey(path string) error {
+	records, err := d.listPublishRecords()
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		if rec.SSKKeyPath == path {
+			return nil
+		}
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	klog.V(2).Infof("removed SSK key %q", path)
+	return nil
+}
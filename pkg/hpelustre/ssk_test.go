@@ -0,0 +1,124 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tmpfsDir returns a directory on tmpfs, which is removed when the test ends.
+func tmpfsDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("/dev/shm", "ssk-")
+	if err != nil {
+		t.Skipf("no tmpfs for SSK keys: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	var fs unix.Statfs_t
+	require.NoError(t, unix.Statfs(dir, &fs))
+	if fs.Type != unix.TMPFS_MAGIC {
+		t.Skip("/dev/shm is not on tmpfs")
+	}
+	return dir
+}
+
+func TestInstallSSKKey(t *testing.T) {
+	d := NewDriver(&DriverOptions{SSKKeyDir: filepath.Join(tmpfsDir(t), "keys")})
+	key := []byte("shared-secret key")
+
+	path, err := d.installSSKKey(key)
+	require.NoError(t, err)
+	assert.Equal(t, d.sskKeyDir, filepath.Dir(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, data)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	info, err = os.Stat(d.sskKeyDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	// Volumes with the same key share its file, and no temporary files are
+	// left behind.
+	samePath, err := d.installSSKKey(key)
+	require.NoError(t, err)
+	assert.Equal(t, path, samePath)
+	otherPath, err := d.installSSKKey([]byte("other key"))
+	require.NoError(t, err)
+	assert.NotEqual(t, path, otherPath)
+
+	entries, err := os.ReadDir(d.sskKeyDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestInstallSSKKeyNotOnTmpfs(t *testing.T) {
+	dir := t.TempDir()
+	var fs unix.Statfs_t
+	require.NoError(t, unix.Statfs(dir, &fs))
+	if fs.Type == unix.TMPFS_MAGIC {
+		t.Skip("temporary directory is on tmpfs")
+	}
+
+	d := NewDriver(&DriverOptions{SSKKeyDir: dir})
+	_, err := d.installSSKKey([]byte("shared-secret key"))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "%v", err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestReleaseSSKKey(t *testing.T) {
+	d := NewDriver(&DriverOptions{
+		SSKKeyDir: tmpfsDir(t),
+		StateDir:  t.TempDir(),
+	})
+
+	path, err := d.installSSKKey([]byte("shared-secret key"))
+	require.NoError(t, err)
+	require.NoError(t, d.savePublishRecord(&publishRecord{
+		VolumeID:   "10.1.1.113@tcp:/lushtx",
+		TargetPath: "/var/lib/kubelet/pods/1234/volumes/kubernetes.io~csi/pv/mount",
+		SSKKeyPath: path,
+	}))
+
+	// The key is kept while a published volume uses it.
+	require.NoError(t, d.releaseSSKKey(path))
+	assert.FileExists(t, path)
+
+	require.NoError(t, d.removePublishRecord("/var/lib/kubelet/pods/1234/volumes/kubernetes.io~csi/pv/mount"))
+	require.NoError(t, d.releaseSSKKey(path))
+	assert.NoFileExists(t, path)
+
+	// Releasing a key that is already gone is not an error.
+	assert.NoError(t, d.releaseSSKKey(path))
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"strings"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Sub-dir template variables that are taken from the volume context that
+// kubelet passes to NodePublishVolume.
+var subDirContextVariables = map[string]string{
+	podNameKey:            podNameMetadata,
+	podNamespaceKey:       podNamespaceMetadata,
+	podUIDKey:             podUIDMetadata,
+	serviceAccountNameKey: serviceAccountNameMetadata,
+	pvcNameKey:            pvcNameMetadata,
+	pvcNamespaceKey:       pvcNamespaceMetadata,
+	pvNameKey:             pvNameMetadata,
+}
+
+// parseSubDirTemplate parses the sub-dir of a volume, checking that it only
+// refers to variables that the driver knows about.
+func parseSubDirTemplate(subDir string) (*volumehelper.Template, error) {
+	tmpl, err := volumehelper.ParseTemplate(subDir)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Context sub-dir is invalid: %v", err)
+	}
+
+	for _, name := range tmpl.Variables() {
+		if !isSubDirVariable(name) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Context sub-dir refers to unknown variable ${%s}", name)
+		}
+	}
+
+	return tmpl, nil
+}
+
+func isSubDirVariable(name string) bool {
+	if name == nodeNameMetadata {
+		return true
+	}
+	for _, v := range subDirContextVariables {
+		if name == v {
+			return true
+		}
+	}
+	for _, prefix := range []string{podLabelsPrefix, nodeLabelsPrefix, driverVariablePrefix} {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// interpolateSubDir expands the variables in the sub-dir of a volume. The
+// labels of the pod and the node are only looked up if the sub-dir refers to
+// them.
+func (d *Driver) interpolateSubDir(ctx context.Context, volumeContext map[string]string, vol *lustreVolume) (string, error) {
+	tmpl, err := parseSubDirTemplate(vol.subDir)
+	if err != nil {
+		return "", err
+	}
+
+	vars := map[string]string{
+		nodeNameMetadata: d.NodeID,
+	}
+	for k, v := range volumeContext {
+		if name, ok := subDirContextVariables[strings.ToLower(k)]; ok {
+			vars[name] = v
+		}
+	}
+	for k, v := range d.subDirVariables {
+		vars[driverVariablePrefix+k] = v
+	}
+
+	podLabels, nodeLabels := false, false
+	for _, name := range tmpl.Variables() {
+		podLabels = podLabels || strings.HasPrefix(name, podLabelsPrefix)
+		nodeLabels = nodeLabels || strings.HasPrefix(name, nodeLabelsPrefix)
+	}
+	if podLabels {
+		if err := d.addPodLabels(ctx, volumeContext, vars); err != nil {
+			return "", err
+		}
+	}
+	if nodeLabels {
+		if err := d.addNodeLabels(ctx, vars); err != nil {
+			return "", err
+		}
+	}
+
+	subDir, err := tmpl.Expand(vars)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Context sub-dir could not be expanded: %v", err)
+	}
+
+	return subDir, nil
+}
+
+func (d *Driver) addPodLabels(ctx context.Context, volumeContext map[string]string, vars map[string]string) error {
+	name, namespace := volumeContext[podNameKey], volumeContext[podNamespaceKey]
+	if len(name) == 0 || len(namespace) == 0 {
+		return status.Error(codes.InvalidArgument,
+			"Context sub-dir refers to pod labels, but the volume context has no pod")
+	}
+
+	client, err := d.getKubeClient()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not look up labels of pod %s/%s: %v", namespace, name, err)
+	}
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "Could not look up labels of pod %s/%s: %v", namespace, name, err)
+	}
+
+	for k, v := range pod.Labels {
+		vars[podLabelsPrefix+k] = v
+	}
+	return nil
+}
+
+func (d *Driver) addNodeLabels(ctx context.Context, vars map[string]string) error {
+	client, err := d.getKubeClient()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not look up labels of node %s: %v", d.NodeID, err)
+	}
+	node, err := client.CoreV1().Nodes().Get(ctx, d.NodeID, metav1.GetOptions{})
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "Could not look up labels of node %s: %v", d.NodeID, err)
+	}
+
+	for k, v := range node.Labels {
+		vars[nodeLabelsPrefix+k] = v
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// DeleteVolume moves a volume into the trash directory of its filesystem, and
+// the trash collector removes it in the background once it has been there for
+// the trash TTL. A volume is only ever moved into the trash from a parent
+// directory that has the managed marker, which CreateVolume creates.
+
+const (
+	// File in a parent directory whose volumes were created by the driver
+	managedParentMarker = ".lustre-csi-volumes"
+
+	// Time that a volume was moved into the trash, appended to its name
+	trashTimeFormat = "20060102T150405.000000000Z"
+
+	// Internal mount of a filesystem whose trash is being collected
+	trashMountPath = "trash"
+)
+
+var (
+	trashVolumes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lustre_csi_trash_volumes",
+		Help: "Number of deleted volumes in the trash directory of a filesystem, as of its last collection.",
+	}, []string{"filesystem"})
+	trashRemovedVolumes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_trash_removed_volumes_total",
+		Help: "Number of deleted volumes that have been removed from the trash directory of a filesystem.",
+	}, []string{"filesystem"})
+	trashRemovedEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_trash_removed_entries_total",
+		Help: "Number of files and directories that have been removed from the trash directory of a filesystem.",
+	}, []string{"filesystem"})
+	trashRemoveErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_trash_remove_errors_total",
+		Help: "Number of deleted volumes that could not be removed from the trash directory of a filesystem.",
+	}, []string{"filesystem"})
+	trashArchivedVolumes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_trash_archived_volumes_total",
+		Help: "Number of deleted volumes that have been archived and moved into the HSM archive directory of a filesystem.",
+	}, []string{"filesystem"})
+	trashArchiveErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lustre_csi_trash_archive_errors_total",
+		Help: "Number of times that the files of a deleted volume could not be archived or released.",
+	}, []string{"filesystem"})
+)
+
+func init() {
+	prometheus.MustRegister(trashVolumes, trashRemovedVolumes, trashRemovedEntries, trashRemoveErrors,
+		trashArchivedVolumes, trashArchiveErrors)
+}
+
+// checkManagedVolumeDir checks that a sub-dir is one that the driver may create
+// or delete a volume in: one in a parent directory that does not overlap the
+// directories that the driver uses itself.
+func (d *Driver) checkManagedVolumeDir(volumeDir string) error {
+	parent := filepath.Dir(volumeDir)
+	if !ensureStrictSubpath(volumeDir) || !ensureStrictSubpath(parent) {
+		return status.Errorf(codes.InvalidArgument,
+			"Volume directory %q must be strict subpath of a parent directory", volumeDir)
+	}
+	for _, dir := range []string{d.snapshotsDir, d.trashDir, d.hsmArchiveDir} {
+		if len(dir) != 0 && (isSubpathOf(parent, dir) || isSubpathOf(dir, parent)) {
+			return status.Errorf(codes.InvalidArgument,
+				"Parent directory %q overlaps %q", parent, dir)
+		}
+	}
+	return nil
+}
+
+// isManagedParent reports whether a parent directory of volumes has the
+// managed marker.
+func isManagedParent(root, parent string) (bool, error) {
+	path, exists, err := checkSubDir(root, parent)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	info, err := os.Lstat(filepath.Join(path, managedParentMarker))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, status.Errorf(codes.Internal, "failed to stat marker in %q: %v", path, err)
+	}
+	return info.Mode().IsRegular(), nil
+}
+
+// makeManagedParent creates the parent directory of a volume, if it does not
+// exist, and its managed marker.
+func makeManagedParent(root, volumeDir string) error {
+	parent := filepath.Dir(volumeDir)
+	if err := os.MkdirAll(filepath.Join(root, parent), 0o755); err != nil {
+		return status.Errorf(codes.Internal, "failed to make parent directory %q: %v", parent, err)
+	}
+	path, _, err := checkSubDir(root, parent)
+	if err != nil {
+		return err
+	}
+
+	marker := filepath.Join(path, managedParentMarker)
+	f, err := os.OpenFile(marker, os.O_CREATE|os.O_WRONLY|unix.O_NOFOLLOW, 0o644)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create %q: %v", marker, err)
+	}
+	return f.Close()
+}
+
+// moveToTrash renames a volume directory into the trash directory of its
+// filesystem.
+func (d *Driver) moveToTrash(root, volumeDir string) error {
+	path, exists, err := checkSubDir(root, volumeDir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "refusing to delete volume: %v", status.Convert(err).Message())
+	}
+	if !exists {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, d.trashDir), 0o700); err != nil {
+		return status.Errorf(codes.Internal, "failed to make trash directory: %v", err)
+	}
+	trashPath, _, err := checkSubDir(root, d.trashDir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "refusing to delete volume: %v", status.Convert(err).Message())
+	}
+
+	target := filepath.Join(trashPath, filepath.Base(volumeDir)+"."+time.Now().UTC().Format(trashTimeFormat))
+	if err := os.Rename(path, target); err != nil {
+		return status.Errorf(codes.Internal, "failed to move volume %q to %q: %v", path, target, err)
+	}
+
+	return nil
+}
+
+// parseTrashTime returns the time that an entry of the trash directory was
+// moved there, and whether its name is one that moveToTrash gives.
+func parseTrashTime(name string) (time.Time, bool) {
+	i := len(name) - len(trashTimeFormat)
+	if i < 2 || name[i-1] != '.' {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(trashTimeFormat, name[i:])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// getTrashFilesystems returns the filesystems whose trash is collected, those
+// with managed parent directories.
+func (d *Driver) getTrashFilesystems(ctx context.Context) []string {
+	handles := []string{}
+	for _, parent := range d.getManagedParents(ctx) {
+		if !slices.Contains(handles, parent.filesystem) {
+			handles = append(handles, parent.filesystem)
+		}
+	}
+	return handles
+}
+
+// runTrashCollector collects the trash of every filesystem once per interval.
+func (d *Driver) runTrashCollector(ctx context.Context) {
+	klog.Infof("collecting trash every %v, after %v", d.trashCollectInterval, d.trashTTL)
+
+	ticker := time.NewTicker(d.trashCollectInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, handle := range d.getTrashFilesystems(ctx) {
+			if err := d.collectTrash(ctx, handle); err != nil {
+				klog.Errorf("failed to collect trash of %s: %v", handle, err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collectTrash removes the volumes in the trash directory of a filesystem that
+// have been there for the trash TTL, and archives the volumes that are to be
+// archived rather than removed. Only the entries of the trash directory that
+// moveToTrash created are removed or archived.
+func (d *Driver) collectTrash(ctx context.Context, handle string) error {
+	root, unmount, err := d.mountFilesystem(handle, trashMountPath, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	trashPath, exists, err := checkSubDir(root, d.trashDir)
+	if err != nil {
+		return status.Errorf(codes.Internal, "refusing to collect trash: %v", status.Convert(err).Message())
+	}
+	if !exists {
+		trashVolumes.WithLabelValues(handle).Set(0)
+		return nil
+	}
+
+	entries, err := os.ReadDir(trashPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read %q: %v", trashPath, err)
+	}
+
+	remaining := 0
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		trashed, ok := parseTrashTime(entry.Name())
+		if !ok || !entry.IsDir() {
+			klog.Warningf("ignoring %q in trash of %s, which is not a deleted volume", entry.Name(), handle)
+			continue
+		}
+		remaining++
+		path := filepath.Join(trashPath, entry.Name())
+
+		if archiveID, ok := getHsmArchiveID(path); ok {
+			archived, err := d.archiveTrashVolume(ctx, root, path, archiveID)
+			if err != nil {
+				trashArchiveErrors.WithLabelValues(handle).Inc()
+				klog.Errorf("failed to archive %s in trash of %s: %v", entry.Name(), handle, err)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+			} else if archived {
+				remaining--
+				trashArchivedVolumes.WithLabelValues(handle).Inc()
+				klog.Infof("archived %s from trash of %s into %s", entry.Name(), handle, d.hsmArchiveDir)
+			}
+			continue
+		}
+
+		if time.Since(trashed) < d.trashTTL {
+			continue
+		}
+
+		klog.V(2).Infof("removing %s from trash of %s", entry.Name(), handle)
+		start := time.Now()
+		removed := trashRemovedEntries.WithLabelValues(handle)
+		if err := volumehelper.RemoveTree(ctx, path, d.trashWorkers, removed.Inc); err != nil {
+			trashRemoveErrors.WithLabelValues(handle).Inc()
+			klog.Errorf("failed to remove %s from trash of %s: %v", entry.Name(), handle, err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		remaining--
+		trashRemovedVolumes.WithLabelValues(handle).Inc()
+		klog.Infof("removed %s from trash of %s in %v", entry.Name(), handle, time.Since(start).Round(time.Second))
+	}
+	trashVolumes.WithLabelValues(handle).Set(float64(remaining))
+
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseTrashTime(t *testing.T) {
+	trashed := time.Date(2026, 10, 19, 13, 24, 37, 123456789, time.UTC)
+
+	parsed, ok := parseTrashTime("pvc-1234." + trashed.Format(trashTimeFormat))
+	require.True(t, ok)
+	assert.True(t, trashed.Equal(parsed))
+
+	for _, name := range []string{
+		"pvc-1234",
+		"pvc-1234" + trashed.Format(trashTimeFormat),
+		"." + trashed.Format(trashTimeFormat),
+		"pvc-1234.20261019T132437Z",
+		".deleting",
+	} {
+		_, ok := parseTrashTime(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestCheckManagedVolumeDir(t *testing.T) {
+	d := NewDriver(&DriverOptions{
+		SnapshotsDir:  ".snapshots",
+		TrashDir:      ".trash",
+		HsmArchiveDir: "archive/volumes",
+	})
+
+	tests := []struct {
+		volumeDir string
+		valid     bool
+	}{
+		{volumeDir: "volumes/pvc-1234", valid: true},
+		{volumeDir: "archives/pvc-1234", valid: true},
+		{volumeDir: "pvc-1234"},
+		{volumeDir: "../volumes/pvc-1234"},
+		{volumeDir: ".trash/volumes/pvc-1234"},
+		{volumeDir: ".snapshots/pvc-1234"},
+		{volumeDir: "archive/volumes/pvc-1234"},
+		{volumeDir: "archive/volumes/team/pvc-1234"},
+		{volumeDir: "archive/pvc-1234"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.volumeDir, func(t *testing.T) {
+			err := d.checkManagedVolumeDir(test.volumeDir)
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", err)
+			}
+		})
+	}
+}
+
+func TestManagedParent(t *testing.T) {
+	root := t.TempDir()
+
+	managed, err := isManagedParent(root, "volumes")
+	require.NoError(t, err)
+	assert.False(t, managed)
+
+	require.NoError(t, makeManagedParent(root, "volumes/pvc-1234"))
+	managed, err = isManagedParent(root, "volumes")
+	require.NoError(t, err)
+	assert.True(t, managed)
+	// Making it again leaves it as it is.
+	require.NoError(t, makeManagedParent(root, "volumes/pvc-5678"))
+
+	// A marker must be a file that the driver created, not a link to one.
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "linked"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(root, "volumes", managedParentMarker), filepath.Join(root, "linked", managedParentMarker)))
+	managed, err = isManagedParent(root, "linked")
+	require.NoError(t, err)
+	assert.False(t, managed)
+	assert.Error(t, makeManagedParent(root, "linked/pvc-1234"))
+
+	// Nor is a parent that leaves the filesystem through a symlink managed.
+	require.NoError(t, os.Symlink(filepath.Join(root, "volumes"), filepath.Join(root, "elsewhere")))
+	_, err = isManagedParent(root, "elsewhere")
+	assert.Error(t, err)
+}
+
+func TestMoveToTrash(t *testing.T) {
+	d := NewDriver(&DriverOptions{TrashDir: ".trash"})
+	root := t.TempDir()
+	volume := filepath.Join(root, "volumes", "pvc-1234")
+	require.NoError(t, os.MkdirAll(volume, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(volume, "data"), []byte("data"), 0o644))
+
+	before := time.Now()
+	require.NoError(t, d.moveToTrash(root, "volumes/pvc-1234"))
+	assert.NoDirExists(t, volume)
+
+	entries, err := os.ReadDir(filepath.Join(root, ".trash"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	trashed, ok := parseTrashTime(entries[0].Name())
+	require.True(t, ok, entries[0].Name())
+	assert.False(t, trashed.Before(before.Truncate(time.Microsecond)))
+	assert.FileExists(t, filepath.Join(root, ".trash", entries[0].Name(), "data"))
+
+	// A volume that is already gone has been deleted.
+	require.NoError(t, d.moveToTrash(root, "volumes/pvc-1234"))
+
+	// A volume that resolves outside its parent directory is left alone.
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "volumes", "pvc-5678")))
+	err = d.moveToTrash(root, "volumes/pvc-5678")
+	assert.Equal(t, codes.Internal, status.Code(err), "%v", err)
+	assert.DirExists(t, outside)
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// A volume can tune the Lustre client that it is mounted with through volume
+// attributes. Each publish is its own client mount, with its own llite
+// instance and OSC devices, so the node plugin sets the parameters of only
+// that mount with lctl set_param once it is mounted. The parameters go away
+// with the mount when the volume is unpublished.
+
+// Prefix of the volume attributes that tune the client mount
+const clientTuningPrefix = "client-"
+
+// clientTunable is a Lustre client parameter that a volume attribute sets.
+type clientTunable struct {
+	// "llite" for the parameters of the mount, or "osc" for those of each of
+	// its OSC devices
+	device string
+	param  string
+	// Returns the value to set, or an error if the attribute's value is
+	// invalid
+	parse func(value string) (string, error)
+}
+
+var clientTunables = map[string]clientTunable{
+	VolumeContextClientMaxReadAheadMB:  {device: "llite", param: "max_read_ahead_mb", parse: parseTunableCount(0)},
+	VolumeContextClientMaxDirtyMB:      {device: "osc", param: "max_dirty_mb", parse: parseTunableCount(1)},
+	VolumeContextClientMaxRPCsInFlight: {device: "osc", param: "max_rpcs_in_flight", parse: parseTunableCount(1)},
+	VolumeContextClientStatAheadMax:    {device: "llite", param: "statahead_max", parse: parseTunableCount(0)},
+	VolumeContextClientLazyStatFS:      {device: "llite", param: "lazystatfs", parse: parseTunableBool},
+}
+
+func parseTunableCount(minimum int64) func(string) (string, error) {
+	return func(value string) (string, error) {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || n < minimum {
+			return "", fmt.Errorf("must be a number of at least %d", minimum)
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+}
+
+func parseTunableBool(value string) (string, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("must be a boolean")
+	}
+	if b {
+		return "1", nil
+	}
+	return "0", nil
+}
+
+// parseClientTuning parses a volume attribute that tunes the client mount,
+// and returns it in the form "attribute=value" with the value to set.
+func parseClientTuning(attribute, value string) (string, error) {
+	tunable, ok := clientTunables[attribute]
+	if !ok {
+		supported := []string{}
+		for name := range clientTunables {
+			supported = append(supported, name)
+		}
+		slices.Sort(supported)
+		return "", status.Errorf(codes.InvalidArgument,
+			"Context %s is not a supported client parameter, which are %s", attribute, strings.Join(supported, ", "))
+	}
+	parsed, err := tunable.parse(value)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Context %s %v, not %q", attribute, err, value)
+	}
+	return attribute + "=" + parsed, nil
+}
+
+// getClientInstance returns the llite instance of the client mount at
+// target, such as lustre-ffff8f6a4d6e8000, whose suffix also names its OSC
+// devices.
+func (d *Driver) getClientInstance(target string) (string, error) {
+	output, err := d.runLfs("getname", target)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("invalid lfs getname output %q", output)
+	}
+	return fields[0], nil
+}
+
+// tuneClient sets the client parameters of a volume on its client mount at
+// target.
+func (d *Driver) tuneClient(target string, vol *lustreVolume) error {
+	instance, err := d.getClientInstance(target)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not find the client of %q: %v", target, err)
+	}
+	clientID := instance[strings.LastIndex(instance, "-")+1:]
+
+	for _, setting := range strings.Split(vol.clientTuning, ",") {
+		attribute, value, _ := strings.Cut(setting, "=")
+		tunable := clientTunables[attribute]
+
+		var name string
+		if tunable.device == "osc" {
+			name = "osc.*-osc-" + clientID + "." + tunable.param
+		} else {
+			name = "llite." + instance + "." + tunable.param
+		}
+
+		if _, err := d.runLctl("list_param", name); err != nil {
+			return status.Errorf(codes.FailedPrecondition,
+				"Context %s is not supported by the Lustre client of the node: %v", attribute, err)
+		}
+		if _, err := d.runLctl("set_param", name+"="+value); err != nil {
+			return status.Errorf(codes.InvalidArgument,
+				"Context %s was rejected by the Lustre client of the node: %v", attribute, err)
+		}
+		klog.V(4).Infof("set %s=%s on %q", name, value, target)
+	}
+	return nil
+}
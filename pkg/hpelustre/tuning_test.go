@@ -0,0 +1,95 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseClientTuning(t *testing.T) {
+	tests := []struct {
+		desc      string
+		attribute string
+		value     string
+		expected  string
+		code      codes.Code
+	}{
+		{
+			desc:      "count",
+			attribute: "client-max-read-ahead-mb",
+			value:     "1024",
+			expected:  "client-max-read-ahead-mb=1024",
+		},
+		{
+			desc:      "count of 0",
+			attribute: "client-statahead-max",
+			value:     "0",
+			expected:  "client-statahead-max=0",
+		},
+		{
+			desc:      "true",
+			attribute: "client-lazystatfs",
+			value:     "true",
+			expected:  "client-lazystatfs=1",
+		},
+		{
+			desc:      "false",
+			attribute: "client-lazystatfs",
+			value:     "False",
+			expected:  "client-lazystatfs=0",
+		},
+		{
+			desc:      "count below the minimum",
+			attribute: "client-max-rpcs-in-flight",
+			value:     "0",
+			code:      codes.InvalidArgument,
+		},
+		{
+			desc:      "count too large",
+			attribute: "client-max-dirty-mb",
+			value:     "4294967296",
+			code:      codes.InvalidArgument,
+		},
+		{
+			desc:      "not a boolean",
+			attribute: "client-lazystatfs",
+			value:     "maybe",
+			code:      codes.InvalidArgument,
+		},
+		{
+			desc:      "unsupported parameter",
+			attribute: "client-checksums",
+			value:     "0",
+			code:      codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			parsed, err := parseClientTuning(test.attribute, test.value)
+			assert.Equal(t, test.code, status.Code(err))
+			assert.Equal(t, test.expected, parsed)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// These checks let the admission webhook reject a volume that the node plugin
+// would refuse to mount, using the same parsing as NodePublishVolume. The
+// volume handle is also checked more strictly than the node plugin does, since
+// mount.lustre only reports a bad handle after a long timeout.
+
+// Lustre network types that may appear in a NID
+var lnetNetworkTypes = []string{"tcp", "o2ib", "kfi", "gni", "efa", "lo"}
+
+var (
+	lnetNetworkRegexp  = regexp.MustCompile(`^([a-z0-9]+?)([0-9]*)$`)
+	lustreFsNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,8}$`)
+)
+
+// validateNID checks a NID such as "10.1.1.113@tcp" or "10.1.1.113@o2ib1".
+func validateNID(nid string) error {
+	address, network, found := strings.Cut(nid, "@")
+	if !found || len(address) == 0 || len(network) == 0 {
+		return status.Errorf(codes.InvalidArgument, "NID %q is not of the form <address>@<network>", nid)
+	}
+
+	match := lnetNetworkRegexp.FindStringSubmatch(network)
+	known := false
+	for _, t := range lnetNetworkTypes {
+		if match != nil && match[1] == t {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return status.Errorf(codes.InvalidArgument,
+			"NID %q has unknown network %q; the network type must be one of %s, optionally followed by a number",
+			nid, network, strings.Join(lnetNetworkTypes, ", "))
+	}
+
+	// Networks such as gni and kfi use numeric addresses.
+	isNumber := strings.Trim(address, "0123456789") == ""
+	if net.ParseIP(address) == nil && !isNumber {
+		return status.Errorf(codes.InvalidArgument, "NID %q does not have an IP or numeric address", nid)
+	}
+
+	return nil
+}
+
+// ValidateVolumeHandle checks that a volume handle is a Lustre mount source of
+// the form <mgs>:/<fsname>[/<path>], where <mgs> is a list of the MGS's NIDs,
+// separated by ",", with the NIDs of failover MGS nodes separated by ":". The
+// handle may be followed by "#<sub-dir>".
+func ValidateVolumeHandle(volumeID string) error {
+	handle, subDir := splitVolumeID(volumeID)
+	if strings.Contains(volumeID, separator) && !ensureStrictSubpath(subDir) {
+		return status.Errorf(codes.InvalidArgument, "sub-dir %q of the volume must be strict subpath", subDir)
+	}
+
+	mgs, fsPath, err := parseVolumeHandle(handle)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range strings.Split(mgs, ":") {
+		for _, nid := range strings.Split(node, ",") {
+			if err := validateNID(nid); err != nil {
+				return err
+			}
+		}
+	}
+
+	fsName, fileset, _ := strings.Cut(fsPath, "/")
+	if !lustreFsNameRegexp.MatchString(fsName) {
+		return status.Errorf(codes.InvalidArgument,
+			"filesystem name %q must be 1 to 8 letters, digits, '_' or '-'", fsName)
+	}
+	if len(fileset) != 0 && !ensureStrictSubpath(fileset) {
+		return status.Errorf(codes.InvalidArgument, "path %q below the filesystem must be strict subpath", fileset)
+	}
+
+	return nil
+}
+
+// ValidateVolumeAttributes checks the volume attributes of the volume with the
+// given handle the way NodePublishVolume parses its volume context. The sub-dir
+// may still have variables, which are only substituted on publish.
+func ValidateVolumeAttributes(volumeID string, attributes map[string]string) error {
+	if err := checkPersistentVolumeContext(attributes); err != nil {
+		return err
+	}
+
+	vol := newVolume("")
+	if err := parseVolumeContext(vol, attributes); err != nil {
+		return err
+	}
+
+	if len(vol.subDir) == 0 {
+		return nil
+	}
+	if _, idSubDir := splitVolumeID(volumeID); len(idSubDir) != 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"Context sub-dir must not be set for volume %q, whose ID has a sub-dir", volumeID)
+	}
+	if !ensureStrictSubpath(vol.subDir) {
+		return status.Error(codes.InvalidArgument, "Context sub-dir must be strict subpath")
+	}
+
+	return nil
+}
+
+// ValidateEphemeralVolumeAttributes checks the volume attributes of an
+// ephemeral inline volume, including the handle of its filesystem.
+func ValidateEphemeralVolumeAttributes(attributes map[string]string) error {
+	vol, err := getEphemeralVolume(attributes)
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateVolumeHandle(vol.id); err != nil {
+		return err
+	}
+	if !ensureStrictSubpath(vol.subDir) {
+		return status.Error(codes.InvalidArgument, "Context sub-dir must be strict subpath")
+	}
+
+	return nil
+}
+
+// ValidateStorageClassParameters checks the parameters of a StorageClass the
+// way CreateVolume parses them.
+func ValidateStorageClassParameters(parameters map[string]string) error {
+	_, err := parseStorageClassParameters(parameters)
+	return err
+}
+
+// ValidateMutableParameters checks the parameters of a VolumeAttributesClass
+// the way CreateVolume and ControllerModifyVolume parse them.
+func ValidateMutableParameters(parameters map[string]string) error {
+	_, err := parseMutableParameters(parameters)
+	return err
+}
+
+// ValidateMountOptions checks mount options that NodePublishVolume would
+// reject.
+func ValidateMountOptions(mountOptions []string) error {
+	_, _, err := getMountOptions(nil, mountOptions)
+	return err
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+// A volume that is created from a content source, a snapshot or another
+// volume, is populated by copying the source into it in the background.
+// CreateVolume fails with Aborted until the copy completes, and the CO calls it
+// again until it succeeds. While the volume is being populated, a marker next
+// to it in its parent directory records its source, so that a copy that was
+// interrupted by a restart of the controller is resumed.
+
+const (
+	// Parent of the internal mounts of filesystems that volumes are being
+	// populated on
+	volumeCopyMountDir = "volume-copies"
+
+	populatingMarkerSuffix = ".populating"
+)
+
+// backgroundCopy is a copy that runs in the background. err is only set once
+// done is closed.
+type backgroundCopy struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func startBackgroundCopy(description string, copyTree func(ctx context.Context) error) *backgroundCopy {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &backgroundCopy{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(c.done)
+		defer cancel()
+
+		start := time.Now()
+		c.err = copyTree(ctx)
+		if c.err != nil {
+			klog.Errorf("failed to copy %s: %v", description, c.err)
+			return
+		}
+		klog.Infof("copied %s in %v", description, time.Since(start).Round(time.Second))
+	}()
+
+	return c
+}
+
+// finished reports whether the copy has completed or failed.
+func (c *backgroundCopy) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop cancels the copy and waits for it to finish.
+func (c *backgroundCopy) stop() {
+	c.cancel()
+	<-c.done
+}
+
+// volumeSource is the content source of a volume, on the volume's filesystem.
+type volumeSource struct {
+	// ID of the source snapshot or volume
+	ID string `json:"id"`
+	// Sub-dir of the filesystem with the data to copy
+	SubDir string `json:"subDir"`
+	// The source is a snapshot, whose copy has had its write permission
+	// removed
+	Snapshot bool `json:"snapshot"`
+}
+
+// getVolumeSource returns the source that a volume on a filesystem is
+// populated from, or nil if it has none.
+func (d *Driver) getVolumeSource(contentSource *csi.VolumeContentSource, handle string) (*volumeSource, error) {
+	if contentSource == nil {
+		return nil, nil
+	}
+	if !d.enableVolumeCopy {
+		return nil, status.Error(codes.InvalidArgument,
+			"Creating a volume from a snapshot or another volume is not enabled")
+	}
+
+	source := &volumeSource{}
+	sourceHandle := ""
+
+	if snapshot := contentSource.GetSnapshot(); snapshot != nil {
+		var snapshotDir string
+		var ok bool
+		source.ID = snapshot.GetSnapshotId()
+		sourceHandle, snapshotDir, ok = d.parseSnapshotID(source.ID)
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "Snapshot %q not found", source.ID)
+		}
+		source.SubDir = filepath.Join(snapshotDir, snapshotDataDir)
+		source.Snapshot = true
+	} else if volume := contentSource.GetVolume(); volume != nil {
+		source.ID = volume.GetVolumeId()
+		sourceHandle, source.SubDir = splitVolumeID(source.ID)
+		if len(source.SubDir) == 0 || !ensureStrictSubpath(source.SubDir) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Volume %q is not a sub-dir volume; only sub-dir volumes can be cloned", source.ID)
+		}
+	} else {
+		return nil, status.Error(codes.InvalidArgument, "Unknown volume content source")
+	}
+
+	if sourceHandle != handle {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Content source %q is not on filesystem %q", source.ID, handle)
+	}
+
+	return source, nil
+}
+
+// checkVolumeSource checks that the source of a volume exists and can be copied,
+// on the filesystem mounted at root.
+func checkVolumeSource(root string, source *volumeSource) error {
+	if source.Snapshot {
+		info, err := readSnapshotInfo(root, filepath.Dir(source.SubDir))
+		if err != nil {
+			return err
+		} else if info == nil {
+			return status.Errorf(codes.NotFound, "Snapshot %q not found", source.ID)
+		} else if !info.ReadyToUse {
+			return status.Errorf(codes.Unavailable, "Snapshot %q is not ready to use", source.ID)
+		}
+	} else if populating, err := readPopulatingMarker(root, source.SubDir); err != nil {
+		return err
+	} else if populating != nil {
+		return status.Errorf(codes.Unavailable, "Volume %q is still being populated", source.ID)
+	}
+
+	if _, exists, err := checkSubDir(root, source.SubDir); err != nil {
+		return err
+	} else if !exists {
+		return status.Errorf(codes.NotFound, "Content source %q not found", source.ID)
+	}
+
+	return nil
+}
+
+// populatingMarker returns the sub-dir of the marker of a volume that is being
+// populated.
+func populatingMarker(volumeDir string) string {
+	return filepath.Join(filepath.Dir(volumeDir), "."+filepath.Base(volumeDir)+populatingMarkerSuffix)
+}
+
+// readPopulatingMarker returns the source that a volume is being populated
+// from, or nil if it is not being populated.
+func readPopulatingMarker(root, volumeDir string) (*volumeSource, error) {
+	path := filepath.Join(root, populatingMarker(volumeDir))
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read %q: %v", path, err)
+	}
+
+	source := &volumeSource{}
+	if err := json.Unmarshal(data, source); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to parse %q: %v", path, err)
+	}
+
+	return source, nil
+}
+
+func writePopulatingMarker(root, volumeDir string, source *volumeSource) error {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode volume source: %v", err)
+	}
+
+	path := filepath.Join(root, populatingMarker(volumeDir))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return status.Errorf(codes.Internal, "failed to write %q: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return status.Errorf(codes.Internal, "failed to write %q: %v", path, err)
+	}
+
+	return nil
+}
+
+// startVolumeCopy populates a volume from its source in the background. The
+// caller holds volumeLock.
+func (d *Driver) startVolumeCopy(volumeID string, source *volumeSource, m *volumeModification) {
+	description := fmt.Sprintf("%s into volume %s", source.ID, volumeID)
+	d.volumeCopies[volumeID] = startBackgroundCopy(description, func(ctx context.Context) error {
+		return d.populateVolume(ctx, volumeID, source, m)
+	})
+}
+
+// populateVolume copies the source of a volume into it. A volume that is
+// mirrored is given its mirrored layout before anything is copied into it, so
+// that the copies inherit the layout rather than that of their source.
+func (d *Driver) populateVolume(ctx context.Context, volumeID string, source *volumeSource, m *volumeModification) error {
+	handle, volumeDir := splitVolumeID(volumeID)
+
+	root, unmount, err := d.mountFilesystem(handle, filepath.Join(volumeCopyMountDir, filepath.Base(volumeDir)), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer unmount()
+
+	if err := checkVolumeSource(root, source); err != nil {
+		return err
+	}
+	src, _, err := checkSubDir(root, source.SubDir)
+	if err != nil {
+		return err
+	}
+	dst, _, err := checkSubDir(root, volumeDir)
+	if err != nil {
+		return err
+	}
+
+	mirrored := len(m.mirrorPools) != 0
+	if mirrored {
+		if err := os.Mkdir(dst, 0o700); err != nil && !os.IsExist(err) {
+			return status.Errorf(codes.Internal, "failed to make volume directory %q: %v", dst, err)
+		}
+		if err := d.setMirroredLayout(dst, m); err != nil {
+			return err
+		}
+	}
+
+	_, err = volumehelper.CopyTree(ctx, src, dst, volumehelper.CopyTreeOptions{
+		Workers:       d.copyWorkers,
+		RestoreMode:   source.Snapshot,
+		Resume:        true,
+		InheritLayout: mirrored,
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to copy %q into volume %q: %v", source.ID, volumeID, err)
+	}
+
+	// The copies were written to a single mirror.
+	if mirrored {
+		if err := d.resyncVolumeMirrors(ctx, volumeID, dst); err != nil {
+			return status.Errorf(codes.Internal, "failed to resync mirrors of volume %q: %v", volumeID, err)
+		}
+	}
+
+	marker := filepath.Join(root, populatingMarker(volumeDir))
+	if err := os.Remove(marker); err != nil {
+		return status.Errorf(codes.Internal, "failed to remove %q: %v", marker, err)
+	}
+
+	return nil
+}
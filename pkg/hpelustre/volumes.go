@@ -0,0 +1,510 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	volumehelper "github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// The volumes that the driver created are the directories in the managed
+// parent directories of its filesystems. The controller finds the parent
+// directories from the parameters of the driver's StorageClasses, along with
+// any others that it created or deleted volumes in since it started.
+
+const (
+	// Extended attribute of a volume directory that holds the capacity that
+	// was requested for it, in bytes
+	volumeCapacityXattr = "trusted.lustre-csi.capacity"
+	// Extended attribute of a volume directory that holds the volume context
+	// that it was created with, in JSON
+	volumeContextXattr = "trusted.lustre-csi.context"
+
+	// How long the volumes found for the first page of ListVolumes are used
+	// for the pages that follow it
+	volumeListingTTL = time.Minute
+
+	// Internal mount of a filesystem whose volumes are being listed
+	inventoryMountPath = "inventory"
+)
+
+type managedParent struct {
+	filesystem string
+	parentDir  string
+}
+
+// addManagedParent records a parent directory that volumes are created in.
+func (d *Driver) addManagedParent(handle, parentDir string) {
+	d.managedParentsLock.Lock()
+	defer d.managedParentsLock.Unlock()
+	d.managedParents[managedParent{filesystem: handle, parentDir: parentDir}] = struct{}{}
+}
+
+// getManagedParents returns the parent directories that volumes are created
+// in, sorted by filesystem and directory. Whether they are managed is only
+// known once the filesystem is mounted.
+func (d *Driver) getManagedParents(ctx context.Context) []managedParent {
+	d.managedParentsLock.Lock()
+	parents := map[managedParent]struct{}{}
+	for parent := range d.managedParents {
+		parents[parent] = struct{}{}
+	}
+	d.managedParentsLock.Unlock()
+
+	if err := d.addStorageClassParents(ctx, parents); err != nil {
+		klog.Warningf("could not list StorageClasses of the driver: %v", err)
+	}
+
+	result := make([]managedParent, 0, len(parents))
+	for parent := range parents {
+		result = append(result, parent)
+	}
+	slices.SortFunc(result, func(a, b managedParent) int {
+		return strings.Compare(a.filesystem+"\x00"+a.parentDir, b.filesystem+"\x00"+b.parentDir)
+	})
+	return result
+}
+
+func (d *Driver) addStorageClassParents(ctx context.Context, parents map[managedParent]struct{}) error {
+	client, err := d.getKubeClient()
+	if err != nil {
+		return err
+	}
+	list, err := client.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, sc := range list.Items {
+		if sc.Provisioner != d.Name {
+			continue
+		}
+		if params, err := parseStorageClassParameters(sc.Parameters); err == nil {
+			parents[managedParent{filesystem: params.filesystem, parentDir: params.parentDir}] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// recordVolumeCapacity records the capacity that was requested for a volume,
+// unless it already has one.
+func recordVolumeCapacity(path string, capacity int64) error {
+	err := unix.Lsetxattr(path, volumeCapacityXattr, []byte(strconv.FormatInt(capacity, 10)), unix.XATTR_CREATE)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return status.Errorf(codes.Internal, "failed to record capacity of %q: %v", path, err)
+	}
+	return nil
+}
+
+// getVolumeCapacity returns the capacity that was requested for a volume, or
+// 0 if it is not known.
+func getVolumeCapacity(path string) int64 {
+	buf := make([]byte, 32)
+	n, err := unix.Lgetxattr(path, volumeCapacityXattr, buf)
+	if err != nil {
+		return 0
+	}
+	capacity, err := strconv.ParseInt(string(buf[:n]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return capacity
+}
+
+// recordVolumeContext records the volume context that a volume was created
+// with, which is returned along with it by ListVolumes and ControllerGetVolume.
+func recordVolumeContext(path string, volumeContext map[string]string) error {
+	data, err := json.Marshal(volumeContext)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode volume context: %v", err)
+	}
+	if err := unix.Lsetxattr(path, volumeContextXattr, data, 0); err != nil {
+		return status.Errorf(codes.Internal, "failed to record volume context of %q: %v", path, err)
+	}
+	return nil
+}
+
+// getVolumeContext returns the volume context that a volume was created with,
+// or nil if it is not known.
+func getVolumeContext(path string) map[string]string {
+	size, err := unix.Lgetxattr(path, volumeContextXattr, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	n, err := unix.Lgetxattr(path, volumeContextXattr, buf)
+	if err != nil {
+		return nil
+	}
+
+	volumeContext := map[string]string{}
+	if err := json.Unmarshal(buf[:n], &volumeContext); err != nil {
+		klog.Warningf("could not parse volume context of %q: %v", path, err)
+		return nil
+	}
+	return volumeContext
+}
+
+// volumeListing is the volumes that were found for the first page of
+// ListVolumes, which the pages that follow it are returned from.
+type volumeListing struct {
+	entries []*csi.ListVolumesResponse_Entry
+	expires time.Time
+}
+
+// ListVolumes lists the volumes in the managed parent directories of every
+// filesystem, sorted by their IDs. The starting token of a page is the ID of
+// its first volume. The pages that follow the first are returned from the
+// volumes that were found for it, for a while, rather than finding them
+// again, and the listing is aborted if the first volume of a page is gone.
+func (d *Driver) ListVolumes(
+	ctx context.Context,
+	req *csi.ListVolumesRequest,
+) (*csi.ListVolumesResponse, error) {
+	if !d.enableProvisioning {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	token := req.GetStartingToken()
+	entries, err := d.listVolumes(ctx, len(token) != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+	if len(token) != 0 {
+		var found bool
+		start, found = slices.BinarySearchFunc(entries, token, func(e *csi.ListVolumesResponse_Entry, id string) int {
+			return strings.Compare(e.GetVolume().GetVolumeId(), id)
+		})
+		if !found {
+			return nil, status.Errorf(codes.Aborted, "Starting token %q does not match any volume", token)
+		}
+	}
+
+	end := len(entries)
+	if maxEntries := int(req.GetMaxEntries()); maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	resp := &csi.ListVolumesResponse{
+		Entries: entries[start:end],
+	}
+	if end < len(entries) {
+		resp.NextToken = entries[end].GetVolume().GetVolumeId()
+	}
+
+	return resp, nil
+}
+
+// listVolumes returns the volumes in the managed parent directories of every
+// filesystem, sorted by their IDs. The volumes found for an earlier page are
+// returned for a page that follows it, until they expire.
+func (d *Driver) listVolumes(ctx context.Context, nextPage bool) ([]*csi.ListVolumesResponse_Entry, error) {
+	d.volumeListingLock.Lock()
+	defer d.volumeListingLock.Unlock()
+
+	if nextPage && d.volumeListing != nil && time.Now().Before(d.volumeListing.expires) {
+		return d.volumeListing.entries, nil
+	}
+
+	entries, err := d.findVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.volumeListing = &volumeListing{
+		entries: entries,
+		expires: time.Now().Add(volumeListingTTL),
+	}
+	return entries, nil
+}
+
+// findVolumes returns the volumes in the managed parent directories of every
+// filesystem, sorted by their IDs.
+func (d *Driver) findVolumes(ctx context.Context) ([]*csi.ListVolumesResponse_Entry, error) {
+	publishedNodes, err := d.getPublishedNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d.inventoryLock.Lock()
+	defer d.inventoryLock.Unlock()
+
+	entries := []*csi.ListVolumesResponse_Entry{}
+	parents := d.getManagedParents(ctx)
+	for len(parents) != 0 {
+		handle := parents[0].filesystem
+		i := 1
+		for i < len(parents) && parents[i].filesystem == handle {
+			i++
+		}
+
+		found, err := d.findFilesystemVolumes(handle, parents[:i], publishedNodes)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+		parents = parents[i:]
+	}
+
+	slices.SortFunc(entries, func(a, b *csi.ListVolumesResponse_Entry) int {
+		return strings.Compare(a.GetVolume().GetVolumeId(), b.GetVolume().GetVolumeId())
+	})
+	return entries, nil
+}
+
+func (d *Driver) findFilesystemVolumes(handle string, parents []managedParent, publishedNodes map[string][]string) ([]*csi.ListVolumesResponse_Entry, error) {
+	root, unmount, err := d.mountFilesystem(handle, inventoryMountPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	entries := []*csi.ListVolumesResponse_Entry{}
+	for _, parent := range parents {
+		if managed, err := isManagedParent(root, parent.parentDir); err != nil {
+			return nil, err
+		} else if !managed {
+			continue
+		}
+
+		path, _, err := checkSubDir(root, parent.parentDir)
+		if err != nil {
+			return nil, err
+		}
+		dirEntries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to read %q: %v", path, err)
+		}
+
+		for _, dirEntry := range dirEntries {
+			// Markers of the parent directory and of volumes being populated
+			// are dot-files.
+			if strings.HasPrefix(dirEntry.Name(), ".") || !dirEntry.IsDir() {
+				continue
+			}
+
+			volumeDir := filepath.Join(parent.parentDir, dirEntry.Name())
+			volumeID := makeSubDirVolumeID(handle, volumeDir)
+			volume, condition, err := d.getVolumeStatus(root, volumeID)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &csi.ListVolumesResponse_Entry{
+				Volume: volume,
+				Status: &csi.ListVolumesResponse_VolumeStatus{
+					PublishedNodeIds: publishedNodes[volumeID],
+					VolumeCondition:  condition,
+				},
+			})
+		}
+	}
+
+	return entries, nil
+}
+
+// ControllerGetVolume returns a volume that the driver created, and its
+// condition, including the HSM state of its files.
+func (d *Driver) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+	if !d.enableProvisioning {
+		return nil, status.Error(codes.Unimplemented, "")
+	}
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument,
+			"Volume ID missing in request")
+	}
+
+	handle, volumeDir := splitVolumeID(volumeID)
+	if len(volumeDir) == 0 || ValidateVolumeHandle(volumeID) != nil || d.checkManagedVolumeDir(volumeDir) != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q was not created by the driver", volumeID)
+	}
+
+	publishedNodes, err := d.getPublishedNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d.inventoryLock.Lock()
+	defer d.inventoryLock.Unlock()
+
+	root, unmount, err := d.mountFilesystem(handle, inventoryMountPath, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unmount()
+
+	if managed, err := isManagedParent(root, filepath.Dir(volumeDir)); err != nil {
+		return nil, err
+	} else if !managed {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found", volumeID)
+	}
+
+	volume, condition, err := d.getVolumeStatus(root, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if path, exists, err := checkSubDir(root, volumeDir); err == nil && exists {
+		d.addHsmCondition(ctx, path, condition)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: volume,
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: publishedNodes[volumeID],
+			VolumeCondition:  condition,
+		},
+	}, nil
+}
+
+// getVolumeStatus returns a volume in a managed parent directory of the
+// filesystem mounted at root, and its condition: abnormal if its directory is
+// missing or it is over its project quota.
+func (d *Driver) getVolumeStatus(root, volumeID string) (*csi.Volume, *csi.VolumeCondition, error) {
+	_, volumeDir := splitVolumeID(volumeID)
+	volume := &csi.Volume{VolumeId: volumeID}
+
+	populating, err := readPopulatingMarker(root, volumeDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if populating != nil {
+		return volume, &csi.VolumeCondition{
+			Message: fmt.Sprintf("Volume is being populated from %s", populating.ID),
+		}, nil
+	}
+
+	path, exists, err := checkSubDir(root, volumeDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return volume, &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  "Volume directory is missing",
+		}, nil
+	}
+	volume.CapacityBytes = getVolumeCapacity(path)
+	volume.VolumeContext = getVolumeContext(path)
+
+	condition := &csi.VolumeCondition{}
+	if message := d.checkVolumeQuota(root, path); len(message) != 0 {
+		condition.Abnormal = true
+		condition.Message = message
+	}
+
+	return volume, condition, nil
+}
+
+// checkVolumeQuota returns why a volume directory is over the limits of its
+// project quota, or "" if it is not or has no project.
+func (d *Driver) checkVolumeQuota(root, path string) string {
+	output, err := d.mounter.Exec.Command("lfs", "project", "-d", path).CombinedOutput()
+	if err != nil {
+		klog.V(4).Infof("could not get project of %q: %v: %s", path, err, strings.TrimSpace(string(output)))
+		return ""
+	}
+	projectID, err := volumehelper.ParseLfsProject(string(output))
+	if err != nil || projectID == 0 {
+		return ""
+	}
+
+	output, err = d.mounter.Exec.Command("lfs", "quota", "-q", "-p", strconv.FormatUint(uint64(projectID), 10), root).CombinedOutput()
+	if err != nil {
+		klog.V(4).Infof("could not get quota of project %d: %v: %s", projectID, err, strings.TrimSpace(string(output)))
+		return ""
+	}
+	quota, err := volumehelper.ParseLfsQuota(string(output))
+	if err != nil {
+		klog.Warningf("could not get quota of project %d: %v", projectID, err)
+		return ""
+	}
+	if !quota.Exceeded {
+		return ""
+	}
+
+	return fmt.Sprintf("Volume is over its quota of project %d: %d KiB of %d KiB, %d of %d inodes",
+		projectID, quota.UsedKiB, quota.HardLimitKiB, quota.Files, quota.HardLimit)
+}
+
+// getPublishedNodes returns the nodes that each volume is published on, from
+// the VolumeAttachments of the driver. It is empty unless attach mode is
+// enabled, as the driver is not told about publishing otherwise.
+func (d *Driver) getPublishedNodes(ctx context.Context) (map[string][]string, error) {
+	publishedNodes := map[string][]string{}
+	if !d.enableAttach {
+		return publishedNodes, nil
+	}
+
+	client, err := d.getKubeClient()
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "%v", err)
+	}
+	attachments, err := client.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not list VolumeAttachments: %v", err)
+	}
+	pvs, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not list PersistentVolumes: %v", err)
+	}
+
+	volumeIDs := map[string]string{}
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == d.Name {
+			volumeIDs[pv.Name] = pv.Spec.CSI.VolumeHandle
+		}
+	}
+
+	for _, attachment := range attachments.Items {
+		pvName := attachment.Spec.Source.PersistentVolumeName
+		if attachment.Spec.Attacher != d.Name || !attachment.Status.Attached || pvName == nil {
+			continue
+		}
+		if volumeID, ok := volumeIDs[*pvName]; ok && !slices.Contains(publishedNodes[volumeID], attachment.Spec.NodeName) {
+			publishedNodes[volumeID] = append(publishedNodes[volumeID], attachment.Spec.NodeName)
+		}
+	}
+	for _, nodes := range publishedNodes {
+		slices.Sort(nodes)
+	}
+
+	return publishedNodes, nil
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hpelustre
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListVolumesPages(t *testing.T) {
+	entries := []*csi.ListVolumesResponse_Entry{}
+	for _, id := range []string{"10.1.1.113@tcp:/lushtx#csi/a", "10.1.1.113@tcp:/lushtx#csi/b", "10.1.1.113@tcp:/lushtx#csi/c"} {
+		entries = append(entries, &csi.ListVolumesResponse_Entry{Volume: &csi.Volume{VolumeId: id}})
+	}
+	d := NewDriver(&DriverOptions{EnableProvisioning: true})
+	d.volumeListing = &volumeListing{entries: entries, expires: time.Now().Add(volumeListingTTL)}
+
+	tests := []struct {
+		desc       string
+		token      string
+		maxEntries int32
+		ids        []string
+		nextToken  string
+		code       codes.Code
+	}{
+		{
+			desc:       "next page",
+			token:      "10.1.1.113@tcp:/lushtx#csi/b",
+			maxEntries: 1,
+			ids:        []string{"10.1.1.113@tcp:/lushtx#csi/b"},
+			nextToken:  "10.1.1.113@tcp:/lushtx#csi/c",
+		},
+		{
+			desc:  "last page",
+			token: "10.1.1.113@tcp:/lushtx#csi/b",
+			ids:   []string{"10.1.1.113@tcp:/lushtx#csi/b", "10.1.1.113@tcp:/lushtx#csi/c"},
+		},
+		{
+			desc:  "volume that is gone",
+			token: "10.1.1.113@tcp:/lushtx#csi/bb",
+			code:  codes.Aborted,
+		},
+		{
+			desc:  "index token",
+			token: "1",
+			code:  codes.Aborted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := d.ListVolumes(context.Background(), &csi.ListVolumesRequest{
+				StartingToken: test.token,
+				MaxEntries:    test.maxEntries,
+			})
+			if test.code != codes.OK {
+				assert.Equal(t, test.code, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+
+			ids := []string{}
+			for _, entry := range resp.GetEntries() {
+				ids = append(ids, entry.GetVolume().GetVolumeId())
+			}
+			assert.Equal(t, test.ids, ids)
+			assert.Equal(t, test.nextToken, resp.GetNextToken())
+		})
+	}
+}
+
+func TestVolumeContextXattr(t *testing.T) {
+	path := t.TempDir()
+	assert.Nil(t, getVolumeContext(path))
+
+	volumeContext := map[string]string{"sub-dir-on-unpublish": "retain", "client-max-rpcs-in-flight": "16"}
+	if err := recordVolumeContext(path, volumeContext); err != nil {
+		t.Skipf("trusted extended attributes are not supported: %v", err)
+	}
+	assert.Equal(t, volumeContext, getVolumeContext(path))
+}
@@ -23,7 +23,7 @@ echo "$(date -u) Command line arguments: $@"
 
 echo "$(date -u) Entering Lustre CSI driver"
 
-echo Executing: $1 ${2-} ${3-} ${4-} ${5-} ${6-} ${7-} ${8-} ${9-}
-$1 ${2-} ${3-} ${4-} ${5-} ${6-} ${7-} ${8-} ${9-}
+echo Executing: "$@"
+"$1" "${@:2}"
 
 echo "$(date -u) Exiting Lustre CSI driver"
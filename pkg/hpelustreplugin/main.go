@@ -19,9 +19,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/HewlettPackard/lustre-csi-driver/pkg/hpelustre"
+	"github.com/HewlettPackard/lustre-csi-driver/pkg/util"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/klog/v2"
 )
 
@@ -40,6 +45,35 @@ var (
 	driverName               = flag.String("drivername", NnfDriverName, "name of the driver")
 	enableHpeLustreMockMount = flag.Bool("enable-hpelustre-mock-mount", false, "Whether enable mock mount(only for testing)")
 	workingMountDir          = flag.String("working-mount-dir", "/tmp", "working directory for provisioner to mount lustre filesystems temporarily")
+	enableAttach             = flag.Bool("enable-attach", false, "Whether to enforce single-node access modes through ControllerPublishVolume")
+	namespace                = flag.String("namespace", "lustre-csi-system", "namespace holding the driver's Kubernetes objects, such as attach leases")
+	stateDir                 = flag.String("state-dir", "/var/lib/kubelet/plugins/lustre-csi.hpe.com/state", "directory on the host for state about published volumes")
+	sskKeyDir                = flag.String("ssk-key-dir", "/run/lustre-csi/ssk", "tmpfs directory that holds Lustre SSK keys while they are in use")
+	enableIdmappedMounts     = flag.Bool("enable-idmapped-mounts", false, "Whether to publish volumes to pods with user namespaces through idmapped mounts")
+	accessPolicyFile         = flag.String("access-policy-file", "", "file with the policy of which namespaces and service accounts may use which filesystems, or empty to allow all")
+	enableSnapshots          = flag.Bool("enable-snapshots", false, "Whether to create snapshots of sub-dir volumes by copying them")
+	snapshotsDir             = flag.String("snapshots-dir", ".snapshots", "directory below the root of each filesystem that holds its snapshots")
+	enableProvisioning       = flag.Bool("enable-provisioning", false, "Whether to create sub-dir volumes through CreateVolume")
+	enableVolumeCopy         = flag.Bool("enable-volume-copy", false, "Whether to populate new volumes from snapshots and other volumes by copying them")
+	enableCapacity           = flag.Bool("enable-capacity", false, "Whether to report the free space of filesystems and OST pools through GetCapacity")
+	copyWorkers              = flag.Int("copy-workers", 8, "number of files that are copied at the same time for a snapshot or a new volume")
+	trashDir                 = flag.String("trash-dir", ".trash", "directory below the root of each filesystem that deleted volumes are moved into")
+	trashTTL                 = flag.Duration("trash-ttl", 24*time.Hour, "time that a deleted volume stays in the trash before it is removed")
+	trashCollectInterval     = flag.Duration("trash-collect-interval", 10*time.Minute, "time between collections of the trash")
+	trashWorkers             = flag.Int("trash-workers", 8, "number of files that are removed at the same time from the trash")
+	hsmPublishMaxBytes       = flag.Int64("hsm-publish-max-bytes", 100<<30, "most bytes of a volume that are restored from the HSM archive or prefetched when it is published")
+	hsmArchiveDir            = flag.String("hsm-archive-dir", ".hsm-archive", "directory below the root of each filesystem that deleted volumes are moved into once their files are archived and released")
+	pccConfigFile            = flag.String("pcc-config-file", "", "file with the PCC backends of the node that volumes may be cached in, or empty to not cache volumes")
+	prefetchWorkers          = flag.Int("prefetch-workers", 8, "number of files that are prefetched at the same time on the node for the volumes published on it")
+	mirrorResyncInterval     = flag.Duration("mirror-resync-interval", time.Hour, "time between resyncs of the stale files of mirrored volumes, or 0 to not resync them")
+	clientParamsFile         = flag.String("client-params-file", "", "file with the Lustre client parameters that are kept on the node, or empty to not manage them")
+	clientParamsInterval     = flag.Duration("client-params-interval", 5*time.Minute, "time between reconciliations of the Lustre client parameters of the node")
+	clientParamAllowlist     = flag.String("client-param-allowlist", strings.Join(hpelustre.DefaultClientParamAllowlist, ","), "comma-separated patterns of the Lustre client parameters that --client-params-file may set")
+	lnetConfigFile           = flag.String("lnet-config-file", "", "file with the LNet configuration of the node, in the form that lnetctl import takes, or empty to not configure LNet")
+	lnetConfigInterval       = flag.Duration("lnet-config-interval", time.Minute, "time between reconciliations of LNet with --lnet-config-file")
+	lnetForce                = flag.Bool("lnet-force", false, "Whether to change LNet to match --lnet-config-file even while Lustre filesystems are mounted")
+	metricsAddress           = flag.String("metrics-address", "", "address to serve Prometheus metrics on, such as :29765, or empty to not serve them")
+	subDirVariables          = flag.String("sub-dir-variables", "", "variables that sub-dir templates may refer to as ${driver.<name>}, in the form name1=value1,name2=value2")
 	swapSourceFrom           = flag.String("swap-source-from", "", "source as specified in PV's spec.csi.volumeHandle to be swapped")
 	swapSourceTo             = flag.String("swap-source-to", "", "source to be used in place of the PV's spec.csi.volumeHandle")
 	swapSourceToFSType       = flag.String("swap-source-to-fstype", "", "fs type of the --swap-source-to volume")
@@ -86,11 +120,50 @@ func main() {
 }
 
 func handle() {
+	variables, err := util.ConvertTagsToMap(*subDirVariables)
+	if err != nil {
+		klog.Fatalf("--sub-dir-variables: %v", err)
+	}
+	allowlist := []string{}
+	for _, pattern := range strings.Split(*clientParamAllowlist, ",") {
+		if pattern = strings.TrimSpace(pattern); len(pattern) != 0 {
+			allowlist = append(allowlist, pattern)
+		}
+	}
+
 	driverOptions := hpelustre.DriverOptions{
 		NodeID:                   *nodeID,
 		DriverName:               *driverName,
 		EnableHpeLustreMockMount: *enableHpeLustreMockMount,
 		WorkingMountDir:          *workingMountDir,
+		EnableAttach:             *enableAttach,
+		Namespace:                *namespace,
+		StateDir:                 *stateDir,
+		SSKKeyDir:                *sskKeyDir,
+		EnableIdmappedMounts:     *enableIdmappedMounts,
+		AccessPolicyFile:         *accessPolicyFile,
+		SubDirVariables:          variables,
+		EnableSnapshots:          *enableSnapshots,
+		SnapshotsDir:             *snapshotsDir,
+		EnableProvisioning:       *enableProvisioning,
+		EnableVolumeCopy:         *enableVolumeCopy,
+		EnableCapacity:           *enableCapacity,
+		CopyWorkers:              *copyWorkers,
+		TrashDir:                 *trashDir,
+		TrashTTL:                 *trashTTL,
+		TrashCollectInterval:     *trashCollectInterval,
+		TrashWorkers:             *trashWorkers,
+		HsmPublishMaxBytes:       *hsmPublishMaxBytes,
+		HsmArchiveDir:            *hsmArchiveDir,
+		PCCConfigFile:            *pccConfigFile,
+		PrefetchWorkers:          *prefetchWorkers,
+		MirrorResyncInterval:     *mirrorResyncInterval,
+		ClientParamsFile:         *clientParamsFile,
+		ClientParamsInterval:     *clientParamsInterval,
+		ClientParamAllowlist:     allowlist,
+		LnetConfigFile:           *lnetConfigFile,
+		LnetConfigInterval:       *lnetConfigInterval,
+		LnetForce:                *lnetForce,
 		SwapSourceFrom:           swapSrc,
 		SwapSourceTo:             swapDst,
 		SwapSourceToFSType:       swapDstFSType,
@@ -99,5 +172,22 @@ func handle() {
 	if driver == nil {
 		klog.Fatalln("Failed to initialize HPE Lustre CSI driver")
 	}
+	if len(*metricsAddress) != 0 {
+		go serveMetrics(*metricsAddress)
+	}
 	driver.Run(*endpoint, false)
 }
+
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	klog.Infof("serving metrics on %s", address)
+	if err := server.ListenAndServe(); err != nil {
+		klog.Fatalf("failed to serve metrics: %v", err)
+	}
+}
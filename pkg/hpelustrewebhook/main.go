@@ -0,0 +1,59 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	address     = flag.String("address", ":9443", "address to serve admission reviews on")
+	tlsCertFile = flag.String("tls-cert-file", "/etc/lustre-csi-webhook/tls/tls.crt", "file with the webhook's serving certificate")
+	tlsKeyFile  = flag.String("tls-private-key-file", "/etc/lustre-csi-webhook/tls/tls.key", "file with the private key of the serving certificate")
+	driverName  = flag.String("drivername", "lustre-csi.hpe.com", "name of the driver whose volumes are validated")
+)
+
+func main() {
+	klog.InitFlags(nil)
+	err := flag.Set("logtostderr", "true")
+	if err != nil {
+		klog.Fatalln(err)
+	}
+	flag.Parse()
+
+	mux := http.NewServeMux()
+	mux.Handle("/validate", &validator{driverName: *driverName})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := &http.Server{
+		Addr:              *address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	klog.Infof("serving admission reviews for %s on %s", *driverName, *address)
+	klog.Fatalln(server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile))
+}
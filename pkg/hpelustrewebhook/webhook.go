@@ -0,0 +1,212 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/HewlettPackard/lustre-csi-driver/pkg/hpelustre"
+	"google.golang.org/grpc/status"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+)
+
+// AdmissionReview requests are limited to the size of an object in etcd.
+const maxReviewSize = 3 * 1024 * 1024
+
+// validator rejects PersistentVolumes, StorageClasses and pods with inline
+// volumes of the driver that the node plugin would refuse to mount, and
+// VolumeAttributesClasses that the controller would refuse to apply.
+type validator struct {
+	driverName string
+}
+
+func (v *validator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxReviewSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, "request is not an AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = v.review(review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		klog.Errorf("could not write admission response: %v", err)
+	}
+}
+
+func (v *validator) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{
+		UID:     req.UID,
+		Allowed: true,
+	}
+
+	errs, err := v.validate(req)
+	if err != nil {
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		}
+		return resp
+	}
+
+	if len(errs) != 0 {
+		klog.V(2).Infof("rejected %s %s/%s: %v", req.Kind.Kind, req.Namespace, req.Name, errs.ToAggregate())
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: errs.ToAggregate().Error(),
+		}
+	}
+
+	return resp
+}
+
+func (v *validator) validate(req *admissionv1.AdmissionRequest) (field.ErrorList, error) {
+	switch req.Kind {
+	case metav1.GroupVersionKind{Version: "v1", Kind: "PersistentVolume"}:
+		pv := &corev1.PersistentVolume{}
+		if err := json.Unmarshal(req.Object.Raw, pv); err != nil {
+			return nil, fmt.Errorf("could not decode PersistentVolume: %w", err)
+		}
+		return v.validatePersistentVolume(pv), nil
+
+	case metav1.GroupVersionKind{Group: "storage.k8s.io", Version: "v1", Kind: "StorageClass"}:
+		sc := &storagev1.StorageClass{}
+		if err := json.Unmarshal(req.Object.Raw, sc); err != nil {
+			return nil, fmt.Errorf("could not decode StorageClass: %w", err)
+		}
+		return v.validateStorageClass(sc), nil
+
+	case metav1.GroupVersionKind{Group: "storage.k8s.io", Version: "v1beta1", Kind: "VolumeAttributesClass"}:
+		vac := &storagev1beta1.VolumeAttributesClass{}
+		if err := json.Unmarshal(req.Object.Raw, vac); err != nil {
+			return nil, fmt.Errorf("could not decode VolumeAttributesClass: %w", err)
+		}
+		return v.validateVolumeAttributesClass(vac), nil
+
+	case metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}:
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+			return nil, fmt.Errorf("could not decode Pod: %w", err)
+		}
+		return v.validatePod(pod), nil
+	}
+
+	return nil, nil
+}
+
+// statusMessage returns the message of an error from the hpelustre package
+// without its gRPC code.
+func statusMessage(err error) string {
+	return status.Convert(err).Message()
+}
+
+func (v *validator) validatePersistentVolume(pv *corev1.PersistentVolume) field.ErrorList {
+	csi := pv.Spec.CSI
+	if csi == nil || csi.Driver != v.driverName {
+		return nil
+	}
+
+	errs := field.ErrorList{}
+	path := field.NewPath("spec", "csi")
+
+	if err := hpelustre.ValidateVolumeHandle(csi.VolumeHandle); err != nil {
+		errs = append(errs, field.Invalid(path.Child("volumeHandle"), csi.VolumeHandle, statusMessage(err)))
+	}
+	if err := hpelustre.ValidateVolumeAttributes(csi.VolumeHandle, csi.VolumeAttributes); err != nil {
+		errs = append(errs, field.Invalid(path.Child("volumeAttributes"), csi.VolumeAttributes, statusMessage(err)))
+	}
+	if err := hpelustre.ValidateMountOptions(pv.Spec.MountOptions); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("spec", "mountOptions"), pv.Spec.MountOptions, statusMessage(err)))
+	}
+
+	return errs
+}
+
+func (v *validator) validateStorageClass(sc *storagev1.StorageClass) field.ErrorList {
+	if sc.Provisioner != v.driverName {
+		return nil
+	}
+
+	errs := field.ErrorList{}
+	if err := hpelustre.ValidateStorageClassParameters(sc.Parameters); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("parameters"), sc.Parameters, statusMessage(err)))
+	}
+	if err := hpelustre.ValidateMountOptions(sc.MountOptions); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("mountOptions"), sc.MountOptions, statusMessage(err)))
+	}
+
+	return errs
+}
+
+func (v *validator) validateVolumeAttributesClass(vac *storagev1beta1.VolumeAttributesClass) field.ErrorList {
+	if vac.DriverName != v.driverName {
+		return nil
+	}
+
+	errs := field.ErrorList{}
+	if err := hpelustre.ValidateMutableParameters(vac.Parameters); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("parameters"), vac.Parameters, statusMessage(err)))
+	}
+
+	return errs
+}
+
+func (v *validator) validatePod(pod *corev1.Pod) field.ErrorList {
+	errs := field.ErrorList{}
+
+	for i, vol := range pod.Spec.Volumes {
+		csi := vol.CSI
+		if csi == nil || csi.Driver != v.driverName {
+			continue
+		}
+
+		path := field.NewPath("spec", "volumes").Index(i).Child("csi")
+		if err := hpelustre.ValidateEphemeralVolumeAttributes(csi.VolumeAttributes); err != nil {
+			errs = append(errs, field.Invalid(path.Child("volumeAttributes"), csi.VolumeAttributes, statusMessage(err)))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,757 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	storagev1beta1 "k8s.io/api/storage/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const testDriverName = "lustre-csi.hpe.com"
+
+var (
+	pvKind  = metav1.GroupVersionKind{Version: "v1", Kind: "PersistentVolume"}
+	podKind = metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	scKind  = metav1.GroupVersionKind{Group: "storage.k8s.io", Version: "v1", Kind: "StorageClass"}
+	vacKind = metav1.GroupVersionKind{Group: "storage.k8s.io", Version: "v1beta1", Kind: "VolumeAttributesClass"}
+)
+
+// objectRequest returns a request to admit the object, of the given kind.
+func objectRequest(t *testing.T, kind metav1.GroupVersionKind, obj any) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	require.NoError(t, err)
+
+	return &admissionv1.AdmissionRequest{
+		UID:    "1234",
+		Kind:   kind,
+		Object: runtime.RawExtension{Raw: raw},
+	}
+}
+
+// assertReview checks that the response allows the object, or rejects it with
+// a message that contains the given one.
+func assertReview(t *testing.T, resp *admissionv1.AdmissionResponse, allowed bool, message string) {
+	t.Helper()
+
+	assert.Equal(t, "1234", string(resp.UID))
+	assert.Equal(t, allowed, resp.Allowed)
+	if !allowed {
+		require.NotNil(t, resp.Result)
+		assert.Contains(t, resp.Result.Message, message)
+	}
+}
+
+func pvRequest(t *testing.T, handle string, attributes map[string]string, mountOptions []string) *admissionv1.AdmissionRequest {
+	return objectRequest(t, pvKind, &corev1.PersistentVolume{
+		Spec: corev1.PersistentVolumeSpec{
+			MountOptions: mountOptions,
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           testDriverName,
+					VolumeHandle:     handle,
+					VolumeAttributes: attributes,
+				},
+			},
+		},
+	})
+}
+
+// pvTest is a PV of the driver, and whether the webhook allows it, or the
+// message that it rejects it with.
+type pvTest struct {
+	desc         string
+	handle       string
+	attributes   map[string]string
+	mountOptions []string
+	allowed      bool
+	message      string
+}
+
+func testValidatePersistentVolumes(t *testing.T, tests []pvTest) {
+	v := &validator{driverName: testDriverName}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := v.review(pvRequest(t, test.handle, test.attributes, test.mountOptions))
+			assertReview(t, resp, test.allowed, test.message)
+		})
+	}
+}
+
+func TestValidatePersistentVolume(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:    "valid handle",
+			handle:  "10.1.1.113@tcp:/lushtx",
+			allowed: true,
+		},
+		{
+			desc:    "failover MGS with several NIDs",
+			handle:  "10.1.1.113@tcp,10.2.1.113@o2ib1:10.1.1.114@tcp,10.2.1.114@o2ib1:/lushtx",
+			allowed: true,
+		},
+		{
+			desc:    "missing filesystem",
+			handle:  "10.1.1.113@tcp",
+			message: "spec.csi.volumeHandle",
+		},
+		{
+			desc:    "NID without network",
+			handle:  "10.1.1.113:/lushtx",
+			message: "is not of the form <address>@<network>",
+		},
+		{
+			desc:    "unknown network",
+			handle:  "10.1.1.113@tpc:/lushtx",
+			message: `unknown network "tpc"`,
+		},
+		{
+			desc:    "bad address",
+			handle:  "10.1.1@tcp:/lushtx",
+			message: "does not have an IP or numeric address",
+		},
+		{
+			desc:    "filesystem name too long",
+			handle:  "10.1.1.113@tcp:/lustrefs1",
+			message: `filesystem name "lustrefs1"`,
+		},
+		{
+			desc:       "ephemeral-only attribute",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "scratch", "filesystem": "10.1.1.113@tcp:/lushtx"},
+			message:    "only supported for ephemeral volumes",
+		},
+	})
+}
+
+func TestValidatePersistentVolumeSubDir(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:       "valid sub-dir with variables",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "projects/${pvc.metadata.name}", "mode": "2770"},
+			allowed:    true,
+		},
+		{
+			desc:       "sub-dir with functions and labels",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "${node.labels.topology.kubernetes.io/zone}/${pvc.metadata.namespace|lower}/${pvc.metadata.name|hash:12}"},
+			allowed:    true,
+		},
+		{
+			desc:       "sub-dir with unknown variable",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "projects/${pvc.name}"},
+			message:    "unknown variable ${pvc.name}",
+		},
+		{
+			desc:       "sub-dir with unknown function",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "projects/${pvc.metadata.name|reverse}"},
+			message:    `unknown function "reverse"`,
+		},
+		{
+			desc:    "sub-dir in the handle",
+			handle:  "10.1.1.113@tcp:/lushtx#projects/solver",
+			allowed: true,
+		},
+		{
+			desc:       "sub-dir in the handle and the attributes",
+			handle:     "10.1.1.113@tcp:/lushtx#projects/solver",
+			attributes: map[string]string{"sub-dir": "projects/${pvc.metadata.name}"},
+			message:    "whose ID has a sub-dir",
+		},
+		{
+			desc:    "sub-dir in the handle escapes the filesystem",
+			handle:  "10.1.1.113@tcp:/lushtx#../solver",
+			message: "of the volume must be strict subpath",
+		},
+		{
+			desc:       "sub-dir escapes the filesystem",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "projects/../../etc"},
+			message:    "spec.csi.volumeAttributes",
+		},
+		{
+			desc:       "attribute without sub-dir",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"uid": "1000"},
+			message:    "requires a sub-dir",
+		},
+	})
+}
+
+func TestValidatePersistentVolumeSubDirOnUnpublish(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:       "sub-dir archived on unpublish",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "jobs/${pod.metadata.uid}", "sub-dir-on-unpublish": "archive", "sub-dir-archive": "archive/jobs"},
+			allowed:    true,
+		},
+		{
+			desc:       "archive without archive directory",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "jobs/${pod.metadata.uid}", "sub-dir-on-unpublish": "archive"},
+			message:    "sub-dir-archive is required",
+		},
+		{
+			desc:       "archive directory overlaps sub-dir",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "jobs/${pod.metadata.uid}", "sub-dir-on-unpublish": "archive", "sub-dir-archive": "jobs"},
+			message:    "must not overlap the sub-dir",
+		},
+		{
+			desc:       "archive directory escapes the filesystem",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"sub-dir": "jobs/${pod.metadata.uid}", "sub-dir-on-unpublish": "archive", "sub-dir-archive": "../archive"},
+			message:    "sub-dir-archive must be strict subpath",
+		},
+	})
+}
+
+func TestValidatePersistentVolumeHSM(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:       "restore on publish",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"hsm-on-publish": "prefetch", "hsm-on-publish-max-bytes": "10Gi"},
+			allowed:    true,
+		},
+		{
+			desc:       "unknown HSM publish mode",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"hsm-on-publish": "archive"},
+			message:    "hsm-on-publish",
+		},
+		{
+			desc:       "HSM publish limit without mode",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"hsm-on-publish-max-bytes": "10Gi"},
+			message:    "requires hsm-on-publish",
+		},
+	})
+}
+
+func TestValidatePersistentVolumePCC(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:       "read-only PCC caching of some files",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"pcc-mode": "ro", "pcc-backend": "nvme", "pcc-rule": "fname=*.h5,*.tfrecord"},
+			allowed:    true,
+		},
+		{
+			desc:       "PCC rule with unknown kind",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"pcc-mode": "rw", "pcc-rule": "uid=1000"},
+			message:    "pcc-rule",
+		},
+		{
+			desc:       "PCC backend without mode",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"pcc-backend": "nvme"},
+			message:    "require pcc-mode",
+		},
+	})
+}
+
+func TestValidatePersistentVolumePrefetch(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:       "prefetch of patterns and a manifest",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"prefetch-paths": "train/*.tfrecord, index", "prefetch-manifest": "prefetch.txt", "prefetch-wait": "true"},
+			allowed:    true,
+		},
+		{
+			desc:       "prefetch pattern outside the volume",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"prefetch-paths": "../*"},
+			message:    "must be strict subpath",
+		},
+		{
+			desc:       "prefetch wait without patterns",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"prefetch-wait": "true"},
+			message:    "requires prefetch-paths or prefetch-manifest",
+		},
+	})
+}
+
+func TestValidatePersistentVolumeClientTuning(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:       "client tuning",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"client-max-read-ahead-mb": "1024", "client-max-rpcs-in-flight": "32", "client-lazystatfs": "false"},
+			allowed:    true,
+		},
+		{
+			desc:       "unsupported client parameter",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"client-checksums": "0"},
+			message:    "not a supported client parameter",
+		},
+		{
+			desc:       "invalid client parameter",
+			handle:     "10.1.1.113@tcp:/lushtx",
+			attributes: map[string]string{"client-max-rpcs-in-flight": "0"},
+			message:    "client-max-rpcs-in-flight must be a number of at least 1",
+		},
+	})
+}
+
+func TestValidatePersistentVolumeSELinux(t *testing.T) {
+	testValidatePersistentVolumes(t, []pvTest{
+		{
+			desc:         "conflicting SELinux labels",
+			handle:       "10.1.1.113@tcp:/lushtx",
+			mountOptions: []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`, "context=system_u:object_r:container_file_t:s0"},
+			message:      "spec.mountOptions",
+		},
+	})
+}
+
+func TestValidateOtherDriver(t *testing.T) {
+	v := &validator{driverName: "other.csi.example.com"}
+	resp := v.review(pvRequest(t, "not a lustre handle", nil, nil))
+	assert.True(t, resp.Allowed)
+}
+
+func TestReview(t *testing.T) {
+	lustreVolume := func(name string, attributes map[string]string) corev1.Volume {
+		return corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				CSI: &corev1.CSIVolumeSource{Driver: testDriverName, VolumeAttributes: attributes},
+			},
+		}
+	}
+	valid := map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx", "sub-dir": "scratch/${pod.metadata.uid}"}
+
+	tests := []struct {
+		desc    string
+		req     *admissionv1.AdmissionRequest
+		allowed bool
+		code    int32
+		message string
+	}{
+		{
+			desc:    "undecodable object",
+			req:     &admissionv1.AdmissionRequest{UID: "1234", Kind: pvKind, Object: runtime.RawExtension{Raw: []byte("{")}},
+			code:    http.StatusBadRequest,
+			message: "could not decode PersistentVolume",
+		},
+		{
+			desc:    "other kind",
+			req:     objectRequest(t, metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, &corev1.ConfigMap{}),
+			allowed: true,
+		},
+		{
+			desc:    "PV without CSI",
+			req:     objectRequest(t, pvKind, &corev1.PersistentVolume{}),
+			allowed: true,
+		},
+		{
+			desc:    "StorageClass of another provisioner",
+			req:     objectRequest(t, scKind, &storagev1.StorageClass{Provisioner: "other.csi.example.com"}),
+			allowed: true,
+		},
+		{
+			desc: "VolumeAttributesClass of another driver",
+			req: objectRequest(t, vacKind, &storagev1beta1.VolumeAttributesClass{
+				DriverName: "other.csi.example.com",
+				Parameters: map[string]string{"parent-dir": "volumes"},
+			}),
+			allowed: true,
+		},
+		{
+			desc: "pod without Lustre volumes",
+			req: objectRequest(t, podKind, &corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "other", VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{Driver: "other.csi.example.com"}}},
+			}}}),
+			allowed: true,
+		},
+		{
+			desc: "invalid second Lustre volume of a pod",
+			req: objectRequest(t, podKind, &corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				lustreVolume("scratch", valid),
+				lustreVolume("shared", map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx", "sub-dir": "shared"}),
+			}}}),
+			code:    http.StatusUnprocessableEntity,
+			message: "spec.volumes[1].csi.volumeAttributes",
+		},
+		{
+			desc:    "all errors of a PV",
+			req:     pvRequest(t, "10.1.1.113@tpc:/lushtx", map[string]string{"uid": "1000"}, []string{"context=bad"}),
+			code:    http.StatusUnprocessableEntity,
+			message: "[spec.csi.volumeHandle: ",
+		},
+	}
+
+	v := &validator{driverName: testDriverName}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := v.review(test.req)
+			assertReview(t, resp, test.allowed, test.message)
+			if !test.allowed {
+				assert.Equal(t, test.code, resp.Result.Code)
+			}
+		})
+	}
+}
+
+func TestValidatePodInlineVolume(t *testing.T) {
+	tests := []struct {
+		desc       string
+		attributes map[string]string
+		allowed    bool
+		message    string
+	}{
+		{
+			desc:       "valid",
+			attributes: map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx", "sub-dir": "scratch/${pod.metadata.uid}"},
+			allowed:    true,
+		},
+		{
+			desc:       "retained",
+			attributes: map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx", "sub-dir": "scratch/${pod.metadata.uid}", "sub-dir-on-unpublish": "retain"},
+			allowed:    true,
+		},
+		{
+			desc:       "missing filesystem",
+			attributes: map[string]string{"sub-dir": "scratch/${pod.metadata.uid}"},
+			message:    "requires context filesystem",
+		},
+		{
+			desc:       "bad filesystem",
+			attributes: map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx..", "sub-dir": "scratch/${pod.metadata.uid}"},
+			message:    `filesystem name "lushtx.."`,
+		},
+		{
+			desc:       "sub-dir shared between pods",
+			attributes: map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx", "sub-dir": "scratch"},
+			message:    "so that each pod has its own directory",
+		},
+		{
+			desc:       "sub-dir escapes the filesystem",
+			attributes: map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx", "sub-dir": "../${pod.metadata.uid}"},
+			message:    "spec.volumes[0].csi.volumeAttributes",
+		},
+		{
+			desc:       "unknown sub-dir-on-unpublish",
+			attributes: map[string]string{"filesystem": "10.1.1.113@tcp:/lushtx", "sub-dir": "${pod.metadata.uid}", "sub-dir-on-unpublish": "keep"},
+			message:    "sub-dir-on-unpublish",
+		},
+	}
+
+	v := &validator{driverName: testDriverName}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := v.review(objectRequest(t, podKind, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{
+						{
+							Name: "scratch",
+							VolumeSource: corev1.VolumeSource{
+								CSI: &corev1.CSIVolumeSource{
+									Driver:           testDriverName,
+									VolumeAttributes: test.attributes,
+								},
+							},
+						},
+					},
+				},
+			}))
+			assertReview(t, resp, test.allowed, test.message)
+		})
+	}
+}
+
+type storageClassTest struct {
+	desc         string
+	parameters   map[string]string
+	mountOptions []string
+	allowed      bool
+	message      string
+}
+
+func testValidateStorageClasses(t *testing.T, tests []storageClassTest) {
+	v := &validator{driverName: testDriverName}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := v.review(objectRequest(t, scKind, &storagev1.StorageClass{
+				Provisioner:  testDriverName,
+				Parameters:   test.parameters,
+				MountOptions: test.mountOptions,
+			}))
+			assertReview(t, resp, test.allowed, test.message)
+		})
+	}
+}
+
+func TestValidateStorageClass(t *testing.T) {
+	testValidateStorageClasses(t, []storageClassTest{
+		{
+			desc: "valid",
+			parameters: map[string]string{
+				"filesystem":                "10.0.0.1@tcp:/lustre",
+				"parent-dir":                "volumes/team",
+				"ost-pool":                  "flash",
+				"hsm-on-publish":            "restore",
+				"csi.storage.k8s.io/fstype": "lustre",
+			},
+			allowed: true,
+		},
+		{
+			desc:         "bad mount option",
+			parameters:   map[string]string{"filesystem": "10.0.0.1@tcp:/lustre"},
+			mountOptions: []string{"context=bad"},
+			message:      "mountOptions",
+		},
+		{
+			desc:    "missing filesystem",
+			message: "parameters",
+		},
+		{
+			desc: "filesystem with sub-dir",
+			parameters: map[string]string{
+				"filesystem": "10.0.0.1@tcp:/lustre#data",
+			},
+			message: "parameters",
+		},
+		{
+			desc: "parent dir escapes",
+			parameters: map[string]string{
+				"filesystem": "10.0.0.1@tcp:/lustre",
+				"parent-dir": "../volumes",
+			},
+			message: "parameters",
+		},
+		{
+			desc: "invalid OST pool",
+			parameters: map[string]string{
+				"filesystem": "10.0.0.1@tcp:/lustre",
+				"ost-pool":   "lushtx.flash",
+			},
+			message: "parameters",
+		},
+		{
+			desc: "unknown parameter",
+			parameters: map[string]string{
+				"filesystem": "10.0.0.1@tcp:/lustre",
+				"size":       "10Gi",
+			},
+			message: "parameters",
+		},
+	})
+}
+
+func TestValidateStorageClassMirror(t *testing.T) {
+	testValidateStorageClasses(t, []storageClassTest{
+		{
+			desc: "mirrored across OST pools",
+			parameters: map[string]string{
+				"filesystem":   "10.0.0.1@tcp:/lustre",
+				"mirror-pools": "flash, disk",
+			},
+			allowed: true,
+		},
+		{
+			desc: "mirrored across one OST pool",
+			parameters: map[string]string{
+				"filesystem":   "10.0.0.1@tcp:/lustre",
+				"mirror-pools": "flash",
+			},
+			message: "parameters",
+		},
+		{
+			desc: "mirrored and in an OST pool",
+			parameters: map[string]string{
+				"filesystem":   "10.0.0.1@tcp:/lustre",
+				"ost-pool":     "flash",
+				"mirror-pools": "flash,disk",
+			},
+			message: "parameters",
+		},
+	})
+}
+
+func TestValidateStorageClassHSM(t *testing.T) {
+	testValidateStorageClasses(t, []storageClassTest{
+		{
+			desc: "invalid HSM publish limit",
+			parameters: map[string]string{
+				"filesystem":               "10.0.0.1@tcp:/lustre",
+				"hsm-on-publish":           "restore",
+				"hsm-on-publish-max-bytes": "0",
+			},
+			message: "parameters",
+		},
+	})
+}
+
+type volumeAttributesClassTest struct {
+	desc       string
+	parameters map[string]string
+	allowed    bool
+	message    string
+}
+
+func testValidateVolumeAttributesClasses(t *testing.T, tests []volumeAttributesClassTest) {
+	v := &validator{driverName: testDriverName}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp := v.review(objectRequest(t, vacKind, &storagev1beta1.VolumeAttributesClass{
+				DriverName: testDriverName,
+				Parameters: test.parameters,
+			}))
+			assertReview(t, resp, test.allowed, test.message)
+		})
+	}
+}
+
+func TestValidateVolumeAttributesClass(t *testing.T) {
+	testValidateVolumeAttributesClasses(t, []volumeAttributesClassTest{
+		{
+			desc: "valid",
+			parameters: map[string]string{
+				"stripe-count": "-1",
+				"stripe-size":  "4M",
+				"ost-pool":     "flash",
+				"inode-limit":  "1000000",
+				"migrate":      "true",
+			},
+			allowed: true,
+		},
+		{
+			desc:       "stripe size not a multiple of 64K",
+			parameters: map[string]string{"stripe-size": "100K"},
+			message:    "64K",
+		},
+		{
+			desc:       "negative inode limit",
+			parameters: map[string]string{"inode-limit": "-1"},
+			message:    "inode-limit",
+		},
+		{
+			desc:       "migrate without a layout",
+			parameters: map[string]string{"migrate": "true", "inode-limit": "10"},
+			message:    "migrate",
+		},
+		{
+			desc:       "StorageClass parameter",
+			parameters: map[string]string{"parent-dir": "volumes"},
+			message:    "parent-dir",
+		},
+	})
+}
+
+func TestValidateVolumeAttributesClassHSM(t *testing.T) {
+	testValidateVolumeAttributesClasses(t, []volumeAttributesClassTest{
+		{
+			desc:       "archive on delete",
+			parameters: map[string]string{"hsm-on-delete": "archive", "hsm-archive-id": "2"},
+			allowed:    true,
+		},
+		{
+			desc:       "unknown HSM delete policy",
+			parameters: map[string]string{"hsm-on-delete": "release"},
+			message:    "hsm-on-delete",
+		},
+		{
+			desc:       "archive ID without archive on delete",
+			parameters: map[string]string{"hsm-on-delete": "remove", "hsm-archive-id": "2"},
+			message:    "hsm-archive-id",
+		},
+	})
+}
+
+func TestServeHTTP(t *testing.T) {
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  pvRequest(t, "10.1.1.113@tcp:/lushtx", nil, nil),
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	v := &validator{driverName: testDriverName}
+	v.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	result := &admissionv1.AdmissionReview{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+	assert.Equal(t, "AdmissionReview", result.Kind)
+	assert.Nil(t, result.Request)
+	require.NotNil(t, result.Response)
+	assert.True(t, result.Response.Allowed)
+	assert.Equal(t, "1234", string(result.Response.UID))
+}
+
+func TestServeHTTPBadRequests(t *testing.T) {
+	tests := []struct {
+		desc   string
+		method string
+		body   string
+		code   int
+	}{
+		{
+			desc:   "not a POST",
+			method: http.MethodGet,
+			code:   http.StatusMethodNotAllowed,
+		},
+		{
+			desc:   "not JSON",
+			method: http.MethodPost,
+			body:   "review",
+			code:   http.StatusBadRequest,
+		},
+		{
+			desc:   "review without a request",
+			method: http.MethodPost,
+			body:   `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			code:   http.StatusBadRequest,
+		},
+	}
+
+	v := &validator{driverName: testDriverName}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			v.ServeHTTP(w, httptest.NewRequest(test.method, "/validate", strings.NewReader(test.body)))
+			assert.Equal(t, test.code, w.Code)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tags and layout of the POSIX ACL extended attribute value, from the
+// kernel's include/uapi/linux/posix_acl_xattr.h.
+const (
+	aclXattrVersion = 0x0002
+
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	aclUndefinedID = math.MaxUint32
+)
+
+// DefaultACLXattr is the extended attribute that holds a directory's default ACL
+const DefaultACLXattr = "system.posix_acl_default"
+
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// ParseACL parses an ACL in the short text form accepted by setfacl(1), such as
+// "user::rwx,user:1000:rwx,group::r-x,other::---", and returns it encoded as a
+// POSIX ACL extended attribute value. Named users and groups must be numeric
+// IDs. If the ACL has named entries and no mask, a mask is computed the same
+// way setfacl does.
+func ParseACL(acl string) ([]byte, error) {
+	entries := []aclEntry{}
+	seen := map[aclEntry]bool{}
+
+	for _, field := range strings.Split(acl, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+
+		entry, err := parseACLEntry(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACL entry %q: %w", field, err)
+		}
+
+		key := aclEntry{tag: entry.tag, id: entry.id}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate ACL entry %q", field)
+		}
+		seen[key] = true
+
+		entries = append(entries, entry)
+	}
+
+	for _, required := range []uint16{aclUserObj, aclGroupObj, aclOther} {
+		if !seen[aclEntry{tag: required, id: aclUndefinedID}] {
+			return nil, fmt.Errorf("ACL %q must have user::, group:: and other:: entries", acl)
+		}
+	}
+
+	// The mask bounds the permissions of the group class. It is required if
+	// there are named entries.
+	hasNamed := false
+	groupClass := uint16(0)
+	for _, e := range entries {
+		switch e.tag {
+		case aclUser, aclGroup:
+			hasNamed = true
+			groupClass |= e.perm
+		case aclGroupObj:
+			groupClass |= e.perm
+		}
+	}
+	if hasNamed && !seen[aclEntry{tag: aclMask, id: aclUndefinedID}] {
+		entries = append(entries, aclEntry{tag: aclMask, perm: groupClass, id: aclUndefinedID})
+	}
+
+	// The kernel only accepts entries ordered by tag, then by ID.
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	buf := make([]byte, 4, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(buf, aclXattrVersion)
+	for _, e := range entries {
+		buf = binary.LittleEndian.AppendUint16(buf, e.tag)
+		buf = binary.LittleEndian.AppendUint16(buf, e.perm)
+		buf = binary.LittleEndian.AppendUint32(buf, e.id)
+	}
+
+	return buf, nil
+}
+
+func parseACLEntry(field string) (aclEntry, error) {
+	parts := strings.Split(field, ":")
+	if len(parts) != 3 {
+		return aclEntry{}, fmt.Errorf("expected <type>:<id>:<perms>")
+	}
+
+	entry := aclEntry{id: aclUndefinedID}
+	named := len(parts[1]) != 0
+
+	switch parts[0] {
+	case "u", "user":
+		entry.tag = aclUserObj
+		if named {
+			entry.tag = aclUser
+		}
+	case "g", "group":
+		entry.tag = aclGroupObj
+		if named {
+			entry.tag = aclGroup
+		}
+	case "m", "mask":
+		entry.tag = aclMask
+	case "o", "other":
+		entry.tag = aclOther
+	default:
+		return aclEntry{}, fmt.Errorf("unknown type %q", parts[0])
+	}
+
+	if named {
+		if entry.tag != aclUser && entry.tag != aclGroup {
+			return aclEntry{}, fmt.Errorf("type %q does not take an ID", parts[0])
+		}
+		id, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil || id == aclUndefinedID {
+			return aclEntry{}, fmt.Errorf("ID %q is not a numeric user or group ID", parts[1])
+		}
+		entry.id = uint32(id)
+	}
+
+	perm, err := parseACLPerm(parts[2])
+	if err != nil {
+		return aclEntry{}, err
+	}
+	entry.perm = perm
+
+	return entry, nil
+}
+
+func parseACLPerm(perms string) (uint16, error) {
+	if len(perms) == 0 || len(perms) > 3 {
+		return 0, fmt.Errorf("invalid permissions %q", perms)
+	}
+
+	perm := uint16(0)
+	for _, c := range perms {
+		bit := uint16(0)
+		switch c {
+		case 'r':
+			bit = 4
+		case 'w':
+			bit = 2
+		case 'x':
+			bit = 1
+		case '-':
+			continue
+		default:
+			return 0, fmt.Errorf("invalid permissions %q", perms)
+		}
+		if perm&bit != 0 {
+			return 0, fmt.Errorf("invalid permissions %q", perms)
+		}
+		perm |= bit
+	}
+
+	return perm, nil
+}
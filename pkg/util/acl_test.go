@@ -0,0 +1,126 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseACL(t *testing.T) {
+	header := []byte{0x02, 0x00, 0x00, 0x00}
+	undefined := []byte{0xff, 0xff, 0xff, 0xff}
+
+	entry := func(tag, perm byte, id []byte) []byte {
+		return append([]byte{tag, 0x00, perm, 0x00}, id...)
+	}
+	acl := func(entries ...[]byte) []byte {
+		b := append([]byte{}, header...)
+		for _, e := range entries {
+			b = append(b, e...)
+		}
+		return b
+	}
+
+	tests := []struct {
+		desc     string
+		acl      string
+		expected []byte
+	}{
+		{
+			desc: "minimal ACL",
+			acl:  "user::rwx,group::r-x,other::---",
+			expected: acl(
+				entry(aclUserObj, 7, undefined),
+				entry(aclGroupObj, 5, undefined),
+				entry(aclOther, 0, undefined),
+			),
+		},
+		{
+			desc: "short tags, out of order",
+			acl:  "o::r,g::rx,u::rw",
+			expected: acl(
+				entry(aclUserObj, 6, undefined),
+				entry(aclGroupObj, 5, undefined),
+				entry(aclOther, 4, undefined),
+			),
+		},
+		{
+			desc: "named entries are sorted by ID and get a computed mask",
+			acl:  "user::rwx,user:2000:r--,user:1000:rw-,group::r--,group:100:--x,other::---",
+			expected: acl(
+				entry(aclUserObj, 7, undefined),
+				entry(aclUser, 6, []byte{0xe8, 0x03, 0x00, 0x00}),
+				entry(aclUser, 4, []byte{0xd0, 0x07, 0x00, 0x00}),
+				entry(aclGroupObj, 4, undefined),
+				entry(aclGroup, 1, []byte{0x64, 0x00, 0x00, 0x00}),
+				entry(aclMask, 7, undefined),
+				entry(aclOther, 0, undefined),
+			),
+		},
+		{
+			desc: "explicit mask is kept",
+			acl:  "user::rwx,user:1000:rwx,group::r-x,mask::r-x,other::---",
+			expected: acl(
+				entry(aclUserObj, 7, undefined),
+				entry(aclUser, 7, []byte{0xe8, 0x03, 0x00, 0x00}),
+				entry(aclGroupObj, 5, undefined),
+				entry(aclMask, 5, undefined),
+				entry(aclOther, 0, undefined),
+			),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			result, err := ParseACL(test.acl)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestParseACLInvalid(t *testing.T) {
+	tests := []struct {
+		desc string
+		acl  string
+	}{
+		{desc: "empty", acl: ""},
+		{desc: "missing other", acl: "user::rwx,group::r-x"},
+		{desc: "unknown type", acl: "user::rwx,group::r-x,other::---,world::r"},
+		{desc: "too few fields", acl: "user::rwx,group:r-x,other::---"},
+		{desc: "user name", acl: "user::rwx,user:alice:rwx,group::r-x,other::---"},
+		{desc: "negative ID", acl: "user::rwx,user:-1:rwx,group::r-x,other::---"},
+		{desc: "mask with ID", acl: "user::rwx,group::r-x,mask:1:rwx,other::---"},
+		{desc: "bad permission", acl: "user::rwz,group::r-x,other::---"},
+		{desc: "repeated permission", acl: "user::rr,group::r-x,other::---"},
+		{desc: "duplicate entry", acl: "user::rwx,user::r,group::r-x,other::---"},
+		{desc: "duplicate named entry", acl: "user::rwx,user:1:r,user:1:w,group::r-x,other::---"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := ParseACL(test.acl)
+			require.Error(t, err)
+		})
+	}
+}
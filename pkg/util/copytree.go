@@ -0,0 +1,494 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// LustreLayoutXattr is the extended attribute that holds the layout of a Lustre
+// file, or the default layout of a directory. It must be set on a file before
+// any data is written to it.
+const LustreLayoutXattr = "lustre.lov"
+
+// OriginalModeXattr holds the permissions that a read-only copy had before its
+// write permission was removed, in octal.
+const OriginalModeXattr = "trusted.lustre-csi.mode"
+
+// Extended attributes that are copied along with the layout. Lustre's own
+// trusted.* attributes describe the file on the servers and are not copied.
+var copiedXattrPrefixes = []string{"user.", "security.", "system.posix_acl_"}
+
+// CopyTreeOptions control CopyTree.
+type CopyTreeOptions struct {
+	// Number of files that are copied at the same time
+	Workers int
+	// Remove write permission from everything that is copied, recording the
+	// original permissions in OriginalModeXattr
+	ReadOnly bool
+	// Give copies the permissions recorded in OriginalModeXattr of a
+	// read-only copy, rather than its own
+	RestoreMode bool
+	// Continue an earlier copy to dst that did not complete. Files that were
+	// already copied, with the same size and modification time as the
+	// source, are not copied again.
+	Resume bool
+	// Give copies the default Lustre layout of the directory that they are
+	// created in, rather than the layout of the source
+	InheritLayout bool
+}
+
+type copiedDir struct {
+	src  string
+	dst  string
+	stat *unix.Stat_t
+}
+
+// CopyTree copies the directory tree at src to dst, which must not exist
+// unless the copy is resumed, preserving the Lustre layout, extended
+// attributes, ownership, permissions and times of everything in it. Files are
+// copied in parallel by options.Workers workers. Hard links are copied as
+// separate files, and sockets are skipped. It returns the number of bytes of
+// file data in the copy.
+func CopyTree(ctx context.Context, src, dst string, options CopyTreeOptions) (int64, error) {
+	workers := max(options.Workers, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var size atomic.Int64
+	var firstErr error
+	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	type job struct {
+		src  string
+		dst  string
+		stat *unix.Stat_t
+	}
+	jobs := make(chan job)
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				n, err := copyFile(ctx, j.src, j.dst, j.stat, options)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				size.Add(n)
+			}
+		}()
+	}
+
+	// Directories are created writable, and given their own permissions and
+	// times once everything in them has been copied.
+	dirs := []copiedDir{}
+	walkErr := filepath.WalkDir(src, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		stat := &unix.Stat_t{}
+		if err := unix.Lstat(path, stat); err != nil {
+			return fmt.Errorf("could not stat %q: %w", path, err)
+		}
+
+		switch stat.Mode & unix.S_IFMT {
+		case unix.S_IFDIR:
+			if err := copyDir(path, target, options); err != nil {
+				return err
+			}
+			dirs = append(dirs, copiedDir{src: path, dst: target, stat: stat})
+		case unix.S_IFREG:
+			select {
+			case jobs <- job{src: path, dst: target, stat: stat}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case unix.S_IFLNK:
+			if err := copySymlink(path, target, stat, options); err != nil {
+				return err
+			}
+		case unix.S_IFSOCK:
+			return nil
+		default:
+			if err := copySpecialFile(path, target, stat, options); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	if walkErr != nil {
+		return 0, walkErr
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		if err := copyMetadata(dir.src, dir.dst, dir.stat, options, true); err != nil {
+			return 0, err
+		}
+	}
+
+	return size.Load(), nil
+}
+
+func copyDir(src, dst string, options CopyTreeOptions) error {
+	if err := os.Mkdir(dst, 0o700); err != nil {
+		info, statErr := os.Lstat(dst)
+		if !options.Resume || !os.IsExist(err) || statErr != nil || !info.IsDir() {
+			return fmt.Errorf("could not create directory %q: %w", dst, err)
+		}
+		if err := os.Chmod(dst, 0o700); err != nil {
+			return fmt.Errorf("could not change mode of %q: %w", dst, err)
+		}
+	}
+
+	// The default layout of the directory is set before anything is created
+	// in it.
+	return copyXattrs(src, dst, !options.InheritLayout)
+}
+
+func copyFile(ctx context.Context, src, dst string, stat *unix.Stat_t, options CopyTreeOptions) (int64, error) {
+	if options.Resume {
+		copied, err := isCopied(dst, stat)
+		if err != nil || copied {
+			return stat.Size, err
+		}
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("could not open %q: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("could not create %q: %w", dst, err)
+	}
+	defer out.Close()
+
+	if !options.InheritLayout {
+		if err := copyLayout(src, dst); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.Copy(out, contextReader{ctx: ctx, r: in})
+	if err != nil {
+		return 0, fmt.Errorf("could not copy %q to %q: %w", src, dst, err)
+	}
+
+	if err := copyXattrs(src, dst, false); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, fmt.Errorf("could not write %q: %w", dst, err)
+	}
+
+	return n, copyMetadata(src, dst, stat, options, true)
+}
+
+// isCopied reports whether an earlier copy of a file completed, and otherwise
+// removes what there is of it.
+func isCopied(dst string, stat *unix.Stat_t) (bool, error) {
+	existing := unix.Stat_t{}
+	if err := unix.Lstat(dst, &existing); errors.Is(err, unix.ENOENT) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not stat %q: %w", dst, err)
+	}
+
+	if existing.Mode&unix.S_IFMT == unix.S_IFREG && existing.Size == stat.Size && existing.Mtim == stat.Mtim {
+		return true, nil
+	}
+
+	if err := os.RemoveAll(dst); err != nil {
+		return false, fmt.Errorf("could not remove %q: %w", dst, err)
+	}
+	return false, nil
+}
+
+// removeForResume removes anything at dst that an earlier copy left behind.
+func removeForResume(dst string, resume bool) error {
+	if !resume {
+		return nil
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("could not remove %q: %w", dst, err)
+	}
+	return nil
+}
+
+// contextReader stops a copy when its context is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func copySymlink(src, dst string, stat *unix.Stat_t, options CopyTreeOptions) error {
+	link, err := os.Readlink(src)
+	if err != nil {
+		return fmt.Errorf("could not read symlink %q: %w", src, err)
+	}
+	if err := removeForResume(dst, options.Resume); err != nil {
+		return err
+	}
+	if err := os.Symlink(link, dst); err != nil {
+		return fmt.Errorf("could not create symlink %q: %w", dst, err)
+	}
+	if err := copyXattrs(src, dst, false); err != nil {
+		return err
+	}
+
+	return copyMetadata(src, dst, stat, options, false)
+}
+
+func copySpecialFile(src, dst string, stat *unix.Stat_t, options CopyTreeOptions) error {
+	if err := removeForResume(dst, options.Resume); err != nil {
+		return err
+	}
+	if err := unix.Mknod(dst, stat.Mode&^0o7777|0o600, int(stat.Rdev)); err != nil {
+		return fmt.Errorf("could not create %q: %w", dst, err)
+	}
+	if err := copyXattrs(src, dst, false); err != nil {
+		return err
+	}
+
+	return copyMetadata(src, dst, stat, options, true)
+}
+
+// copyLayout gives an empty file the Lustre layout of the source file. It does
+// nothing for files that are not on Lustre.
+func copyLayout(src, dst string) error {
+	value, err := getXattr(src, LustreLayoutXattr)
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not get layout of %q: %w", src, err)
+	}
+
+	if err := unix.Lsetxattr(dst, LustreLayoutXattr, value, 0); err != nil && !errors.Is(err, unix.ENOTSUP) {
+		return fmt.Errorf("could not set layout of %q: %w", dst, err)
+	}
+
+	return nil
+}
+
+// copyXattrs copies the extended attributes of src to dst, along with the
+// Lustre layout of a directory if layout is set.
+func copyXattrs(src, dst string, layout bool) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return fmt.Errorf("could not list extended attributes of %q: %w", src, err)
+	}
+
+	for _, name := range names {
+		copied := layout && name == LustreLayoutXattr
+		for _, prefix := range copiedXattrPrefixes {
+			copied = copied || strings.HasPrefix(name, prefix)
+		}
+		if !copied {
+			continue
+		}
+
+		value, err := getXattr(src, name)
+		if errors.Is(err, unix.ENODATA) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not get extended attribute %s of %q: %w", name, src, err)
+		}
+
+		if err := unix.Lsetxattr(dst, name, value, 0); err != nil {
+			return fmt.Errorf("could not set extended attribute %s of %q: %w", name, dst, err)
+		}
+	}
+
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	} else if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if len(name) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+// copyMetadata gives dst the ownership, permissions and times of the source.
+// The permissions are set after the ownership, since changing the owner clears
+// the setuid and setgid bits.
+func copyMetadata(src, dst string, stat *unix.Stat_t, options CopyTreeOptions, setMode bool) error {
+	if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+		return fmt.Errorf("could not change owner of %q: %w", dst, err)
+	}
+
+	if setMode {
+		mode := stat.Mode & 0o7777
+		if options.RestoreMode {
+			original, err := getOriginalMode(src)
+			if err != nil {
+				return err
+			}
+			mode = cmp.Or(original, mode)
+		}
+		if options.ReadOnly {
+			value := []byte(strconv.FormatUint(uint64(mode), 8))
+			if err := unix.Lsetxattr(dst, OriginalModeXattr, value, 0); err != nil {
+				return fmt.Errorf("could not record mode of %q: %w", dst, err)
+			}
+			mode &^= 0o222
+		}
+		if err := unix.Chmod(dst, mode); err != nil {
+			return fmt.Errorf("could not change mode of %q: %w", dst, err)
+		}
+	}
+
+	times := []unix.Timespec{stat.Atim, stat.Mtim}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("could not set times of %q: %w", dst, err)
+	}
+
+	return nil
+}
+
+// getOriginalMode returns the permissions recorded in OriginalModeXattr, or 0
+// if there are none.
+func getOriginalMode(path string) (uint32, error) {
+	value, err := getXattr(path, OriginalModeXattr)
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("could not get original mode of %q: %w", path, err)
+	}
+
+	mode, err := strconv.ParseUint(string(value), 8, 32)
+	if err != nil || mode&^0o7777 != 0 {
+		return 0, fmt.Errorf("invalid original mode %q of %q", value, path)
+	}
+	return uint32(mode), nil
+}
+
+// ChangedSince returns the path of something in the directory tree at root
+// whose inode changed at or after the given time, or an empty string if
+// nothing did. Creating, removing or renaming an entry changes its directory,
+// so a tree that is unchanged since a copy of it started was copied
+// consistently.
+func ChangedSince(ctx context.Context, root string, since time.Time) (string, error) {
+	changed := ""
+	err := filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		stat := unix.Stat_t{}
+		if err := unix.Lstat(path, &stat); err != nil {
+			return fmt.Errorf("could not stat %q: %w", path, err)
+		}
+		if !time.Unix(stat.Ctim.Unix()).Before(since) {
+			changed = path
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "a", "b"), 0o755))
+	for i := range 20 {
+		data := []byte(fmt.Sprintf("file %d", i))
+		require.NoError(t, os.WriteFile(filepath.Join(src, "a", fmt.Sprintf("f%d", i)), data, 0o640))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a", "b", "exec"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.Symlink("../a/f1", filepath.Join(src, "a", "b", "link")))
+	require.NoError(t, os.Chmod(filepath.Join(src, "a", "b"), 0o750))
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(src, "a", "f0"), mtime, mtime))
+
+	xattrs := unix.Setxattr(filepath.Join(src, "a", "f0"), "user.project", []byte("solver"), 0) == nil
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	size, err := CopyTree(context.Background(), src, dst, CopyTreeOptions{Workers: 4})
+	require.NoError(t, err)
+	assert.Equal(t, int64(20*len("file 0")+10+len("#!/bin/sh\n")), size)
+
+	data, err := os.ReadFile(filepath.Join(dst, "a", "f13"))
+	require.NoError(t, err)
+	assert.Equal(t, "file 13", string(data))
+
+	info, err := os.Stat(filepath.Join(dst, "a", "f0"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+	assert.True(t, mtime.Equal(info.ModTime()))
+
+	info, err = os.Stat(filepath.Join(dst, "a", "b"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+
+	link, err := os.Readlink(filepath.Join(dst, "a", "b", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "../a/f1", link)
+
+	if xattrs {
+		value, err := getXattr(filepath.Join(dst, "a", "f0"), "user.project")
+		require.NoError(t, err)
+		assert.Equal(t, "solver", string(value))
+	}
+}
+
+func TestCopyTreeReadOnly(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("recording the original mode of a read-only copy requires root")
+	}
+
+	src := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(src, "dir"), 0o755))
+	require.NoError(t, os.Chmod(filepath.Join(src, "dir"), 0o775|os.ModeSetgid))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "dir", "data"), []byte("data"), 0o644))
+	require.NoError(t, os.Chmod(filepath.Join(src, "dir", "data"), 0o664))
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot")
+	_, err := CopyTree(context.Background(), src, snapshot, CopyTreeOptions{Workers: 2, ReadOnly: true})
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(snapshot, "dir", "data"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o444), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(snapshot, "dir"))
+	require.NoError(t, err)
+	assert.Zero(t, info.Mode().Perm()&0o222)
+
+	restored := filepath.Join(t.TempDir(), "restored")
+	_, err = CopyTree(context.Background(), snapshot, restored, CopyTreeOptions{Workers: 2, RestoreMode: true})
+	require.NoError(t, err)
+
+	info, err = os.Stat(filepath.Join(restored, "dir", "data"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o664), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(restored, "dir"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o775), info.Mode().Perm())
+	assert.NotZero(t, info.Mode()&os.ModeSetgid)
+
+	_, err = getXattr(filepath.Join(restored, "dir", "data"), OriginalModeXattr)
+	assert.ErrorIs(t, err, unix.ENODATA)
+}
+
+func TestCopyTreeResume(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(src, "dir"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "dir", "copied"), []byte("copied"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "dir", "partial"), []byte("partial"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "missing"), []byte("missing"), 0o644))
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	_, err := CopyTree(context.Background(), src, dst, CopyTreeOptions{})
+	require.NoError(t, err)
+
+	// Make it look like the copy was interrupted.
+	require.NoError(t, os.WriteFile(filepath.Join(dst, "dir", "partial"), []byte("par"), 0o600))
+	require.NoError(t, os.Remove(filepath.Join(dst, "missing")))
+	stale := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dst, "dir", "copied"), stale, stale))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "dir", "copied"), []byte("changed"), 0o644))
+
+	_, err = CopyTree(context.Background(), src, dst, CopyTreeOptions{})
+	require.Error(t, err)
+
+	size, err := CopyTree(context.Background(), src, dst, CopyTreeOptions{Resume: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("changed")+len("partial")+len("missing")), size)
+
+	for _, name := range []string{"dir/copied", "dir/partial", "missing"} {
+		expected, err := os.ReadFile(filepath.Join(src, name))
+		require.NoError(t, err)
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(data), name)
+	}
+}
+
+func TestCopyTreeExistingDestination(t *testing.T) {
+	_, err := CopyTree(context.Background(), t.TempDir(), t.TempDir(), CopyTreeOptions{})
+	require.Error(t, err)
+}
+
+func TestCopyTreeCancelled(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(src, "data"), []byte("data"), 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := CopyTree(ctx, src, filepath.Join(t.TempDir(), "dst"), CopyTreeOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestChangedSince(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "dir"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "dir/data"), []byte("data"), 0o644))
+
+	changed, err := ChangedSince(context.Background(), src, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	changed, err = ChangedSince(context.Background(), src, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, src, changed)
+
+	since := time.Now().Add(10 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, os.Chmod(filepath.Join(src, "dir/data"), 0o600))
+	changed, err = ChangedSince(context.Background(), src, since)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(src, "dir/data"), changed)
+}
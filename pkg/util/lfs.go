@@ -0,0 +1,328 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LfsDfSummary is the summary line of the output of "lfs df", for the whole
+// filesystem or for the OSTs of a pool. With "lfs df -i" the counts are
+// inodes, otherwise they are KiB.
+type LfsDfSummary struct {
+	Total     int64
+	Used      int64
+	Available int64
+}
+
+// ParseLfsDf parses the output of "lfs df" or "lfs df -i" for a single
+// filesystem, such as:
+//
+//	UUID                   1K-blocks        Used   Available Use% Mounted on
+//	lustre-MDT0000_UUID      2210688       25760     1976160   2% /mnt/lustre[MDT:0]
+//	lustre-OST0000_UUID      3771392       16484     3540812   1% /mnt/lustre[OST:0]
+//
+//	filesystem_summary:      3771392       16484     3540812   1% /mnt/lustre
+//
+// and returns its summary line.
+func ParseLfsDf(output string) (*LfsDfSummary, error) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasSuffix(fields[0], "_summary:") {
+			continue
+		}
+
+		values := make([]int64, 3)
+		for i := range values {
+			v, err := strconv.ParseInt(fields[i+1], 10, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid value %q in lfs df summary %q", fields[i+1], line)
+			}
+			values[i] = v
+		}
+
+		return &LfsDfSummary{Total: values[0], Used: values[1], Available: values[2]}, nil
+	}
+
+	return nil, fmt.Errorf("no summary in lfs df output %q", output)
+}
+
+// LfsQuota is the usage and limits of a quota from "lfs quota -q", in KiB and
+// inodes. Limits of 0 are unlimited.
+type LfsQuota struct {
+	UsedKiB      int64
+	SoftLimitKiB int64
+	HardLimitKiB int64
+	Files        int64
+	SoftLimit    int64
+	HardLimit    int64
+	// lfs marks usage that is over a limit with a '*'
+	Exceeded bool
+}
+
+// ParseLfsQuota parses the output of "lfs quota -q" for a single filesystem,
+// such as:
+//
+//	/mnt/lustre   1024*   0   1000   6d23h59m57s   12   0   100   -
+//
+// lfs puts the values on the next line if the mount point is long.
+func ParseLfsQuota(output string) (*LfsQuota, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 9 {
+		return nil, fmt.Errorf("invalid lfs quota output %q", output)
+	}
+
+	quota := &LfsQuota{}
+	values := []*int64{
+		&quota.UsedKiB, &quota.SoftLimitKiB, &quota.HardLimitKiB, nil,
+		&quota.Files, &quota.SoftLimit, &quota.HardLimit, nil,
+	}
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		field := fields[i+1]
+		if strings.HasSuffix(field, "*") {
+			quota.Exceeded = true
+			field = strings.TrimSuffix(field, "*")
+		}
+		v, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid value %q in lfs quota output %q", fields[i+1], output)
+		}
+		*value = v
+	}
+
+	if (quota.HardLimitKiB != 0 && quota.UsedKiB >= quota.HardLimitKiB) ||
+		(quota.HardLimit != 0 && quota.Files >= quota.HardLimit) {
+		quota.Exceeded = true
+	}
+
+	return quota, nil
+}
+
+// ParseLfsProject parses the output of "lfs project -d" for a directory, such
+// as "   1000 P /mnt/lustre/volume", and returns its project ID.
+func ParseLfsProject(output string) (uint32, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 3 {
+		return 0, fmt.Errorf("invalid lfs project output %q", output)
+	}
+
+	id, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid project ID %q in lfs project output %q", fields[0], output)
+	}
+	return uint32(id), nil
+}
+
+// Lustre stripe sizes are multiples of 64 KiB.
+const stripeSizeUnit = 64 * 1024
+
+// ParseStripeSize parses a Lustre stripe size in the form that lfs setstripe
+// accepts, bytes with an optional K, M or G suffix for KiB, MiB or GiB, and
+// returns it in bytes. It must be less than 4 GiB.
+func ParseStripeSize(size string) (int64, error) {
+	number, shift := size, 0
+	if len(size) != 0 {
+		switch size[len(size)-1] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		}
+	}
+	if shift != 0 {
+		number = size[:len(size)-1]
+	}
+
+	v, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || v <= 0 || v >= (1<<32)>>shift {
+		return 0, fmt.Errorf("invalid stripe size %q", size)
+	}
+	v <<= shift
+	if v%stripeSizeUnit != 0 {
+		return 0, fmt.Errorf("stripe size %q is not a multiple of 64K", size)
+	}
+
+	return v, nil
+}
+
+// HSM flags of a file, from lustre_user.h
+const (
+	HsmExists    = 0x00000001
+	HsmDirty     = 0x00000002
+	HsmReleased  = 0x00000004
+	HsmArchived  = 0x00000008
+	HsmNoRelease = 0x00000010
+	HsmNoArchive = 0x00000020
+	HsmLost      = 0x00000040
+)
+
+// LfsHsmState is the HSM state of a file from "lfs hsm_state".
+type LfsHsmState struct {
+	Flags     uint32
+	ArchiveID uint32
+}
+
+// Has reports whether the state has all of the given flags.
+func (s LfsHsmState) Has(flags uint32) bool {
+	return s.Flags&flags == flags
+}
+
+// ParseLfsHsmState parses the output of "lfs hsm_state" for one or more
+// files, such as:
+//
+//	/mnt/lustre/volume/a: (0x0000000d) released exists archived, archive_id:1
+//	/mnt/lustre/volume/b: (0x00000000)
+//
+// and returns the state of each file by its path.
+func ParseLfsHsmState(output string) (map[string]LfsHsmState, error) {
+	states := map[string]LfsHsmState{}
+	for _, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		i := strings.LastIndex(line, ": (0x")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid lfs hsm_state line %q", line)
+		}
+		path, rest := line[:i], line[i+len(": (0x"):]
+		hex, rest, ok := strings.Cut(rest, ")")
+		if !ok {
+			return nil, fmt.Errorf("invalid lfs hsm_state line %q", line)
+		}
+		flags, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flags %q in lfs hsm_state line %q", hex, line)
+		}
+
+		state := LfsHsmState{Flags: uint32(flags)}
+		if _, id, ok := strings.Cut(rest, "archive_id:"); ok {
+			v, err := strconv.ParseUint(strings.TrimSpace(id), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid archive ID %q in lfs hsm_state line %q", id, line)
+			}
+			state.ArchiveID = uint32(v)
+		}
+		states[path] = state
+	}
+
+	return states, nil
+}
+
+// ParseLfsHsmAction parses the output of "lfs hsm_action" for one or more
+// files, such as:
+//
+//	/mnt/lustre/volume/a: NOOP
+//	/mnt/lustre/volume/b: ARCHIVE running (1048576 bytes moved)
+//
+// and returns the HSM action that is in progress on each file by its path,
+// which is NOOP if there is none.
+func ParseLfsHsmAction(output string) (map[string]string, error) {
+	actions := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		i := strings.LastIndex(line, ": ")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid lfs hsm_action line %q", line)
+		}
+		fields := strings.Fields(line[i+2:])
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid lfs hsm_action line %q", line)
+		}
+		actions[line[:i]] = fields[0]
+	}
+
+	return actions, nil
+}
+
+// LctlStat is a counter of a Lustre stats file, such as llite.*.stats.
+type LctlStat struct {
+	Samples int64
+	// Unit of the sum, such as "bytes", "usecs" or "reqs"
+	Unit string
+	// Sum of the samples, if the counter keeps one
+	Sum int64
+}
+
+// ParseLctlStats parses a Lustre stats file from "lctl get_param -n", such as:
+//
+//	snapshot_time             1708471325.123456789 secs.nsecs
+//	read_bytes                1024 samples [bytes] 4096 1048576 536870912
+//	pcc_attach                3 samples [reqs]
+//
+// and returns its counters by name. Lines that are not counters, such as the
+// times, are skipped.
+func ParseLctlStats(output string) (map[string]LctlStat, error) {
+	stats := map[string]LctlStat{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "samples" {
+			continue
+		}
+
+		samples, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || samples < 0 {
+			return nil, fmt.Errorf("invalid samples %q in stats line %q", fields[1], line)
+		}
+		stat := LctlStat{Samples: samples, Unit: strings.Trim(fields[3], "[]")}
+		if len(fields) >= 7 {
+			if stat.Sum, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid sum %q in stats line %q", fields[6], line)
+			}
+		}
+		stats[fields[0]] = stat
+	}
+
+	return stats, nil
+}
+
+// ParseLctlParams parses the output of "lctl get_param" for parameters with
+// single-line values, such as:
+//
+//	osc.lustre-OST0000-osc-ffff8f6a4d6e8000.max_pages_per_rpc=1024
+//	osc.lustre-OST0001-osc-ffff8f6a4d6e8000.max_pages_per_rpc=1024
+//	jobid_var=procname_uid
+//
+// and returns the value of each parameter by name.
+func ParseLctlParams(output string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || len(name) == 0 || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid parameter line %q", line)
+		}
+		params[name] = strings.TrimSpace(value)
+	}
+
+	return params, nil
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLfsDf(t *testing.T) {
+	output := `UUID                   1K-blocks        Used   Available Use% Mounted on
+lushtx-MDT0000_UUID      2210688       25760     1976160   2% /tmp/volumes[MDT:0]
+lushtx-OST0000_UUID      3771392       16484     3540812   1% /tmp/volumes[OST:0]
+lushtx-OST0001_UUID      3771392       16484     3540812   1% /tmp/volumes[OST:1]
+
+filesystem_summary:      7542784       32968     7081624   1% /tmp/volumes
+
+`
+	summary, err := ParseLfsDf(output)
+	require.NoError(t, err)
+	assert.Equal(t, &LfsDfSummary{Total: 7542784, Used: 32968, Available: 7081624}, summary)
+
+	inodes := `UUID                      Inodes       IUsed       IFree IUse% Mounted on
+lushtx-MDT0000_UUID      1048576         272     1048304   1% /tmp/volumes[MDT:0]
+lushtx-OST0000_UUID       262144         262      261882   1% /tmp/volumes[OST:0]
+
+filesystem_summary:      1048576         272     1048304   1% /tmp/volumes
+`
+	summary, err = ParseLfsDf(inodes)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1048304), summary.Available)
+}
+
+func TestParseLfsDfInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"UUID                   1K-blocks        Used   Available Use% Mounted on\n",
+		"filesystem_summary:      7542784       -1     7081624   1% /tmp/volumes\n",
+		"filesystem_summary:      7542784       32968     lots   1% /tmp/volumes\n",
+	}
+
+	for _, output := range tests {
+		_, err := ParseLfsDf(output)
+		assert.Error(t, err, output)
+	}
+}
+
+func TestParseLfsQuota(t *testing.T) {
+	tests := []struct {
+		desc   string
+		output string
+		quota  *LfsQuota
+	}{
+		{
+			desc:   "within limits",
+			output: "     /tmp/volumes     512       0    1000       -      12       0     100       -\n",
+			quota:  &LfsQuota{UsedKiB: 512, HardLimitKiB: 1000, Files: 12, HardLimit: 100},
+		},
+		{
+			desc:   "over block limit",
+			output: "/tmp/volumes   1024*   0   1000   -   12   0   100   -\n",
+			quota:  &LfsQuota{UsedKiB: 1024, HardLimitKiB: 1000, Files: 12, HardLimit: 100, Exceeded: true},
+		},
+		{
+			desc:   "at inode limit",
+			output: "/tmp/volumes   4   0   0   -   100   0   100   -\n",
+			quota:  &LfsQuota{UsedKiB: 4, Files: 100, HardLimit: 100, Exceeded: true},
+		},
+		{
+			desc:   "long mount point",
+			output: "/var/lib/lustre-csi/working/volumes\n   4   0   0   -   1   0   0   -\n",
+			quota:  &LfsQuota{UsedKiB: 4, Files: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			quota, err := ParseLfsQuota(test.output)
+			require.NoError(t, err)
+			assert.Equal(t, test.quota, quota)
+		})
+	}
+
+	_, err := ParseLfsQuota("/tmp/volumes 4 0 0 -\n")
+	assert.Error(t, err)
+	_, err = ParseLfsQuota("/tmp/volumes 4 0 lots - 1 0 0 -\n")
+	assert.Error(t, err)
+}
+
+func TestParseLfsProject(t *testing.T) {
+	id, err := ParseLfsProject("   1000 P /tmp/volumes/csi-volumes/pvc-1\n")
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1000), id)
+
+	_, err = ParseLfsProject("lfs: failed to get xattr\n")
+	assert.Error(t, err)
+}
+
+func TestParseStripeSize(t *testing.T) {
+	tests := map[string]int64{
+		"65536": 65536,
+		"64K":   65536,
+		"1M":    1 << 20,
+		"4m":    4 << 20,
+		"1G":    1 << 30,
+	}
+	for size, expected := range tests {
+		v, err := ParseStripeSize(size)
+		require.NoError(t, err, size)
+		assert.Equal(t, expected, v, size)
+	}
+
+	for _, size := range []string{"", "M", "0", "-1M", "1000", "100K", "1T", "4G"} {
+		_, err := ParseStripeSize(size)
+		assert.Error(t, err, size)
+	}
+}
+
+func TestParseLfsHsmState(t *testing.T) {
+	output := `/tmp/volumes/pvc-1/a: (0x0000000d) released exists archived, archive_id:1
+/tmp/volumes/pvc-1/b: (0x0000000b) exists dirty archived, archive_id:2
+/tmp/volumes/pvc-1/c: (0x00000000)
+/tmp/volumes/pvc-1/d: (0x1): (0x00000001) exists, archive_id:0
+`
+	states, err := ParseLfsHsmState(output)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]LfsHsmState{
+		"/tmp/volumes/pvc-1/a":        {Flags: HsmExists | HsmReleased | HsmArchived, ArchiveID: 1},
+		"/tmp/volumes/pvc-1/b":        {Flags: HsmExists | HsmDirty | HsmArchived, ArchiveID: 2},
+		"/tmp/volumes/pvc-1/c":        {},
+		"/tmp/volumes/pvc-1/d: (0x1)": {Flags: HsmExists},
+	}, states)
+	assert.True(t, states["/tmp/volumes/pvc-1/a"].Has(HsmReleased|HsmArchived))
+	assert.False(t, states["/tmp/volumes/pvc-1/b"].Has(HsmReleased))
+
+	for _, output := range []string{
+		"lfs hsm_state: cannot get status\n",
+		"/tmp/volumes/a: (0xlots) exists\n",
+		"/tmp/volumes/a: (0x00000001 exists\n",
+		"/tmp/volumes/a: (0x00000009) exists archived, archive_id:x\n",
+	} {
+		_, err := ParseLfsHsmState(output)
+		assert.Error(t, err, output)
+	}
+}
+
+func TestParseLfsHsmAction(t *testing.T) {
+	output := `/tmp/volumes/pvc-1/a: NOOP
+/tmp/volumes/pvc-1/b: ARCHIVE running (1048576 bytes moved)
+/tmp/volumes/pvc-1/c: d: RESTORE waiting (0 bytes moved)
+`
+	actions, err := ParseLfsHsmAction(output)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/tmp/volumes/pvc-1/a":    "NOOP",
+		"/tmp/volumes/pvc-1/b":    "ARCHIVE",
+		"/tmp/volumes/pvc-1/c: d": "RESTORE",
+	}, actions)
+
+	for _, output := range []string{"lfs hsm_action failed\n", "/tmp/volumes/a: \n"} {
+		_, err := ParseLfsHsmAction(output)
+		assert.Error(t, err, output)
+	}
+}
+
+func TestParseLctlStats(t *testing.T) {
+	output := `snapshot_time             1708471325.123456789 secs.nsecs
+start_time                1708460000.000000000 secs.nsecs
+elapsed_time              11325.123456789 secs.nsecs
+read_bytes                1024 samples [bytes] 4096 1048576 536870912 281474976710656
+open                      12 samples [usecs] 1 100 200 5000
+pcc_attach                3 samples [reqs]
+`
+	stats, err := ParseLctlStats(output)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]LctlStat{
+		"read_bytes": {Samples: 1024, Unit: "bytes", Sum: 536870912},
+		"open":       {Samples: 12, Unit: "usecs", Sum: 200},
+		"pcc_attach": {Samples: 3, Unit: "reqs"},
+	}, stats)
+
+	_, err = ParseLctlStats("read_bytes lots samples [bytes] 1 2 3\n")
+	assert.Error(t, err)
+	_, err = ParseLctlStats("read_bytes 1 samples [bytes] 1 2 lots\n")
+	assert.Error(t, err)
+}
+
+func TestParseLctlParams(t *testing.T) {
+	output := `osc.lustre-OST0000-osc-ffff8f6a4d6e8000.max_pages_per_rpc=1024
+osc.lustre-OST0001-osc-ffff8f6a4d6e8000.max_pages_per_rpc=256
+jobid_var=procname_uid
+jobid_name=
+`
+	params, err := ParseLctlParams(output)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"osc.lustre-OST0000-osc-ffff8f6a4d6e8000.max_pages_per_rpc": "1024",
+		"osc.lustre-OST0001-osc-ffff8f6a4d6e8000.max_pages_per_rpc": "256",
+		"jobid_var":  "procname_uid",
+		"jobid_name": "",
+	}, params)
+
+	_, err = ParseLctlParams("error: get_param: param_path 'osc/*/max_pages_per_rpc': No such file or directory\n")
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// LnetConfig is the part of an LNet configuration, in the YAML form that
+// "lnetctl import" takes and "lnetctl export" prints, with the networks,
+// routes and discovery of the node:
+//
+//	net:
+//	    - net type: tcp
+//	      local NI(s):
+//	        - interfaces:
+//	              0: eth0
+//	route:
+//	    - net: o2ib1
+//	      gateway: 10.0.0.1@tcp
+//	global:
+//	    discovery: 1
+type LnetConfig struct {
+	Net    []LnetNet   `json:"net,omitempty"`
+	Route  []LnetRoute `json:"route,omitempty"`
+	Global *LnetGlobal `json:"global,omitempty"`
+}
+
+type LnetNet struct {
+	NetType  string   `json:"net type"`
+	LocalNIs []LnetNI `json:"local NI(s),omitempty"`
+}
+
+type LnetNI struct {
+	// Interfaces by index, such as {"0": "eth0"}
+	Interfaces map[string]string `json:"interfaces,omitempty"`
+}
+
+type LnetRoute struct {
+	Net     string `json:"net"`
+	Gateway string `json:"gateway"`
+	// Hops to the network, or -1 for the default
+	Hop *int `json:"hop,omitempty"`
+	// Priority of the route, where 0 is the highest
+	Priority *int `json:"priority,omitempty"`
+}
+
+type LnetGlobal struct {
+	// 1 if peers are discovered, 0 if not
+	Discovery *int `json:"discovery,omitempty"`
+}
+
+// ParseLnetConfig parses an LNet configuration. With strict, fields that
+// LnetConfig does not have are rejected, as in a configuration to import;
+// without it they are skipped, as in the output of "lnetctl net show",
+// "lnetctl route show" or "lnetctl global show", which also have the state of
+// the networks and routes.
+func ParseLnetConfig(data []byte, strict bool) (*LnetConfig, error) {
+	config := &LnetConfig{}
+	var err error
+	if strict {
+		err = yaml.UnmarshalStrict(data, config)
+	} else {
+		err = yaml.Unmarshal(data, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Interfaces returns the interfaces of the local NIs of a network, sorted.
+func (n *LnetNet) Interfaces() []string {
+	interfaces := []string{}
+	for _, ni := range n.LocalNIs {
+		for _, name := range ni.Interfaces {
+			interfaces = append(interfaces, name)
+		}
+	}
+	slices.Sort(interfaces)
+	return interfaces
+}
+
+// ParseLctlNIDs parses the output of "lctl list_nids", one NID per line, such
+// as:
+//
+//	0@lo
+//	10.1.1.20@tcp
+//
+// and returns the NIDs other than those of the loopback network.
+func ParseLctlNIDs(output string) ([]string, error) {
+	nids := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		nid := strings.TrimSpace(line)
+		if len(nid) == 0 {
+			continue
+		}
+		if !strings.Contains(nid, "@") || strings.ContainsAny(nid, " \t") {
+			return nil, fmt.Errorf("invalid NID %q", nid)
+		}
+		if strings.HasSuffix(nid, "@lo") {
+			continue
+		}
+		nids = append(nids, nid)
+	}
+	return nids, nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLnetConfig(t *testing.T) {
+	data := `net:
+    - net type: tcp
+      local NI(s):
+        - interfaces:
+              0: eth0
+        - interfaces:
+              0: eth1
+route:
+    - net: o2ib1
+      gateway: 10.0.0.1@tcp
+      hop: 1
+global:
+    discovery: 1
+`
+	config, err := ParseLnetConfig([]byte(data), true)
+	require.NoError(t, err)
+	require.Len(t, config.Net, 1)
+	assert.Equal(t, "tcp", config.Net[0].NetType)
+	assert.Equal(t, []string{"eth0", "eth1"}, config.Net[0].Interfaces())
+	require.Len(t, config.Route, 1)
+	assert.Equal(t, "o2ib1", config.Route[0].Net)
+	assert.Equal(t, "10.0.0.1@tcp", config.Route[0].Gateway)
+	require.NotNil(t, config.Route[0].Hop)
+	assert.Equal(t, 1, *config.Route[0].Hop)
+	assert.Nil(t, config.Route[0].Priority)
+	require.NotNil(t, config.Global)
+	require.NotNil(t, config.Global.Discovery)
+	assert.Equal(t, 1, *config.Global.Discovery)
+
+	show := `net:
+    - net type: lo
+      local NI(s):
+        - nid: 0@lo
+          status: up
+    - net type: tcp
+      local NI(s):
+        - nid: 10.1.1.20@tcp
+          status: up
+          interfaces:
+              0: eth0
+`
+	_, err = ParseLnetConfig([]byte(show), true)
+	assert.Error(t, err)
+	config, err = ParseLnetConfig([]byte(show), false)
+	require.NoError(t, err)
+	require.Len(t, config.Net, 2)
+	assert.Empty(t, config.Net[0].Interfaces())
+	assert.Equal(t, []string{"eth0"}, config.Net[1].Interfaces())
+}
+
+func TestParseLctlNIDs(t *testing.T) {
+	nids, err := ParseLctlNIDs("0@lo\n10.1.1.20@tcp\n10.2.1.20@o2ib1\n")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.1.1.20@tcp", "10.2.1.20@o2ib1"}, nids)
+
+	_, err = ParseLctlNIDs("opening /dev/lnet failed: No such device\n")
+	assert.Error(t, err)
+}
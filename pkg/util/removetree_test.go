@@ -0,0 +1,60 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveTree(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "volume")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0o755))
+	for i := range 20 {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a", fmt.Sprintf("f%d", i)), nil, 0o640))
+	}
+	require.NoError(t, os.Symlink("../a/f1", filepath.Join(dir, "a", "b", "link")))
+
+	var removed atomic.Int64
+	require.NoError(t, RemoveTree(context.Background(), dir, 4, func() { removed.Add(1) }))
+	assert.NoDirExists(t, dir)
+	assert.Equal(t, int64(20+1+3), removed.Load())
+
+	// Removing it again does nothing
+	require.NoError(t, RemoveTree(context.Background(), dir, 4, nil))
+}
+
+func TestRemoveTreeCancelled(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "volume")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "f"), nil, 0o640))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, RemoveTree(ctx, dir, 4, nil), context.Canceled)
+	assert.DirExists(t, dir)
+}
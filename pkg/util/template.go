@@ -0,0 +1,215 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Template is a string with variable references of the form
+// ${name|func|func:arg}, where each function is applied in turn to the value
+// of the variable. "$$" is a literal "$", and any other "$" is left as it is.
+//
+// The functions are:
+//
+//	lower       the value in lower case
+//	upper       the value in upper case
+//	truncate:N  the first N characters of the value
+//	hash        the first 8 hex digits of the SHA-256 of the value
+//	hash:N      the first N hex digits of the SHA-256 of the value
+//
+// A template is expanded in a single pass from left to right, and the values
+// of variables are never expanded themselves.
+type Template struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal  string
+	variable string
+	funcs    []templateFunc
+}
+
+type templateFunc struct {
+	name string
+	arg  int
+}
+
+const defaultHashLength = 8
+
+// ParseTemplate parses a template, checking its syntax and its functions.
+func ParseTemplate(s string) (*Template, error) {
+	t := &Template{}
+	literal := strings.Builder{}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			literal.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			literal.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable reference at %q", s[i:])
+			}
+			part, err := parseTemplateReference(s[i+2 : i+2+end])
+			if err != nil {
+				return nil, err
+			}
+
+			if literal.Len() != 0 {
+				t.parts = append(t.parts, templatePart{literal: literal.String()})
+				literal.Reset()
+			}
+			t.parts = append(t.parts, part)
+			i += 2 + end
+		default:
+			literal.WriteByte('$')
+		}
+	}
+
+	if literal.Len() != 0 {
+		t.parts = append(t.parts, templatePart{literal: literal.String()})
+	}
+
+	return t, nil
+}
+
+func parseTemplateReference(ref string) (templatePart, error) {
+	fields := strings.Split(ref, "|")
+	part := templatePart{variable: strings.TrimSpace(fields[0])}
+	if len(part.variable) == 0 {
+		return part, fmt.Errorf("variable reference ${%s} has no variable name", ref)
+	}
+	if strings.ContainsAny(part.variable, "${ ") {
+		return part, fmt.Errorf("invalid variable name %q", part.variable)
+	}
+
+	for _, field := range fields[1:] {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(field), ":")
+		fn := templateFunc{name: name}
+
+		switch name {
+		case "lower", "upper":
+			if hasArg {
+				return part, fmt.Errorf("function %q in ${%s} does not take an argument", name, ref)
+			}
+		case "truncate", "hash":
+			if !hasArg && name == "truncate" {
+				return part, fmt.Errorf("function %q in ${%s} requires a length", name, ref)
+			}
+			fn.arg = defaultHashLength
+			if hasArg {
+				n, err := strconv.Atoi(arg)
+				if err != nil || n <= 0 || (name == "hash" && n > 2*sha256.Size) {
+					return part, fmt.Errorf("invalid length %q for function %q in ${%s}", arg, name, ref)
+				}
+				fn.arg = n
+			}
+		default:
+			return part, fmt.Errorf("unknown function %q in ${%s}", name, ref)
+		}
+
+		part.funcs = append(part.funcs, fn)
+	}
+
+	return part, nil
+}
+
+// Variables returns the names of the variables that the template refers to, in
+// the order that they first appear.
+func (t *Template) Variables() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, p := range t.parts {
+		if len(p.variable) != 0 && !seen[p.variable] {
+			seen[p.variable] = true
+			names = append(names, p.variable)
+		}
+	}
+	return names
+}
+
+// Expand returns the template with each variable reference replaced by the
+// value of the variable. It is an error to refer to a variable that is not in
+// vars.
+func (t *Template) Expand(vars map[string]string) (string, error) {
+	result := strings.Builder{}
+
+	for _, p := range t.parts {
+		if len(p.variable) == 0 {
+			result.WriteString(p.literal)
+			continue
+		}
+
+		value, ok := vars[p.variable]
+		if !ok {
+			return "", fmt.Errorf("unknown variable %q", p.variable)
+		}
+		for _, fn := range p.funcs {
+			value = fn.apply(value)
+		}
+		result.WriteString(value)
+	}
+
+	return result.String(), nil
+}
+
+func (fn templateFunc) apply(value string) string {
+	switch fn.name {
+	case "lower":
+		return strings.ToLower(value)
+	case "upper":
+		return strings.ToUpper(value)
+	case "truncate":
+		runes := []rune(value)
+		if len(runes) > fn.arg {
+			return string(runes[:fn.arg])
+		}
+		return value
+	case "hash":
+		sum := sha256.Sum256([]byte(value))
+		return hex.EncodeToString(sum[:])[:fn.arg]
+	}
+	return value
+}
+
+// EscapeTemplate returns a template that expands to s.
+func EscapeTemplate(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// ExpandTemplate parses and expands a template in one step.
+func ExpandTemplate(s string, vars map[string]string) (string, error) {
+	t, err := ParseTemplate(s)
+	if err != nil {
+		return "", err
+	}
+	return t.Expand(vars)
+}
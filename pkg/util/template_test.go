@@ -0,0 +1,162 @@
+/*
+ * Copyright 2026 Hewlett Packard Enterprise Development LP
+ * Other additional copyright holders may be indicated within.
+ *
+ * The entirety of this work is licensed under the Apache License,
+ * Version 2.0 (the "License"); you may not use this file except
+ * in compliance with the License.
+ *
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	vars := map[string]string{
+		"pod.metadata.name":                 "Job-Runner-7f9c",
+		"pod.metadata.namespace":            "team-a",
+		"pod":                               "short",
+		"pod.labels.app.kubernetes.io/name": "solver",
+		"self":                              "${pod}",
+	}
+
+	tests := []struct {
+		desc     string
+		template string
+		expected string
+	}{
+		{
+			desc:     "no variables",
+			template: "scratch/data",
+			expected: "scratch/data",
+		},
+		{
+			desc:     "variables",
+			template: "${pod.metadata.namespace}/${pod.metadata.name}",
+			expected: "team-a/Job-Runner-7f9c",
+		},
+		{
+			desc:     "variable names that are prefixes of others",
+			template: "${pod}/${pod.metadata.name}/${pod}",
+			expected: "short/Job-Runner-7f9c/short",
+		},
+		{
+			desc:     "label key with slash",
+			template: "${pod.labels.app.kubernetes.io/name}",
+			expected: "solver",
+		},
+		{
+			desc:     "values are not expanded",
+			template: "${self}",
+			expected: "${pod}",
+		},
+		{
+			desc:     "lower",
+			template: "${pod.metadata.name|lower}",
+			expected: "job-runner-7f9c",
+		},
+		{
+			desc:     "upper",
+			template: "${pod.metadata.namespace|upper}",
+			expected: "TEAM-A",
+		},
+		{
+			desc:     "truncate",
+			template: "${pod.metadata.name|truncate:3}",
+			expected: "Job",
+		},
+		{
+			desc:     "truncate longer than the value",
+			template: "${pod|truncate:10}",
+			expected: "short",
+		},
+		{
+			desc:     "hash",
+			template: "${pod|hash}",
+			expected: "f9b0078b",
+		},
+		{
+			desc:     "hash with length",
+			template: "${pod|hash:4}",
+			expected: "f9b0",
+		},
+		{
+			desc:     "functions are applied in order",
+			template: "${pod.metadata.name|truncate:3|upper}",
+			expected: "JOB",
+		},
+		{
+			desc:     "escaped dollar",
+			template: "$${pod}",
+			expected: "${pod}",
+		},
+		{
+			desc:     "lone dollar",
+			template: "cost$/a$b$",
+			expected: "cost$/a$b$",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			result, err := ExpandTemplate(test.template, vars)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestExpandTemplateInvalid(t *testing.T) {
+	vars := map[string]string{"pod": "short"}
+
+	tests := []struct {
+		desc     string
+		template string
+	}{
+		{desc: "unknown variable", template: "${node.name}"},
+		{desc: "unterminated reference", template: "data/${pod"},
+		{desc: "empty reference", template: "${}"},
+		{desc: "nested reference", template: "${pod${pod}}"},
+		{desc: "unknown function", template: "${pod|reverse}"},
+		{desc: "truncate without length", template: "${pod|truncate}"},
+		{desc: "truncate with zero length", template: "${pod|truncate:0}"},
+		{desc: "hash too long", template: "${pod|hash:65}"},
+		{desc: "lower with argument", template: "${pod|lower:1}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := ExpandTemplate(test.template, vars)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestEscapeTemplate(t *testing.T) {
+	for _, s := range []string{"data", "${pod}", "$$", "a$b$", "$${pod}"} {
+		result, err := ExpandTemplate(EscapeTemplate(s), nil)
+		require.NoError(t, err)
+		assert.Equal(t, s, result)
+	}
+}
+
+func TestTemplateVariables(t *testing.T) {
+	tmpl, err := ParseTemplate("${b}/${a|lower}/$${c}/${b|hash}")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, tmpl.Variables())
+}